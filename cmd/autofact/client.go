@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
@@ -11,8 +14,10 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/mohae/autofact"
+	"github.com/mohae/autofact/command"
 	"github.com/mohae/autofact/conf"
 	"github.com/mohae/autofact/message"
+	"github.com/mohae/autofact/util"
 	"github.com/mohae/joefriday/cpu/cpuutil"
 	cpuutilf "github.com/mohae/joefriday/cpu/cpuutil/flat"
 	"github.com/mohae/joefriday/net/netusage"
@@ -29,6 +34,21 @@ import (
 
 const IDLen = 8
 
+// clientKinds are the message kinds the client understands.
+var clientKinds = []message.Kind{
+	message.EOT,
+	message.ClientConf,
+	message.Hello,
+	message.Command,
+	message.CommandReply,
+	message.Ack,
+	message.SysInfoJSON,
+	message.CPUUtilization,
+	message.LoadAvg,
+	message.MemInfo,
+	message.NetUsage,
+}
+
 // Client is anything that talks to the server.
 type Client struct {
 	// The Autofact Path
@@ -40,8 +60,21 @@ type Client struct {
 	// this lock is for everything except messages or other things that are
 	// already threadsafe.
 	mu sync.Mutex
+	// Transport is how the client talks to the server: TransportWebsocket
+	// or TransportHTTP.
+	Transport string
+	// Fingerprint identifies the client's host to the server so that the
+	// client keeps its ID if its configuration is lost.
+	Fingerprint message.Fingerprint
 	// The websocket connection that this client uses.
 	WS *websocket.Conn
+	// wmu serializes writes to WS.
+	wmu sync.Mutex
+	// httpClient is used by the HTTP transport; batch are the messages
+	// that will be posted next.
+	httpClient *http.Client
+	batchMu    sync.Mutex
+	batch      [][]byte
 	// Channel for outbound binary messages.  The message is assumed to be a
 	// websocket.Binary type
 	sendB       chan []byte
@@ -52,12 +85,44 @@ type Client struct {
 	idGen       snoflinga.Generator
 	// The func is assigned on creation as the implementation could change
 	// depending on where the output is directed and/or requested format.
+	// The collectors are passed their period so that they don't read the
+	// client's Collect, which is changed under the lock.
 	LoadAvg        func() ([]byte, error)
-	CPUUtilization func(chan struct{})
-	MemInfo        func(chan struct{})
-	NetUsage       func(chan struct{})
-	tsLayout       string //the layout for timestamps
-	useTS          bool
+	CPUUtilization func(chan struct{}, time.Duration)
+	MemInfo        func(chan struct{}, time.Duration)
+	NetUsage       func(chan struct{}, time.Duration)
+	// collectCh stops the collectors when closed; it is nil when the
+	// collectors aren't running.
+	collectCh chan struct{}
+	// unacked are the sent messages that the server hasn't acknowledged.
+	unacked *outbox
+	// endpoints are the servers to connect to, in order of preference;
+	// server is the index of the one the client is attached to.
+	endpoints []conf.Server
+	server    int
+	// proto is the protocol agreed on with the server.
+	proto message.Protocol
+	// Codec is the name of the preferred codec.  codec is the codec agreed
+	// on with the server; binary messages are encoded with it on write and
+	// decoded with it on read.
+	Codec string
+	codec message.Codec
+	// healthbeatPush is whether the server has asked for the healthbeat to
+	// be pushed instead of pulling it.
+	healthbeatPush bool
+	// relay, if the client is a relay, forwards the messages of the clients
+	// connected to it.
+	relay *relay
+	// bursting is whether a burst is in progress; burstPrior are the
+	// collection periods to restore when it ends and burstRunning is
+	// whether the collectors are to be left running.  burstGen identifies
+	// the current burst so that a replaced burst's end is ignored.
+	bursting     bool
+	burstPrior   conf.Collect
+	burstRunning bool
+	burstGen     uint64
+	tsLayout     string //the layout for timestamps
+	useTS        bool
 }
 
 func NewClient(c conf.Conn, useTS bool, l string) *Client {
@@ -67,16 +132,22 @@ func NewClient(c conf.Conn, useTS bool, l string) *Client {
 		// A really small buffer:
 		// TODO: rethink this vis-a-vis what happens when recipient isn't there
 		// or if it goes away during sending and possibly caching items to be sent.
-		sendB:    make(chan []byte, 8),
-		sendStr:  make(chan string, 8),
-		useTS:    useTS,
-		tsLayout: l,
+		sendB:      make(chan []byte, 8),
+		sendStr:    make(chan string, 8),
+		unacked:    newOutbox(MaxUnacked),
+		Transport:  TransportWebsocket,
+		httpClient: &http.Client{Timeout: HTTPTimeout},
+		useTS:      useTS,
+		tsLayout:   l,
 	}
 }
 
 // Connect handles connecting to the server and returns the connection status.
 // The client will attempt to connect until it has either succeeded or the
-// connection retry period has been exceeded.  A retry is done every 5 seconds.
+// connection retry period has been exceeded.  If a server can't be reached,
+// or the handshake with it fails, the client fails over to the next of its
+// servers; once all of them have been tried, it waits ConnectInterval before
+// retrying.  If every server has refused the client, it stops trying.
 //
 // If the client is already connected, nothing will be done.
 func (c *Client) Connect() bool {
@@ -86,6 +157,8 @@ func (c *Client) Connect() bool {
 	}
 	start := time.Now()
 	retryEnd := start.Add(c.ConnectPeriod.Duration)
+	first := c.currentServer()
+	refusedBy := make(map[int]bool)
 	// connect to server; retry until the retry period has expired
 	for {
 		if time.Now().After(retryEnd) {
@@ -97,86 +170,267 @@ func (c *Client) Connect() bool {
 			return false
 		}
 		err := c.DialServer()
-		if err == nil {
-			break
+		if err != nil {
+			log.Debug(
+				"failed: retrying...",
+				zap.String("op", "connect"),
+				zap.String("server", c.ServerURL.String()),
+			)
+		} else {
+			setDeadlines(c.WS)
+			var h *handshake
+			h, err = c.handshakeWS()
+			if err == nil {
+				log.Debug(
+					"success",
+					zap.String("op", "connect"),
+					zap.String("id", c.ServerURL.String()),
+				)
+				c.connected(h)
+				return true
+			}
+			c.WS.Close()
+			if reason, ok := refused(err); ok {
+				log.Error(
+					"connection refused by server: "+reason,
+					zap.String("op", "handshake"),
+					zap.String("server", c.ServerURL.String()),
+				)
+				refusedBy[c.currentServer()] = true
+				if len(refusedBy) == len(c.endpoints) {
+					return false
+				}
+			} else {
+				log.Error(
+					err.Error(),
+					zap.String("op", "handshake"),
+					zap.String("server", c.ServerURL.String()),
+				)
+			}
+		}
+		// try the next server; once all of them have been tried, wait
+		// before trying again.
+		if c.failover() == first {
+			time.Sleep(c.ConnectInterval.Duration)
 		}
-		time.Sleep(c.ConnectInterval.Duration)
-		log.Debug(
-			"failed: retrying...",
-			zap.String("op", "connect"),
-			zap.String("server", c.ServerURL.String()),
-		)
 	}
-	// Send the ID
-	err := c.WS.WriteMessage(websocket.TextMessage, c.Conn.ID)
+}
+
+// handshakeWS sends the Hello on the websocket and processes the handshake
+// messages until the EOT.
+func (c *Client) handshakeWS() (*handshake, error) {
+	var flake snoflinga.Flake
+	err := c.WS.WriteMessage(websocket.BinaryMessage, message.Serialize(flake, message.Hello, c.hello()))
 	if err != nil {
-		log.Error(
-			err.Error(),
-			zap.String("op", "send id"),
-			zap.String("id", string(c.Conn.ID)),
-		)
-		c.WS.Close()
-		return false
+		return nil, err
 	}
-
+	h := newHandshake()
 	// read messages until we get an EOT
-handshake:
 	for {
 		typ, p, err := c.WS.ReadMessage()
 		if err != nil {
-			log.Error(
-				err.Error(),
-				zap.String("op", "read message"),
-			)
-			c.WS.Close()
-			return false
+			return nil, err
 		}
 		switch typ {
 		case websocket.BinaryMessage:
-			// process according to message kind
-			msg := message.GetRootAsMessage(p, 0)
-			switch message.Kind(msg.Kind()) {
-			case message.ClientConf:
-				cnf := conf.GetRootAsClient(msg.DataBytes(), 0)
-				// If there's a new ID, persist it/
-				if bytes.Compare(c.Conn.ID, cnf.IDBytes()) != 0 {
-					c.Conn.ID = cnf.IDBytes() // save the ID; if it was an
-					c.Collect.HealthbeatPeriod.Set(cnf.HealthbeatPeriod())
-					c.Collect.CPUUtilizationPeriod.Set(cnf.CPUUtilizationPeriod())
-					c.Collect.MemInfoPeriod.Set(cnf.MemInfoPeriod())
-					c.Collect.NetUsagePeriod.Set(cnf.NetUsagePeriod())
-				}
-			case message.EOT:
-				break handshake
-			default:
-				log.Error("unknown message type received during handshake")
-				return false
+			done, err := c.handshakeMessage(h, p)
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return h, nil
 			}
 		case websocket.TextMessage:
 			fmt.Printf("%s\n", string(p))
 		default:
-			log.Error(
-				"unknown message type",
-				zap.Int("type", typ),
-				zap.Base64("message", p),
-			)
-			c.WS.Close()
-			return false
+			return nil, fmt.Errorf("unknown message type: %d", typ)
 		}
 	}
-	log.Debug(
-		"success",
-		zap.String("op", "connect"),
-		zap.String("id", c.ServerURL.String()),
-	)
+}
+
+// refused returns whether the error is the server refusing the client, e.g.
+// because its protocol is incompatible, and the server's reason.
+func refused(err error) (string, bool) {
+	switch e := err.(type) {
+	case *websocket.CloseError:
+		// an incompatible server closes the connection with the reason.
+		return e.Text, e.Code == websocket.CloseProtocolError
+	case statusError:
+		return e.msg, e.code < http.StatusInternalServerError
+	}
+	return "", false
+}
+
+// hello returns the serialized Hello: the ID and the supported protocol.
+func (c *Client) hello() []byte {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Warn(
+			err.Error(),
+			zap.String("op", "get hostname"),
+		)
+	}
+	hello := message.Protocol{
+		ID:          c.Conn.ID,
+		Version:     message.ProtocolVersion,
+		MinVersion:  message.HelloProtocolVersion,
+		Kinds:       clientKinds,
+		Codecs:      c.codecs(),
+		Fingerprint: c.Fingerprint,
+		Hostname:    host,
+		Labels:      c.Conn.AllLabels(),
+	}
+	return hello.Serialize()
+}
+
+// handshake is the state of a handshake with the server.
+type handshake struct {
+	proto message.Protocol
+	codec message.Codec
+	push  bool
+}
+
+func newHandshake() *handshake {
+	// the handshake is flatbuffer serialized until the agreed on protocol is
+	// received.
+	return &handshake{codec: message.Flatbuffers{}}
+}
+
+// handshakeMessage processes a binary message received during the handshake.
+// It returns true once the handshake is done, i.e. an EOT was received.
+func (c *Client) handshakeMessage(h *handshake, p []byte) (bool, error) {
+	p, err := h.codec.Decode(p)
+	if err != nil {
+		return false, fmt.Errorf("%s decode: %s", h.codec.Name(), err)
+	}
+	err = message.Verify(p)
+	if err != nil {
+		return false, err
+	}
+	// process according to message kind
+	msg := message.GetRootAsMessage(p, 0)
+	switch message.Kind(msg.Kind()) {
+	case message.Hello:
+		h.proto = message.GetProtocol(msg.DataBytes())
+		h.codec, err = message.GetCodec(h.proto.Codec())
+		if err != nil {
+			return false, err
+		}
+	case message.ClientConf:
+		cnf := conf.GetRootAsClient(msg.DataBytes(), 0)
+		h.push = util.ByteToBool(cnf.HealthbeatPush())
+		// If there's a new ID, persist it/
+		if bytes.Compare(c.Conn.ID, cnf.IDBytes()) != 0 {
+			c.Conn.ID = cnf.IDBytes() // save the ID; if it was an
+			c.mu.Lock()
+			// during a burst, the new periods are used once it ends.
+			collect := &c.Collect
+			if c.bursting {
+				collect = &c.burstPrior
+			}
+			collect.HealthbeatPeriod.Set(cnf.HealthbeatPeriod())
+			collect.CPUUtilizationPeriod.Set(cnf.CPUUtilizationPeriod())
+			collect.MemInfoPeriod.Set(cnf.MemInfoPeriod())
+			collect.NetUsagePeriod.Set(cnf.NetUsagePeriod())
+			c.mu.Unlock()
+		}
+	case message.EOT:
+		if h.proto.Version == 0 {
+			return false, errors.New("server did not send the agreed on protocol")
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("unexpected message received during handshake: %s", message.Kind(msg.Kind()))
+	}
+	return false, nil
+}
+
+// connected sets the client as connected using what was agreed on in the
+// handshake.
+func (c *Client) connected(h *handshake) {
 	c.mu.Lock()
 	c.isConnected = true
+	c.proto = h.proto
+	c.codec = h.codec
+	c.healthbeatPush = h.push
 	c.mu.Unlock()
 	// assume that the ID is now set: get a snowflake Generator
 	c.genLock.Lock()
 	c.idGen = snoflinga.New(c.Conn.ID)
 	c.genLock.Unlock()
-	return true
+	log.Info(
+		"attached to server",
+		zap.String("op", "connect"),
+		zap.String("server", c.Server()),
+	)
+	if c.relay != nil {
+		c.relay.Connected()
+	}
+}
+
+// setDeadlines sets the connection's read deadline and the ping and pong
+// handlers that extend it: if nothing, not even a ping or a pong, is received
+// from the other side before the deadline, the connection is considered dead.
+func setDeadlines(ws *websocket.Conn) {
+	ws.SetReadDeadline(time.Now().Add(autofact.PongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(autofact.PongWait))
+	})
+	ws.SetPingHandler(func(s string) error {
+		ws.SetReadDeadline(time.Now().Add(autofact.PongWait))
+		return ws.WriteControl(websocket.PongMessage, []byte(s), time.Now().Add(autofact.WriteWait))
+	})
+}
+
+// Ping pings the server every PingPeriod, while connected, so that a
+// half-open connection is detected even if the server doesn't ping.
+func (c *Client) Ping(doneCh chan struct{}) {
+	ticker := time.NewTicker(autofact.PingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !c.IsConnected() {
+				continue
+			}
+			err := c.WS.WriteControl(websocket.PingMessage, nil, time.Now().Add(autofact.WriteWait))
+			if err != nil {
+				log.Error(
+					err.Error(),
+					zap.String("op", "ping"),
+				)
+			}
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+// codecs returns the supported codecs with the preferred codec first.
+func (c *Client) codecs() []string {
+	names := []string{c.Codec}
+	for _, v := range message.CodecNames() {
+		if v != c.Codec {
+			names = append(names, v)
+		}
+	}
+	return names
+}
+
+// WriteBinaryMessage encodes the flatbuffer serialized message using the
+// agreed on codec and writes it to the connection.  This is safe for
+// concurrent use.
+func (c *Client) WriteBinaryMessage(p []byte) error {
+	c.mu.Lock()
+	codec := c.codec
+	c.mu.Unlock()
+	p, err := codec.Encode(p)
+	if err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.WS.WriteMessage(websocket.BinaryMessage, p)
 }
 
 func (c *Client) DialServer() error {
@@ -198,16 +452,20 @@ func (c *Client) MessageWriter(doneCh chan struct{}) {
 	for {
 		select {
 		case p, ok := <-c.sendB:
+			// The message is retained until the server acknowledges it; if
+			// the client isn't connected, it is sent after reconnecting.
+			if ok && c.Supports(message.Ack) {
+				c.retain(p)
+			}
 			// don't send if not connected
 			if !c.IsConnected() {
-				// TODO add write to db for persistence instead.
 				continue
 			}
 			if !ok {
 				c.WS.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			err := c.WS.WriteMessage(websocket.BinaryMessage, p)
+			err := c.WriteBinaryMessage(p)
 			if err != nil {
 				log.Error(
 					err.Error(),
@@ -219,18 +477,58 @@ func (c *Client) MessageWriter(doneCh chan struct{}) {
 	}
 }
 
+// retain keeps the message until the server acknowledges it.
+func (c *Client) retain(p []byte) {
+	id := message.GetRootAsMessage(p, 0).IDBytes()
+	if len(id) == 0 {
+		return
+	}
+	if c.unacked.Add(id, p) {
+		log.Warn(
+			"too many unacknowledged messages: oldest message dropped",
+			zap.String("op", "retain message"),
+		)
+	}
+}
+
+// resendUnacked queues the unacknowledged messages to be sent again.
+func (c *Client) resendUnacked() {
+	pending := c.unacked.Pending()
+	if len(pending) == 0 {
+		return
+	}
+	log.Debug(
+		"resending unacknowledged messages",
+		zap.String("op", "resend"),
+		zap.Int("count", len(pending)),
+	)
+	for _, p := range pending {
+		c.sendB <- p
+	}
+}
+
+// ConnectServer connects to the server using the client's transport and
+// returns the connection status.
+func (c *Client) ConnectServer() bool {
+	if c.Transport == TransportHTTP {
+		return c.ConnectHTTP()
+	}
+	return c.Connect()
+}
+
 func (c *Client) Reconnect() bool {
 	c.mu.Lock()
 	c.isConnected = false
 	c.mu.Unlock()
 	for i := 0; i < 4; i++ {
-		b := c.Connect()
+		b := c.ConnectServer()
 		if b {
 			log.Debug(
 				"reconnected",
 				zap.String("op", "reconnect"),
 				zap.String("server", c.ServerURL.String()),
 			)
+			go c.resendUnacked()
 			return b
 		}
 	}
@@ -241,13 +539,20 @@ func (c *Client) Listen(doneCh chan struct{}) {
 	// loop until there's a done signal
 	defer close(doneCh)
 	for {
+		c.WS.SetReadDeadline(time.Now().Add(autofact.PongWait))
 		typ, p, err := c.WS.ReadMessage()
 		if err != nil {
 			log.Error(
 				err.Error(),
 				zap.String("op", "read message"),
 			)
-			if _, ok := err.(*websocket.CloseError); !ok {
+			_, closed := err.(*websocket.CloseError)
+			// a timeout means the connection is dead, e.g. half-open.
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				c.WS.Close()
+				closed = true
+			}
+			if !closed {
 				return
 			}
 			log.Debug(
@@ -285,43 +590,37 @@ func (c *Client) Listen(doneCh chan struct{}) {
 					)
 					continue
 				}
-				err = c.WS.WriteMessage(websocket.BinaryMessage, c.NewMessage(message.LoadAvg, p))
-				if err != nil {
-					if _, ok := err.(*websocket.CloseError); !ok {
-						log.Error(
-							err.Error(),
-							zap.String("op", "write message"),
-							zap.String("type", "loadavg"),
-						)
-						return
-					}
-					log.Debug(
-						"connection closed: reconnecting",
-						zap.String("op", "write message"),
-						zap.String("type", "loadavg"),
-					)
-					connected := c.Reconnect()
-					if connected {
-						log.Debug(
-							"connection re-established",
-							zap.String("op", "write message"),
-							zap.String("type", "loadavg"),
-						)
-						continue
-					}
-					log.Error(
-						"reconnect failed",
-						zap.String("op", "write message"),
-						zap.String("type", "loadavg"),
-					)
-					return
-				}
-				c.WS.WriteMessage(websocket.TextMessage, c.NewMessage(message.LoadAvg, p))
+				c.sendB <- c.NewMessage(message.LoadAvg, p)
 				continue
 			}
 		case websocket.BinaryMessage:
-			// nothing right now
-			// TODO validate that nothing is thecorrect thing here
+			c.mu.Lock()
+			codec := c.codec
+			c.mu.Unlock()
+			p, err = codec.Decode(p)
+			if err != nil {
+				log.Error(
+					err.Error(),
+					zap.String("op", "decode message"),
+					zap.String("codec", codec.Name()),
+				)
+				continue
+			}
+			err = message.Verify(p)
+			if err != nil {
+				log.Error(
+					err.Error(),
+					zap.String("op", "verify message"),
+				)
+				continue
+			}
+			err = c.processBinaryMessage(p)
+			if err != nil {
+				log.Error(
+					err.Error(),
+					zap.String("op", "process binary message"),
+				)
+			}
 		case websocket.CloseMessage:
 			log.Debug(
 				"connection closed by remote: reconnecting",
@@ -341,6 +640,136 @@ func (c *Client) Listen(doneCh chan struct{}) {
 	}
 }
 
+// StartCollectors starts the CPU utilization, meminfo, and netusage
+// collectors.  They run until StopCollectors is called.  If they are already
+// running, nothing is done.
+func (c *Client) StartCollectors() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the collectors were started on purpose: they keep running after a
+	// burst.
+	c.burstRunning = true
+	c.startCollectors()
+}
+
+// startCollectors starts the collectors, with the current periods, if they
+// aren't running.  This does not do any locking; it is assumed that the
+// caller is properly managing the lock's state.
+func (c *Client) startCollectors() {
+	if c.collectCh != nil {
+		return
+	}
+	c.collectCh = make(chan struct{})
+	go c.CPUUtilization(c.collectCh, c.Collect.CPUUtilizationPeriod.Duration)
+	go c.MemInfo(c.collectCh, c.Collect.MemInfoPeriod.Duration)
+	go c.NetUsage(c.collectCh, c.Collect.NetUsagePeriod.Duration)
+}
+
+// StopCollectors stops the collectors.  The healthbeat is not affected.
+func (c *Client) StopCollectors() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.burstRunning = false
+	if !c.stopCollectors() {
+		return errors.New("collectors are not running")
+	}
+	return nil
+}
+
+// stopCollectors stops the collectors and returns whether they were running.
+// This does not do any locking; it is assumed that the caller is properly
+// managing the lock's state.
+func (c *Client) stopCollectors() bool {
+	if c.collectCh == nil {
+		return false
+	}
+	close(c.collectCh)
+	c.collectCh = nil
+	return true
+}
+
+// Burst collects the CPU utilization, meminfo, and netusage every period for
+// the duration d, starting the collectors if they aren't running.  Once the
+// burst ends, the prior collection periods are restored and collectors that
+// weren't running before the burst are stopped.  A burst replaces any burst
+// in progress; the state from before the first burst is the one that is
+// restored.  The burst is ended by the client so the periods
+// are restored even if the server has gone away.
+func (c *Client) Burst(period, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.bursting {
+		c.burstPrior = c.Collect
+		c.burstRunning = c.collectCh != nil
+		c.bursting = true
+	}
+	c.burstGen++
+	gen := c.burstGen
+	c.Collect.CPUUtilizationPeriod.Duration = period
+	c.Collect.MemInfoPeriod.Duration = period
+	c.Collect.NetUsagePeriod.Duration = period
+	c.stopCollectors()
+	c.startCollectors()
+	time.AfterFunc(d, func() { c.endBurst(gen) })
+	log.Info(
+		"burst started",
+		zap.String("op", "burst"),
+		zap.String("period", period.String()),
+		zap.String("duration", d.String()),
+	)
+}
+
+// endBurst ends the burst with the generation gen, restoring the prior
+// collection periods.  If the collectors were running before the burst, or
+// were started during it, and are still running, they are restarted so that
+// they use the restored periods; otherwise they are stopped.
+func (c *Client) endBurst(gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.bursting || gen != c.burstGen {
+		return
+	}
+	c.bursting = false
+	c.Collect = c.burstPrior
+	if c.stopCollectors() && c.burstRunning {
+		c.startCollectors()
+	}
+	log.Info(
+		"burst ended",
+		zap.String("op", "burst"),
+	)
+}
+
+// CollectNow sends a snapshot of the loadavg, CPU utilization, meminfo, and
+// netusage to the server.
+func (c *Client) CollectNow() error {
+	collectors := []struct {
+		k   message.Kind
+		get func() ([]byte, error)
+	}{
+		{message.LoadAvg, LoadAvgFB},
+		{message.CPUUtilization, cpuutilf.Get},
+		{message.MemInfo, memf.Get},
+		{message.NetUsage, netusagef.Get},
+	}
+	for _, v := range collectors {
+		p, err := v.get()
+		if err != nil {
+			return fmt.Errorf("%s: %s", v.k, err)
+		}
+		c.sendB <- c.NewMessage(v.k, p)
+	}
+	return nil
+}
+
+// Supports returns whether the protocol agreed on with the server supports
+// messages of kind k.
+func (c *Client) Supports(k message.Kind) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.proto.Supports(k)
+}
+
 // IsConnected returns if the client is connected.
 func (c *Client) IsConnected() bool {
 	c.mu.Lock()
@@ -350,13 +779,13 @@ func (c *Client) IsConnected() bool {
 
 // CPUUtilizationFB gets the CPU Utilization data on a ticker and queues the
 // serialized data on the send buffer.
-func (c *Client) CPUUtilizationFB(doneCh chan struct{}) {
+func (c *Client) CPUUtilizationFB(doneCh chan struct{}, period time.Duration) {
 	// An interval of 0 means don't collect meminfo
-	if c.Collect.CPUUtilizationPeriod.Int64() == 0 {
+	if period == 0 {
 		return
 	}
 	// ticker for cpu utilization data
-	cpuTicker, err := cpuutilf.NewTicker(time.Duration(c.Collect.CPUUtilizationPeriod.Int64()))
+	cpuTicker, err := cpuutilf.NewTicker(period)
 	if err != nil {
 		log.Error(
 			err.Error(),
@@ -388,13 +817,13 @@ func (c *Client) CPUUtilizationFB(doneCh chan struct{}) {
 
 // CPUUtilizationLocal gets the CPU Utilization data on a ticker and outputs
 // it to the local destination as JSON.
-func (c *Client) CPUUtilizationLocal(doneCh chan struct{}) {
+func (c *Client) CPUUtilizationLocal(doneCh chan struct{}, period time.Duration) {
 	// An interval of 0 means don't collect meminfo
-	if c.Collect.CPUUtilizationPeriod.Int64() == 0 {
+	if period == 0 {
 		return
 	}
 	// ticker for cpu utilization data
-	cpuTicker, err := cpuutil.NewTicker(time.Duration(c.Collect.CPUUtilizationPeriod.Int64()))
+	cpuTicker, err := cpuutil.NewTicker(period)
 	if err != nil {
 		log.Error(
 			err.Error(),
@@ -448,13 +877,13 @@ func (c *Client) CPUUtilizationLocal(doneCh chan struct{}) {
 
 // MemInfoFB gets the meminfo data on a ticker and queues the serialized data on
 // the send buffer.
-func (c *Client) MemInfoFB(doneCh chan struct{}) {
+func (c *Client) MemInfoFB(doneCh chan struct{}, period time.Duration) {
 	// An interval of 0 means don't collect meminfo
-	if c.Collect.MemInfoPeriod.Int64() == 0 {
+	if period == 0 {
 		return
 	}
 	// ticker for meminfo data
-	memTicker, err := memf.NewTicker(time.Duration(c.Collect.MemInfoPeriod.Int64()))
+	memTicker, err := memf.NewTicker(period)
 	if err != nil {
 		log.Error(
 			err.Error(),
@@ -485,13 +914,13 @@ func (c *Client) MemInfoFB(doneCh chan struct{}) {
 
 // MemInfoLocal gets the meminfo data on a ticker and outputs it to the local
 // destination as JSON.
-func (c *Client) MemInfoLocal(doneCh chan struct{}) {
+func (c *Client) MemInfoLocal(doneCh chan struct{}, period time.Duration) {
 	// An interval of 0 means don't collect meminfo
-	if c.Collect.MemInfoPeriod.Int64() == 0 {
+	if period == 0 {
 		return
 	}
 	// ticker for meminfo data
-	memTicker, err := mem.NewTicker(time.Duration(c.Collect.MemInfoPeriod.Int64()))
+	memTicker, err := mem.NewTicker(period)
 	if err != nil {
 		log.Error(
 			err.Error(),
@@ -545,13 +974,13 @@ func (c *Client) MemInfoLocal(doneCh chan struct{}) {
 
 // NetUsageFB gets the netusage data on a ticker and queues the serialized data
 // on the send buffer.
-func (c *Client) NetUsageFB(doneCh chan struct{}) {
+func (c *Client) NetUsageFB(doneCh chan struct{}, period time.Duration) {
 	// An interval of 0 means don't collect meminfo
-	if c.Collect.NetUsagePeriod.Int64() == 0 {
+	if period == 0 {
 		return
 	}
 	// ticker for network usage data
-	netTicker, err := netusagef.NewTicker(time.Duration(c.Collect.NetUsagePeriod.Int64()))
+	netTicker, err := netusagef.NewTicker(period)
 	if err != nil {
 		log.Error(
 			err.Error(),
@@ -583,13 +1012,13 @@ func (c *Client) NetUsageFB(doneCh chan struct{}) {
 
 // NetUsageLocal gets the netusage data on a ticker and outputs it to the local
 // destination as JSON.
-func (c *Client) NetUsageLocal(doneCh chan struct{}) {
+func (c *Client) NetUsageLocal(doneCh chan struct{}, period time.Duration) {
 	// An interval of 0 means don't collect meminfo
-	if c.Collect.NetUsagePeriod.Int64() == 0 {
+	if period == 0 {
 		return
 	}
 	// ticker for network usage data
-	netTicker, err := netusage.NewTicker(time.Duration(c.Collect.NetUsagePeriod.Int64()))
+	netTicker, err := netusage.NewTicker(period)
 	if err != nil {
 		log.Error(
 			err.Error(),
@@ -643,10 +1072,31 @@ func (c *Client) processBinaryMessage(p []byte) error {
 	switch k {
 	case message.ClientConf:
 		cl := conf.GetRootAsClient(msg.DataBytes(), 0)
-		c.Collect.HealthbeatPeriod.Set(cl.HealthbeatPeriod())
-		c.Collect.CPUUtilizationPeriod.Set(cl.CPUUtilizationPeriod())
-		c.Collect.MemInfoPeriod.Set(cl.MemInfoPeriod())
-		c.Collect.NetUsagePeriod.Set(cl.NetUsagePeriod())
+		c.mu.Lock()
+		c.healthbeatPush = util.ByteToBool(cl.HealthbeatPush())
+		// during a burst, the new periods are used once it ends.
+		collect := &c.Collect
+		if c.bursting {
+			collect = &c.burstPrior
+		}
+		collect.HealthbeatPeriod.Set(cl.HealthbeatPeriod())
+		collect.CPUUtilizationPeriod.Set(cl.CPUUtilizationPeriod())
+		collect.MemInfoPeriod.Set(cl.MemInfoPeriod())
+		collect.NetUsagePeriod.Set(cl.NetUsagePeriod())
+		c.mu.Unlock()
+	case message.Command:
+		c.Command(command.GetRootAsCommand(msg.DataBytes(), 0))
+	case message.Ack:
+		c.unacked.Ack(message.AckIDs(msg.DataBytes()))
+	case message.Relay:
+		if c.relay != nil {
+			c.relay.Deliver(msg.DstID(), msg.DataBytes())
+		}
+	case message.EOT:
+		// the server ended the session of a relayed client.
+		if c.relay != nil && msg.DstID() != 0 {
+			c.relay.End(msg.DstID(), string(msg.DataBytes()))
+		}
 	default:
 		log.Warn(
 			"unknown message kind",
@@ -657,6 +1107,104 @@ func (c *Client) processBinaryMessage(p []byte) error {
 	return nil
 }
 
+// Command runs the command sent by the server and replies with the result.
+func (c *Client) Command(cmd *command.Command) {
+	op := command.Op(cmd.Op())
+	log.Debug(
+		"command received",
+		zap.String("op", "run command"),
+		zap.String("command", op.String()),
+	)
+	var err error
+	switch op {
+	case command.CollectNow:
+		err = c.CollectNow()
+	case command.ResendSysInfo:
+		err = c.SystemInfoServerJSON()
+	case command.SetLogLevel:
+		err = SetLogLevel(string(cmd.Arg()))
+	case command.Reconnect:
+		reply := c.NewMessage(message.CommandReply, command.SerializeReply(cmd.IDBytes(), nil))
+		if c.Transport == TransportHTTP {
+			// There's no connection to close: a new session is started and
+			// the reply is posted in it.
+			c.sendB <- reply
+		} else {
+			// The reply has to be written before the connection is closed.
+			err = c.WriteBinaryMessage(reply)
+			if err != nil {
+				log.Error(
+					err.Error(),
+					zap.String("op", "write message"),
+					zap.String("type", "command reply"),
+				)
+			}
+			c.WS.Close()
+		}
+		if !c.Reconnect() {
+			log.Error(
+				"reconnect failed",
+				zap.String("op", "run command"),
+				zap.String("command", op.String()),
+			)
+		}
+		return
+	case command.StopCollectors:
+		err = c.StopCollectors()
+	case command.Burst:
+		var period, d time.Duration
+		period, d, err = command.ParseBurst(string(cmd.Arg()))
+		if err == nil {
+			c.Burst(period, d)
+		}
+	default:
+		err = fmt.Errorf("unsupported command: %s", op)
+	}
+	if err != nil {
+		log.Warn(
+			err.Error(),
+			zap.String("op", "run command"),
+			zap.String("command", op.String()),
+		)
+	}
+	c.sendB <- c.NewMessage(message.CommandReply, command.SerializeReply(cmd.IDBytes(), err))
+}
+
+// Healthbeat pushes the healthbeat, the loadavg, to the server every
+// HealthbeatPeriod if the server has asked for it to be pushed; otherwise the
+// server pulls it.
+func (c *Client) Healthbeat(doneCh chan struct{}) {
+	// If this was set to 0; don't do a healthbeat.
+	if c.Collect.HealthbeatPeriod.Int64() == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(c.Collect.HealthbeatPeriod.Int64()))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			push := c.healthbeatPush && c.isConnected
+			c.mu.Unlock()
+			if !push {
+				continue
+			}
+			p, err := c.LoadAvg()
+			if err != nil {
+				log.Error(
+					err.Error(),
+					zap.String("op", "healthbeat"),
+					zap.String("data", "loadavg"),
+				)
+				continue
+			}
+			c.sendB <- c.NewMessage(message.LoadAvg, p)
+		case <-doneCh:
+			return
+		}
+	}
+}
+
 // HealthbeatLocal gets the healthbeat on a ticker and saves it to the datalog.
 // This is only used when serverless.  The client's configured HealthbeatPeriod
 // is used for the ticker; for non-serverless environments that value is
@@ -756,9 +1304,8 @@ func (c *Client) SystemInfoServerless() {
 
 // SystemInfoServerJSON gathers information about the local system and sends it
 // to the server as JSON serialized bytes.  If an error occurs, it will be
-// logged and an error will be sent to the server; the client will continue
-// running.
-func (c *Client) SystemInfoServerJSON() {
+// logged and returned; the client will continue running.
+func (c *Client) SystemInfoServerJSON() error {
 	// If the node information is to be written do it now
 	var s systeminfo.System
 	err := s.Get()
@@ -767,7 +1314,7 @@ func (c *Client) SystemInfoServerJSON() {
 			err.Error(),
 			zap.String("op", "get systeminfo"),
 		)
-		return
+		return err
 	}
 	b, err := s.JSONMarshal()
 	if err != nil {
@@ -776,10 +1323,10 @@ func (c *Client) SystemInfoServerJSON() {
 			zap.String("op", "marshal json"),
 			zap.String("type", "systeminfo"),
 		)
-		return
+		return err
 	}
 	c.sendB <- c.NewMessage(message.SysInfoJSON, b)
-	return
+	return nil
 }
 
 // FormattedTime returns the nanoseconds as a formatted datetime string using
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mohae/autofact/conf"
+	"github.com/uber-go/zap"
+)
+
+func TestBurst(t *testing.T) {
+	defer func(l zap.Logger) { log = l }(log)
+	log = zap.New(zap.NewJSONEncoder(), zap.Output(zap.AddSync(ioutil.Discard)))
+	c := NewClient(conf.Conn{}, false, "")
+	c.Collect.CPUUtilizationPeriod.Duration = time.Minute
+	c.Collect.MemInfoPeriod.Duration = time.Minute
+	c.Collect.NetUsagePeriod.Duration = time.Minute
+	// the collectors report the period they were started with.
+	periods := make(chan time.Duration, 3)
+	collector := func(doneCh chan struct{}, period time.Duration) {
+		periods <- period
+		<-doneCh
+	}
+	c.CPUUtilization, c.MemInfo, c.NetUsage = collector, collector, collector
+	started := func(want time.Duration) {
+		for i := 0; i < 3; i++ {
+			select {
+			case got := <-periods:
+				if got != want {
+					t.Errorf("got period %s; want %s", got, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("want the collectors started with %s", want)
+			}
+		}
+	}
+
+	tests := []struct {
+		running bool
+	}{
+		{false},
+		{true},
+	}
+	for _, test := range tests {
+		if test.running {
+			c.StartCollectors()
+			started(time.Minute)
+		}
+		c.Burst(time.Second, time.Hour)
+		started(time.Second)
+		c.endBurst(c.burstGen)
+		if test.running {
+			// the collectors are restarted with the prior periods.
+			started(time.Minute)
+		}
+		if running := c.collectCh != nil; running != test.running {
+			t.Errorf("after the burst: got running %t; want %t", running, test.running)
+		}
+		if c.Collect.CPUUtilizationPeriod.Duration != time.Minute {
+			t.Errorf("after the burst: got period %s; want %s", c.Collect.CPUUtilizationPeriod.Duration, time.Minute)
+		}
+	}
+	c.StopCollectors()
+}
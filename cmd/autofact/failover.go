@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mohae/autofact"
+	"github.com/mohae/autofact/conf"
+	"github.com/uber-go/zap"
+)
+
+// SetServers sets the servers that the client connects to, in order of
+// preference, and uses the preferred one.
+func (c *Client) SetServers(servers []conf.Server) {
+	c.endpoints = servers
+	c.useServer(0)
+}
+
+// Server returns the address of the server that the client is attached to, or
+// will connect to next.
+func (c *Client) Server() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.endpoints[c.server].String()
+}
+
+// useServer sets the server, of the client's servers, to connect to.
+func (c *Client) useServer(i int) {
+	host := c.endpoints[i].String()
+	c.mu.Lock()
+	c.server = i
+	if c.Transport == TransportHTTP {
+		c.ServerURL = url.URL{Scheme: "http", Host: host}
+	} else {
+		c.ServerURL = url.URL{Scheme: "ws", Host: host, Path: "/client"}
+	}
+	c.mu.Unlock()
+}
+
+// currentServer returns the index of the server the client is attached to.
+func (c *Client) currentServer() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.server
+}
+
+// failover switches to the next of the client's servers and returns its
+// index.
+func (c *Client) failover() int {
+	c.mu.Lock()
+	i := (c.server + 1) % len(c.endpoints)
+	c.mu.Unlock()
+	if len(c.endpoints) > 1 {
+		log.Info(
+			"failing over",
+			zap.String("op", "connect"),
+			zap.String("server", c.endpoints[i].String()),
+		)
+	}
+	c.useServer(i)
+	return i
+}
+
+// Failback checks, every FailbackInterval, whether a server that is preferred
+// over the one the client is attached to is reachable; if it is, the client
+// reconnects to it.
+func (c *Client) Failback(doneCh chan struct{}) {
+	if len(c.endpoints) < 2 || c.FailbackInterval.Duration == 0 {
+		return
+	}
+	ticker := time.NewTicker(c.FailbackInterval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			current := c.currentServer()
+			if current == 0 || !c.IsConnected() {
+				continue
+			}
+			for i := 0; i < current; i++ {
+				conn, err := net.DialTimeout("tcp", c.endpoints[i].String(), autofact.WriteWait)
+				if err != nil {
+					log.Debug(
+						err.Error(),
+						zap.String("op", "failback"),
+						zap.String("server", c.endpoints[i].String()),
+					)
+					continue
+				}
+				conn.Close()
+				log.Info(
+					"failing back",
+					zap.String("op", "failback"),
+					zap.String("server", c.endpoints[i].String()),
+				)
+				c.useServer(i)
+				c.disconnect("failing back")
+				break
+			}
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+// disconnect ends the client's connection so that it reconnects.  A websocket
+// connection is closed gracefully: the client reconnects once the server has
+// acknowledged the close.  The HTTP transport reconnects before its next post.
+func (c *Client) disconnect(reason string) {
+	if c.Transport == TransportHTTP {
+		c.mu.Lock()
+		c.isConnected = false
+		c.mu.Unlock()
+		return
+	}
+	err := c.WS.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason), time.Now().Add(autofact.WriteWait))
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "close connection"),
+			zap.String("reason", reason),
+		)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mohae/autofact/conf"
+	"github.com/uber-go/zap"
+)
+
+// testServer returns the conf.Server for the host:port address.
+func testServer(t *testing.T, addr string) conf.Server {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf.Server{Address: host, Port: port}
+}
+
+func TestFailover(t *testing.T) {
+	defer func(l zap.Logger) { log = l }(log)
+	log = zap.New(zap.NewJSONEncoder(), zap.Output(zap.AddSync(ioutil.Discard)))
+	c := NewClient(conf.Conn{}, false, "")
+	c.SetServers([]conf.Server{
+		{Address: "a.example.com", Port: "8675"},
+		{Address: "b.example.com", Port: "8675"},
+		{Address: "c.example.com", Port: "8675"},
+	})
+	if c.Server() != "a.example.com:8675" {
+		t.Errorf("got %s; want the preferred server", c.Server())
+	}
+	// the servers are tried in order of preference, wrapping around to the
+	// preferred one.
+	for _, want := range []int{1, 2, 0, 1} {
+		i := c.failover()
+		if i != want {
+			t.Errorf("got server %d; want %d", i, want)
+		}
+		if c.ServerURL.Host != c.endpoints[want].String() {
+			t.Errorf("got URL %s; want the host %s", c.ServerURL.String(), c.endpoints[want])
+		}
+	}
+}
+
+func TestFailback(t *testing.T) {
+	defer func(l zap.Logger) { log = l }(log)
+	log = zap.New(zap.NewJSONEncoder(), zap.Output(zap.AddSync(ioutil.Discard)))
+	// the preferred server can't be reached; the other two can.
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := down.Addr().String()
+	down.Close()
+	var servers []conf.Server
+	servers = append(servers, testServer(t, downAddr))
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		servers = append(servers, testServer(t, l.Addr().String()))
+	}
+	c := NewClient(conf.Conn{}, false, "")
+	// with HTTP, disconnecting only marks the client as not connected.
+	c.Transport = TransportHTTP
+	c.FailbackInterval.Duration = 10 * time.Millisecond
+	c.SetServers(servers)
+	c.useServer(2)
+	c.isConnected = true
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go c.Failback(doneCh)
+	deadline := time.Now().Add(2 * time.Second)
+	for c.IsConnected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.IsConnected() {
+		t.Fatal("the client didn't fail back")
+	}
+	// the most preferred server that can be reached is used.
+	if i := c.currentServer(); i != 1 {
+		t.Errorf("got server %d; want 1", i)
+	}
+}
+
+func TestConnectFailover(t *testing.T) {
+	defer func(l zap.Logger) { log = l }(log)
+	log = zap.New(zap.NewJSONEncoder(), zap.Output(zap.AddSync(ioutil.Discard)))
+	upgrader := websocket.Upgrader{}
+	// newServer returns a server that accepts the connection and then
+	// either refuses the client or drops the connection during the
+	// handshake.
+	newServer := func(refuse bool, n *int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			atomic.AddInt32(n, 1)
+			if !refuse {
+				return
+			}
+			conn.ReadMessage()
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, "incompatible protocol"), time.Now().Add(time.Second))
+			conn.ReadMessage()
+		}))
+	}
+	tests := []struct {
+		refuseA bool
+		period  time.Duration
+	}{
+		// every server refuses the client: it stops trying before the
+		// retry period has been exceeded.
+		{true, 10 * time.Second},
+		// the first server fails the handshake: the client fails over to
+		// the second, which refuses it, and back again until the retry
+		// period has been exceeded.
+		{false, 300 * time.Millisecond},
+	}
+	for _, test := range tests {
+		var a, b int32
+		tsA := newServer(test.refuseA, &a)
+		tsB := newServer(true, &b)
+		c := NewClient(conf.Conn{}, false, "")
+		c.ConnectPeriod.Duration = test.period
+		c.ConnectInterval.Duration = 10 * time.Millisecond
+		c.SetServers([]conf.Server{
+			testServer(t, strings.TrimPrefix(tsA.URL, "http://")),
+			testServer(t, strings.TrimPrefix(tsB.URL, "http://")),
+		})
+		start := time.Now()
+		if c.Connect() {
+			t.Errorf("refuse a %t: got connected; want not connected", test.refuseA)
+		}
+		if test.refuseA && time.Since(start) >= test.period {
+			t.Error("refused by every server: got a timeout; want to stop trying")
+		}
+		if na, nb := atomic.LoadInt32(&a), atomic.LoadInt32(&b); na == 0 || nb == 0 {
+			t.Errorf("refuse a %t: got %d and %d connections; want both servers tried", test.refuseA, na, nb)
+		}
+		tsA.Close()
+		tsB.Close()
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/mohae/autofact/message"
+	"github.com/uber-go/zap"
+)
+
+// The identifiers that a host's fingerprint can be made of.
+const (
+	FingerprintMachineID   = "machine-id"
+	FingerprintProductUUID = "product-uuid"
+	FingerprintMAC         = "mac"
+)
+
+var (
+	// machineIDFiles are where the machine-id is read from, in order; older
+	// systems only have the dbus copy.
+	machineIDFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+	// productUUIDFile is where the DMI product UUID is read from; it is only
+	// readable by root.
+	productUUIDFile = "/sys/class/dmi/id/product_uuid"
+)
+
+// parseFingerprint parses a comma separated list of the identifiers to
+// fingerprint the host with.  none, or an empty list, doesn't fingerprint the
+// host.
+func parseFingerprint(s string) ([]string, error) {
+	var ids []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		switch v {
+		case "", "none":
+		case FingerprintMachineID, FingerprintProductUUID, FingerprintMAC:
+			ids = append(ids, v)
+		default:
+			return nil, fmt.Errorf("unknown fingerprint identifier: %q", v)
+		}
+	}
+	return ids, nil
+}
+
+// fingerprint returns the host's fingerprint made of the identifiers.  An
+// identifier that can't be read is left out.
+func fingerprint(ids []string) message.Fingerprint {
+	var f message.Fingerprint
+	for _, id := range ids {
+		var err error
+		switch id {
+		case FingerprintMachineID:
+			f.MachineID, err = machineID()
+		case FingerprintProductUUID:
+			f.ProductUUID, err = readID(productUUIDFile)
+		case FingerprintMAC:
+			f.MAC, err = primaryMAC()
+		}
+		if err != nil {
+			log.Warn(
+				err.Error(),
+				zap.String("op", "fingerprint"),
+				zap.String("identifier", id),
+			)
+		}
+	}
+	return f
+}
+
+// machineID returns the host's machine-id.
+func machineID() (string, error) {
+	var err error
+	for _, name := range machineIDFiles {
+		var id string
+		id, err = readID(name)
+		if err == nil {
+			return id, nil
+		}
+	}
+	return "", err
+}
+
+// readID returns the contents of the file, which must not be empty, without
+// surrounding whitespace.
+func readID(name string) (string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return "", fmt.Errorf("%s: empty", name)
+	}
+	return strings.ToLower(string(b)), nil
+}
+
+// primaryMAC returns the hardware address of the host's primary network
+// interface: the first interface that is up, isn't a loopback, and has a
+// hardware address.
+func primaryMAC() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 {
+			continue
+		}
+		return iface.HardwareAddr.String(), nil
+	}
+	return "", fmt.Errorf("no network interface with a hardware address")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseFingerprint(t *testing.T) {
+	tests := []struct {
+		s   string
+		ids []string
+		err bool
+	}{
+		{"machine-id", []string{FingerprintMachineID}, false},
+		{"machine-id, product-uuid,mac", []string{FingerprintMachineID, FingerprintProductUUID, FingerprintMAC}, false},
+		{"none", nil, false},
+		{"", nil, false},
+		{"machine-id,serial", nil, true},
+	}
+	for _, test := range tests {
+		ids, err := parseFingerprint(test.s)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error; got none", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%q: got %v; want %v", test.s, ids, test.ids)
+		}
+	}
+}
+
+func TestReadID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autofact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "machine-id")
+	err = ioutil.WriteFile(name, []byte("0123456789ABCDEF\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := readID(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if id != "0123456789abcdef" {
+		t.Errorf("got %q; want %q", id, "0123456789abcdef")
+	}
+	err = ioutil.WriteFile(name, []byte("\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readID(name)
+	if err == nil {
+		t.Error("empty file: expected an error; got none")
+	}
+}
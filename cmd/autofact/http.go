@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mohae/autofact"
+	"github.com/mohae/autofact/message"
+	"github.com/mohae/snoflinga"
+	"github.com/uber-go/zap"
+)
+
+// Transports: how the client talks to the server.
+const (
+	// TransportWebsocket keeps a websocket connection open to the server.
+	TransportWebsocket = "websocket"
+	// TransportHTTP posts batches of messages to the server; the server's
+	// messages for the client are in the responses.  This is for networks
+	// that don't allow long-lived connections.
+	TransportHTTP = "http"
+)
+
+var (
+	// PostInterval is how often a client using the HTTP transport posts its
+	// messages.  It must be less than the server's session timeout.
+	PostInterval = 5 * time.Second
+	// HTTPTimeout is the time limit for a request, including reading the
+	// response.
+	HTTPTimeout = 30 * time.Second
+)
+
+// statusError is a response, from the server, that isn't OK.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.msg)
+}
+
+// ConnectHTTP starts a session with the server using the HTTP transport and
+// returns the connection status.  Like Connect, the client fails over to its
+// other servers and the Hello is retried until the connection retry period
+// has been exceeded or every server has refused the Hello.
+//
+// If the client is already connected, nothing will be done.
+func (c *Client) ConnectHTTP() bool {
+	if c.IsConnected() {
+		return true
+	}
+	retryEnd := time.Now().Add(c.ConnectPeriod.Duration)
+	first := c.currentServer()
+	refusedBy := make(map[int]bool)
+	for {
+		if time.Now().After(retryEnd) {
+			log.Warn(
+				"timed out",
+				zap.String("op", "connect"),
+				zap.String("server", c.ServerURL.String()),
+			)
+			return false
+		}
+		h, err := c.helloHTTP()
+		if err == nil {
+			log.Debug(
+				"success",
+				zap.String("op", "connect"),
+				zap.String("id", c.ServerURL.String()),
+			)
+			c.connected(h)
+			return true
+		}
+		if reason, ok := refused(err); ok {
+			log.Error(
+				"connection refused by server: "+reason,
+				zap.String("op", "handshake"),
+				zap.String("server", c.ServerURL.String()),
+			)
+			refusedBy[c.currentServer()] = true
+			if len(refusedBy) == len(c.endpoints) {
+				return false
+			}
+		} else {
+			log.Debug(
+				"failed: retrying...",
+				zap.String("op", "connect"),
+				zap.String("server", c.ServerURL.String()),
+				zap.String("error", err.Error()),
+			)
+		}
+		// try the next server; once all of them have been tried, wait
+		// before trying again.
+		if c.failover() == first {
+			time.Sleep(c.ConnectInterval.Duration)
+		}
+	}
+}
+
+// helloHTTP sends the Hello and processes the handshake in the response.
+func (c *Client) helloHTTP() (*handshake, error) {
+	var flake snoflinga.Flake
+	msgs, err := c.post(autofact.HTTPHelloPath, [][]byte{message.Serialize(flake, message.Hello, c.hello())})
+	if err != nil {
+		return nil, err
+	}
+	h := newHandshake()
+	for _, p := range msgs {
+		done, err := c.handshakeMessage(h, p)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return h, nil
+		}
+	}
+	return nil, errors.New("handshake ended without an EOT")
+}
+
+// post posts the messages, as frames, to the server's path and returns the
+// messages in the response.
+func (c *Client) post(path string, msgs [][]byte) ([][]byte, error) {
+	var buf bytes.Buffer
+	message.WriteFrames(&buf, msgs)
+	u := c.ServerURL
+	u.Path = path
+	req, err := http.NewRequest("POST", u.String(), &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", message.FrameContentType)
+	req.Header.Set(autofact.HTTPClientHeader, string(c.Conn.ID))
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, statusError{code: resp.StatusCode, msg: strings.TrimSpace(string(b))}
+	}
+	return message.ReadFrames(resp.Body, message.DefaultMaxFrameSize)
+}
+
+// BatchWriter adds the outbound messages to the batch that is posted next;
+// it is MessageWriter for the HTTP transport.
+func (c *Client) BatchWriter(doneCh chan struct{}) {
+	for {
+		select {
+		case p := <-c.sendB:
+			// The message is retained until the server acknowledges it; if
+			// the client isn't connected, it is sent after reconnecting.
+			if c.Supports(message.Ack) {
+				c.retain(p)
+			}
+			// don't send if not connected
+			if !c.IsConnected() {
+				continue
+			}
+			c.batchMu.Lock()
+			c.batch = append(c.batch, p)
+			c.batchMu.Unlock()
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+// Poster posts the batch every PostInterval, even if it is empty, and
+// processes the server's messages in the response.  If a post fails, the
+// client reconnects before the next one; the unacknowledged messages are
+// then sent again.  doneCh is closed if the client can't reconnect.
+func (c *Client) Poster(doneCh chan struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(PostInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !c.IsConnected() && !c.Reconnect() {
+			log.Error(
+				"reconnect failed",
+				zap.String("op", "post messages"),
+			)
+			return
+		}
+		c.batchMu.Lock()
+		batch := c.batch
+		c.batch = nil
+		c.batchMu.Unlock()
+		err := c.postMessages(batch)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "post messages"),
+				zap.Int("count", len(batch)),
+			)
+			// reconnect before the next post, e.g. a 401 means that the
+			// server doesn't have a session for the client because it was
+			// restarted or the session timed out: a Hello starts a new one.
+			c.mu.Lock()
+			c.isConnected = false
+			c.mu.Unlock()
+		}
+	}
+}
+
+// postMessages encodes the messages using the agreed on codec, posts them,
+// and processes the messages in the response.
+func (c *Client) postMessages(batch [][]byte) error {
+	c.mu.Lock()
+	codec := c.codec
+	c.mu.Unlock()
+	msgs := make([][]byte, 0, len(batch))
+	for _, p := range batch {
+		p, err := codec.Encode(p)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "encode message"),
+				zap.String("codec", codec.Name()),
+			)
+			continue
+		}
+		msgs = append(msgs, p)
+	}
+	msgs, err := c.post(autofact.HTTPMessagesPath, msgs)
+	if err != nil {
+		return err
+	}
+	for _, p := range msgs {
+		p, err = codec.Decode(p)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "decode message"),
+				zap.String("codec", codec.Name()),
+			)
+			continue
+		}
+		err = message.Verify(p)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "verify message"),
+			)
+			continue
+		}
+		c.processBinaryMessage(p)
+	}
+	return nil
+}
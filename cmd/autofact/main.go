@@ -3,7 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net/url"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -11,6 +11,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/mohae/autofact/conf"
+	"github.com/mohae/autofact/message"
 	"github.com/mohae/autofact/util"
 	czap "github.com/mohae/zap"
 	"github.com/uber-go/zap"
@@ -36,6 +37,19 @@ var (
 	serverless bool
 	startInfo  bool
 	dataLevel  = "data"
+
+	// the preferred codec
+	codecName string
+	// how to talk to the server
+	transport string
+	// the address to accept relayed clients on; relay mode is off if empty
+	relayAddress string
+	// the servers to connect to, in order of preference, as a comma
+	// separated list of host:port
+	serverList string
+	// the identifiers to fingerprint the host with, as a comma separated
+	// list
+	fingerprintIDs string
 )
 
 // Vars for logging and local data output, if applicable.
@@ -61,15 +75,21 @@ func init() {
 	flag.StringVar(&connConf.ServerAddress, aVar, "127.0.0.1", "the server address (short)")
 	flag.StringVar(&connConf.ServerPort, portVar, "8675", "the connection port")
 	flag.StringVar(&connConf.ServerPort, pVar, "8675", "the connection port (short)")
+	flag.StringVar(&serverList, "servers", "", "comma separated list of the servers, as host:port, in order of preference; the client fails over to the next server when one can't be reached")
 	flag.StringVar(&logOut, "logout", "stderr", "log output; if empty stderr will be used")
 	flag.StringVar(&logOut, "l", "stderr", "log output; if empty stderr will be used")
 	flag.StringVar(&dataOut, "dataout", "stdout", "serverless mode data output, if empty stderr will be used")
 	flag.StringVar(&dataOut, "d", "stdout", "serverless mode data output, if empty stderr will be used")
 	flag.StringVar(&tsLayout, "tslayout", "epoch", "for serverless output, the layout of the time output. See https://golang.org/pkg/time/#time.Constants.")
 	flag.BoolVar(&serverless, "serverless", false, "serverless: the client will run standalone and write the collected data to the log")
+	flag.StringVar(&codecName, "codec", message.CodecFlatbuffers, "the preferred codec: flatbuffers or protobuf; the server may agree on another one")
+	flag.StringVar(&relayAddress, "relay", "", "relay mode: the address, e.g. :8675, to accept the connections of other clients on; they are forwarded to the server over this client's connection")
+	flag.StringVar(&transport, "transport", TransportWebsocket, "how to talk to the server: websocket or http; http posts batches of messages for networks that don't allow long-lived connections")
+	flag.StringVar(&fingerprintIDs, "fingerprint", FingerprintMachineID, "comma separated list of the identifiers the server recognizes this host by if its ID is lost: machine-id, product-uuid, and mac; none doesn't fingerprint the host")
 	flag.BoolVar(&startInfo, "startinfo", false, "when operating serverless the client's system info will be collected on app start")
 	connConf.ConnectInterval.Duration = 5 * time.Second
 	connConf.ConnectPeriod.Duration = 15 * time.Minute
+	connConf.FailbackInterval.Duration = 5 * time.Minute
 
 	// set custom level desc
 	czap.InfoString = "data"
@@ -117,9 +137,85 @@ func main() {
 
 	// TODO add env var support
 
+	// the servers flag replaces the servers in the conf; so does setting
+	// the address or port, which is a single server.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case addressVar, aVar, portVar, pVar:
+			connConf.Servers = nil
+		}
+	})
+	if serverList != "" {
+		connConf.Servers, err = conf.ParseServers(serverList)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "set servers"),
+			)
+			CloseOut() // defer doesn't run on exit
+			os.Exit(1)
+		}
+	}
+
+	_, err = message.GetCodec(codecName)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "set codec"),
+		)
+		CloseOut() // defer doesn't run on exit
+		os.Exit(1)
+	}
+
+	ids, err := parseFingerprint(fingerprintIDs)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "set fingerprint"),
+		)
+		CloseOut() // defer doesn't run on exit
+		os.Exit(1)
+	}
+
+	err = conf.ValidateLabels(connConf.AllLabels())
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "set labels"),
+		)
+		CloseOut() // defer doesn't run on exit
+		os.Exit(1)
+	}
+
+	if transport != TransportWebsocket && transport != TransportHTTP {
+		log.Error(
+			fmt.Sprintf("unknown transport: %q", transport),
+			zap.String("op", "set transport"),
+		)
+		CloseOut() // defer doesn't run on exit
+		os.Exit(1)
+	}
+	if relayAddress != "" && (serverless || transport != TransportWebsocket) {
+		log.Error(
+			"relay mode requires a websocket connection to the server",
+			zap.String("op", "set relay"),
+		)
+		CloseOut() // defer doesn't run on exit
+		os.Exit(1)
+	}
+
 	// get a client
 	c := NewClient(connConf, useTS, tsLayout)
 	c.AutoPath = autofactPath
+	c.Codec = codecName
+	c.Transport = transport
+	if !serverless {
+		c.Fingerprint = fingerprint(ids)
+	}
+	if relayAddress != "" {
+		c.relay = newRelay(c)
+		clientKinds = append(clientKinds, message.Relay)
+	}
 
 	// if serverless: load the collection configuration
 	if serverless {
@@ -156,11 +252,11 @@ func main() {
 
 	if !serverless { // connect to the server
 		// connect to the Server
-		c.ServerURL = url.URL{Scheme: "ws", Host: fmt.Sprintf("%s:%s", c.ServerAddress, c.ServerPort), Path: "/client"}
+		c.SetServers(c.Conn.Endpoints())
 
 		// must have a connection before doing anything
 		for i := 0; i < 3; i++ {
-			connected := c.Connect()
+			connected := c.ConnectServer()
 			if connected {
 				break
 			}
@@ -199,15 +295,41 @@ func main() {
 		c.MemInfo = c.MemInfoFB
 		c.NetUsage = c.NetUsageFB
 
-		// start the listener
-		go c.Listen(doneCh)
-		// start the message writer
-		go c.MessageWriter(doneCh)
+		if c.Transport == TransportHTTP {
+			// batch the messages and post them; the server's messages are
+			// in the responses.
+			go c.BatchWriter(doneCh)
+			go c.Poster(doneCh)
+		} else {
+			// start the listener
+			go c.Listen(doneCh)
+			// start the message writer
+			go c.MessageWriter(doneCh)
+			// keep the connection alive.
+			go c.Ping(doneCh)
+		}
+		// if the server asks for it, push the healthbeat; with the HTTP
+		// transport, it always does.
+		go c.Healthbeat(doneCh)
+		go c.Failback(doneCh)
+		if c.relay != nil {
+			go c.relay.Forward(doneCh)
+			// relayed clients connect to the relay like they would to the
+			// server.
+			mux := http.NewServeMux()
+			mux.Handle("/client", c.relay)
+			go func() {
+				err := http.ListenAndServe(relayAddress, mux)
+				log.Error(
+					err.Error(),
+					zap.String("op", "relay"),
+					zap.String("address", relayAddress),
+				)
+			}()
+		}
 	}
 
-	go c.CPUUtilization(doneCh)
-	go c.MemInfo(doneCh)
-	go c.NetUsage(doneCh)
+	c.StartCollectors()
 
 	<-doneCh
 }
@@ -237,6 +359,18 @@ newLog:
 	)
 }
 
+// SetLogLevel changes the log level.
+func SetLogLevel(s string) error {
+	var lvl zap.Level
+	err := lvl.UnmarshalText([]byte(s))
+	if err != nil {
+		return err
+	}
+	log.SetLevel(lvl)
+	*loglevel = lvl
+	return nil
+}
+
 func SetDataOut() {
 	var err error
 	if dataOut == "" || dataOut == "stdout" {
@@ -287,7 +421,7 @@ func handleSignals(c *Client) {
 		zap.Object("signal", v.String()),
 	)
 	// If there's a connection send a close signal
-	if c.IsConnected() {
+	if c.IsConnected() && c.WS != nil {
 		log.Debug(
 			"closing connection",
 			zap.String("op", "shutdown"),
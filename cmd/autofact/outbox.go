@@ -0,0 +1,99 @@
+package main
+
+import "sync"
+
+// MaxUnacked is the number of unacknowledged messages a client retains.  Once
+// it is reached, the oldest message is dropped to make room for a new one.
+var MaxUnacked = 8192
+
+// outbox holds the messages that have been queued for the server but haven't
+// been acknowledged by it.  The messages are kept in the order they were
+// added.
+type outbox struct {
+	mu  sync.Mutex
+	max int
+	// ids are the message IDs in the order they were added; acknowledged
+	// messages are removed from msgs and ids is trimmed lazily.
+	ids  []string
+	msgs map[string][]byte
+}
+
+func newOutbox(max int) *outbox {
+	return &outbox{
+		max:  max,
+		msgs: make(map[string][]byte),
+	}
+}
+
+// Add retains the message with the id until it is acknowledged.  If the
+// outbox is full, the oldest message is dropped and true is returned.
+func (o *outbox) Add(id []byte, p []byte) (dropped bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	k := string(id)
+	if _, ok := o.msgs[k]; ok {
+		return false
+	}
+	if len(o.msgs) >= o.max {
+		o.trim()
+		delete(o.msgs, o.ids[0])
+		o.ids = o.ids[1:]
+		dropped = true
+	}
+	o.ids = append(o.ids, k)
+	o.msgs[k] = p
+	return dropped
+}
+
+// Ack removes the acknowledged messages.
+func (o *outbox) Ack(ids [][]byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, id := range ids {
+		delete(o.msgs, string(id))
+	}
+	o.trim()
+	// if the acknowledgements were out of order, the ids may be mostly
+	// acknowledged messages.
+	if len(o.ids) > 2*len(o.msgs)+64 {
+		ids := make([]string, 0, len(o.msgs))
+		for _, k := range o.ids {
+			if _, ok := o.msgs[k]; ok {
+				ids = append(ids, k)
+			}
+		}
+		o.ids = ids
+	}
+}
+
+// Len returns the number of unacknowledged messages.
+func (o *outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.msgs)
+}
+
+// Pending returns the unacknowledged messages, oldest first.
+func (o *outbox) Pending() [][]byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	msgs := make([][]byte, 0, len(o.msgs))
+	for _, k := range o.ids {
+		if p, ok := o.msgs[k]; ok {
+			msgs = append(msgs, p)
+		}
+	}
+	return msgs
+}
+
+// trim removes the acknowledged messages from the front of ids.  This does not
+// do any locking; it is assumed that the caller is properly managing the
+// lock's state.
+func (o *outbox) trim() {
+	for len(o.ids) > 0 {
+		if _, ok := o.msgs[o.ids[0]]; ok {
+			return
+		}
+		o.ids = o.ids[1:]
+	}
+}
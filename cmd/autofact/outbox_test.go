@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestOutbox(t *testing.T) {
+	o := newOutbox(3)
+	for _, id := range []string{"1", "2", "3"} {
+		if o.Add([]byte(id), []byte("msg"+id)) {
+			t.Errorf("%s: unexpected drop", id)
+		}
+	}
+	// a retained message isn't added twice
+	o.Add([]byte("2"), []byte("msg2"))
+	if o.Len() != 3 {
+		t.Errorf("got %d messages; want 3", o.Len())
+	}
+	o.Ack([][]byte{[]byte("2")})
+	if o.Add([]byte("4"), []byte("msg4")) {
+		t.Error("unexpected drop")
+	}
+	if o.Add([]byte("4"), []byte("msg4")) {
+		t.Error("unexpected drop")
+	}
+	if !o.Add([]byte("5"), []byte("msg5")) {
+		t.Error("expected the oldest message to be dropped")
+	}
+	pending := o.Pending()
+	want := []string{"msg3", "msg4", "msg5"}
+	if len(pending) != len(want) {
+		t.Fatalf("got %d pending; want %d", len(pending), len(want))
+	}
+	for i, p := range pending {
+		if string(p) != want[i] {
+			t.Errorf("%d: got %s; want %s", i, p, want[i])
+		}
+	}
+	o.Ack([][]byte{[]byte("3"), []byte("4"), []byte("5"), []byte("6")})
+	if o.Len() != 0 || len(o.Pending()) != 0 || len(o.ids) != 0 {
+		t.Errorf("got %d messages, %d ids; want 0", o.Len(), len(o.ids))
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mohae/autofact"
+	"github.com/mohae/autofact/message"
+	"github.com/uber-go/zap"
+)
+
+// MaxRelayBuffer is the maximum number of messages, from relayed clients,
+// held while the server is unreachable; once there are more, the oldest are
+// dropped.
+var MaxRelayBuffer = 8192
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  autofact.ReadBufferSize,
+	WriteBufferSize: autofact.WriteBufferSize,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// relay accepts the connections of other agents, e.g. those in a network
+// segment that can't reach the server, and multiplexes them over the client's
+// connection to the server.  Each relayed client is identified, on that
+// connection, by its DstID.  The relayed clients' messages are forwarded as
+// is: their handshake, codec, and acknowledgements are between them and the
+// server.
+//
+// While the server is unreachable, the relayed clients' messages are held.
+// After reconnecting, the server doesn't know the relayed clients: each
+// client's Hello is sent again, before its next message, and the server's
+// reply to it is dropped.
+type relay struct {
+	c  *Client
+	mu sync.Mutex
+	// gen is incremented on each connection to the server.
+	gen     uint64
+	next    uint32
+	clients map[uint32]*relayClient
+	// queue holds the messages to forward, in order.
+	queue []relayFrame
+	// ready signals that there may be messages to forward.
+	ready chan struct{}
+}
+
+// relayClient is a client connected through the relay.
+type relayClient struct {
+	ws    *websocket.Conn
+	hello []byte
+	// gen is the connection to the server that the client's Hello was sent
+	// on; if it isn't the current one, the Hello has to be sent again.
+	gen uint64
+	// codec is the codec agreed on with the server; the server's replies are
+	// decoded with it to follow the handshake.
+	codec message.Codec
+	// handshook is whether the client's handshake is done; rehello is
+	// whether the server's replies to a Hello that was sent again are being
+	// dropped.
+	handshook bool
+	rehello   bool
+}
+
+// relayFrame is a message, from a relayed client, to forward.  A frame
+// without a message only makes sure that the server knows the client; an end
+// frame ends the client's session.
+type relayFrame struct {
+	dst uint32
+	p   []byte
+	end bool
+}
+
+func newRelay(c *Client) *relay {
+	return &relay{
+		c:       c,
+		clients: make(map[uint32]*relayClient),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// ServeHTTP accepts a client's connection.  The client starts the handshake
+// with a Hello, like it would with the server; the handshake and the rest of
+// the client's messages are forwarded to the server.
+func (r *relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ws, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "upgrade relay connection"),
+		)
+		return
+	}
+	defer ws.Close()
+	ws.SetReadLimit(message.DefaultMaxFrameSize)
+	setDeadlines(ws)
+	typ, p, err := ws.ReadMessage()
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "read relay hello"),
+		)
+		return
+	}
+	if typ == websocket.BinaryMessage {
+		err = message.Verify(p)
+	}
+	if typ != websocket.BinaryMessage || err != nil || message.Kind(message.GetRootAsMessage(p, 0).Kind()) != message.Hello {
+		closeRelayed(ws, websocket.CloseProtocolError, "expected a hello")
+		return
+	}
+	if !r.c.Supports(message.Relay) {
+		closeRelayed(ws, websocket.CloseProtocolError, "the server doesn't support relaying")
+		return
+	}
+	dst := r.add(ws, p)
+	log.Debug(
+		"client connected",
+		zap.String("op", "relay"),
+		zap.Uint64("dst", uint64(dst)),
+		zap.String("remote", req.RemoteAddr),
+	)
+	for {
+		typ, p, err = ws.ReadMessage()
+		if err != nil {
+			log.Debug(
+				err.Error(),
+				zap.String("op", "relay"),
+				zap.Uint64("dst", uint64(dst)),
+			)
+			break
+		}
+		// anything from the client extends the deadline.
+		ws.SetReadDeadline(time.Now().Add(autofact.PongWait))
+		if typ == websocket.BinaryMessage {
+			r.enqueue(relayFrame{dst: dst, p: p})
+		}
+	}
+	r.enqueue(relayFrame{dst: dst, end: true})
+}
+
+// add adds the client, with its Hello, and returns its DstID.
+func (r *relay) add(ws *websocket.Conn, hello []byte) uint32 {
+	r.mu.Lock()
+	for {
+		r.next++
+		// 0 isn't a valid DstID and DstIDs that are in use are skipped.
+		if _, ok := r.clients[r.next]; r.next != 0 && !ok {
+			break
+		}
+	}
+	dst := r.next
+	r.clients[dst] = &relayClient{ws: ws, hello: hello}
+	r.mu.Unlock()
+	// the empty frame sends the Hello.
+	r.enqueue(relayFrame{dst: dst})
+	return dst
+}
+
+// enqueue queues the frame to be forwarded.
+func (r *relay) enqueue(f relayFrame) {
+	r.mu.Lock()
+	if len(r.queue) >= MaxRelayBuffer {
+		// end frames are kept so that the sessions of clients that have
+		// disconnected are ended.
+		for i, v := range r.queue {
+			if !v.end {
+				r.queue = append(r.queue[:i], r.queue[i+1:]...)
+				break
+			}
+		}
+		log.Warn(
+			"too many messages held: oldest message dropped",
+			zap.String("op", "relay"),
+		)
+	}
+	r.queue = append(r.queue, f)
+	r.mu.Unlock()
+	r.signal()
+}
+
+func (r *relay) signal() {
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Connected is called when the client has connected to the server.  Until
+// their Hello is sent again, the server doesn't know the relayed clients.
+func (r *relay) Connected() {
+	r.mu.Lock()
+	r.gen++
+	r.mu.Unlock()
+	r.signal()
+}
+
+// Forward forwards the queued frames while the client is connected.
+func (r *relay) Forward(doneCh chan struct{}) {
+	for {
+		select {
+		case <-r.ready:
+			r.flush()
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+// flush forwards the queued frames until either the queue is empty or the
+// client isn't connected.  A frame that couldn't be forwarded is forwarded
+// after reconnecting.
+func (r *relay) flush() {
+	for r.c.IsConnected() {
+		r.mu.Lock()
+		if len(r.queue) == 0 {
+			r.mu.Unlock()
+			return
+		}
+		f := r.queue[0]
+		r.queue = r.queue[1:]
+		r.mu.Unlock()
+		err := r.send(f)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "relay"),
+				zap.Uint64("dst", uint64(f.dst)),
+			)
+			r.mu.Lock()
+			r.queue = append([]relayFrame{f}, r.queue...)
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// send sends the frame to the server.  If the server doesn't know the
+// frame's client, its Hello is sent first.
+func (r *relay) send(f relayFrame) error {
+	r.mu.Lock()
+	rc, ok := r.clients[f.dst]
+	gen := r.gen
+	known := ok && rc.gen == gen
+	if ok && f.end {
+		delete(r.clients, f.dst)
+	}
+	r.mu.Unlock()
+	if !ok { // the session has already ended.
+		return nil
+	}
+	if f.end {
+		if !known {
+			return nil
+		}
+		return r.c.WriteBinaryMessage(message.SerializeRelayEnd(f.dst, "client disconnected"))
+	}
+	if !known {
+		err := r.c.WriteBinaryMessage(message.SerializeRelay(f.dst, rc.hello))
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		rc.gen = gen
+		rc.rehello = rc.handshook
+		r.mu.Unlock()
+	}
+	if f.p == nil {
+		return nil
+	}
+	return r.c.WriteBinaryMessage(message.SerializeRelay(f.dst, f.p))
+}
+
+// Deliver writes a message from the server to the relayed client dst.
+func (r *relay) Deliver(dst uint32, p []byte) {
+	r.mu.Lock()
+	rc, ok := r.clients[dst]
+	drop := ok && r.handshake(rc, p)
+	r.mu.Unlock()
+	if !ok || drop {
+		return
+	}
+	err := rc.ws.WriteMessage(websocket.BinaryMessage, p)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "relay"),
+			zap.Uint64("dst", uint64(dst)),
+		)
+	}
+}
+
+// handshake follows the client's handshake and returns whether the message
+// should be dropped, i.e. it is the reply to a Hello that was sent again.
+// r.mu must be held.
+func (r *relay) handshake(rc *relayClient, p []byte) bool {
+	if rc.handshook && !rc.rehello {
+		return false
+	}
+	// the Hello is flatbuffer serialized; the rest of the handshake uses the
+	// agreed on codec.
+	codec := rc.codec
+	if codec == nil {
+		codec = message.Flatbuffers{}
+	}
+	b, err := codec.Decode(p)
+	if err == nil {
+		err = message.Verify(b)
+	}
+	if err != nil {
+		log.Warn(
+			err.Error(),
+			zap.String("op", "relay handshake"),
+		)
+		return rc.rehello
+	}
+	msg := message.GetRootAsMessage(b, 0)
+	switch message.Kind(msg.Kind()) {
+	case message.Hello:
+		proto := message.GetProtocol(msg.DataBytes())
+		codec, err = message.GetCodec(proto.Codec())
+		if err != nil {
+			log.Warn(
+				err.Error(),
+				zap.String("op", "relay handshake"),
+			)
+			break
+		}
+		rc.codec = codec
+	case message.EOT:
+		drop := rc.rehello
+		rc.handshook = true
+		rc.rehello = false
+		return drop
+	}
+	return rc.rehello
+}
+
+// End closes the connection of the relayed client dst, whose session the
+// server has ended, with the reason.  A client whose handshake isn't done was
+// refused by the server.
+func (r *relay) End(dst uint32, reason string) {
+	r.mu.Lock()
+	rc, ok := r.clients[dst]
+	delete(r.clients, dst)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	log.Debug(
+		"session ended by server: "+reason,
+		zap.String("op", "relay"),
+		zap.Uint64("dst", uint64(dst)),
+	)
+	code := websocket.CloseNormalClosure
+	if !rc.handshook {
+		code = websocket.CloseProtocolError
+	}
+	closeRelayed(rc.ws, code, reason)
+	rc.ws.Close()
+}
+
+// closeRelayed closes the relayed client's connection with the reason.
+func closeRelayed(ws *websocket.Conn, code int, reason string) {
+	err := ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(autofact.WriteWait))
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "close relay connection"),
+			zap.String("reason", reason),
+		)
+	}
+}
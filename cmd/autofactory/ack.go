@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+// Acknowledgement and deduplication settings.
+var (
+	// AckInterval is how often the received message IDs are acknowledged.
+	AckInterval = time.Second
+	// AckBatchSize is the number of received message IDs that are
+	// acknowledged without waiting for the AckInterval.
+	AckBatchSize = 256
+	// DedupWindow is how long a received message ID is remembered.  A
+	// message whose ID is remembered is a retransmit and is not processed
+	// again.
+	DedupWindow = 10 * time.Minute
+)
+
+// acker batches the acknowledgements of the messages received from a client.
+type acker struct {
+	mu  sync.Mutex
+	ids [][]byte
+	// send sends the acknowledgement of the ids.
+	send func(ids [][]byte) error
+}
+
+func newAcker(send func([][]byte) error) *acker {
+	return &acker{send: send}
+}
+
+// Add adds the message ID to the batch.  If the batch is full, it is sent.
+func (a *acker) Add(id []byte) {
+	// the id may be part of a reused read buffer.
+	cp := make([]byte, len(id))
+	copy(cp, id)
+	a.mu.Lock()
+	a.ids = append(a.ids, cp)
+	full := len(a.ids) >= AckBatchSize
+	a.mu.Unlock()
+	if full {
+		a.Flush()
+	}
+}
+
+// Flush sends the batch, if it isn't empty.  If the send fails, the IDs are
+// dropped; the client resends the unacknowledged messages and they are
+// acknowledged again.
+func (a *acker) Flush() error {
+	a.mu.Lock()
+	ids := a.ids
+	a.ids = nil
+	a.mu.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+	return a.send(ids)
+}
+
+// Run flushes the batch every interval until done is closed.
+func (a *acker) Run(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := a.Flush()
+			if err != nil {
+				log.Error(
+					err.Error(),
+					zap.String("op", "send acks"),
+				)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// dedup remembers the IDs of the received messages for at least its window.
+// The IDs are kept in two generations: once the current generation is older
+// than the window it becomes the previous generation and the old previous
+// generation is discarded.
+type dedup struct {
+	mu      sync.Mutex
+	window  time.Duration
+	cur     map[string]struct{}
+	prev    map[string]struct{}
+	rotated time.Time
+}
+
+func newDedup(window time.Duration) *dedup {
+	return &dedup{
+		window: window,
+		cur:    make(map[string]struct{}),
+		prev:   make(map[string]struct{}),
+	}
+}
+
+// Seen returns whether the message with the id, from the client, has already
+// been received.  If it hasn't, it is remembered.
+func (d *dedup) Seen(client string, id []byte, now time.Time) bool {
+	k := client + "/" + string(id)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.rotated) >= d.window {
+		d.prev = d.cur
+		d.cur = make(map[string]struct{})
+		d.rotated = now
+	}
+	if _, ok := d.cur[k]; ok {
+		return true
+	}
+	if _, ok := d.prev[k]; ok {
+		return true
+	}
+	d.cur[k] = struct{}{}
+	return false
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	d := newDedup(time.Minute)
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		client string
+		id     string
+		t      time.Time
+		seen   bool
+	}{
+		{"a", "1", start, false},
+		{"a", "1", start.Add(time.Second), true},
+		{"b", "1", start.Add(time.Second), false},
+		{"a", "2", start.Add(30 * time.Second), false},
+		// rotated: the first generation is still remembered
+		{"a", "1", start.Add(70 * time.Second), true},
+		{"a", "3", start.Add(80 * time.Second), false},
+		// rotated again: the first generation is forgotten
+		{"a", "1", start.Add(140 * time.Second), false},
+		{"a", "3", start.Add(141 * time.Second), true},
+	}
+	for i, test := range tests {
+		if seen := d.Seen(test.client, []byte(test.id), test.t); seen != test.seen {
+			t.Errorf("%d: %s/%s: got %t; want %t", i, test.client, test.id, seen, test.seen)
+		}
+	}
+}
+
+func TestAcker(t *testing.T) {
+	size := AckBatchSize
+	AckBatchSize = 3
+	defer func() { AckBatchSize = size }()
+	var sent [][][]byte
+	a := newAcker(func(ids [][]byte) error {
+		sent = append(sent, ids)
+		return nil
+	})
+	buf := []byte("1")
+	a.Add(buf)
+	// the ids must be copied
+	buf[0] = '2'
+	a.Add(buf)
+	if len(sent) != 0 {
+		t.Fatalf("got %d batches before the batch was full; want 0", len(sent))
+	}
+	a.Add([]byte("3"))
+	if len(sent) != 1 {
+		t.Fatalf("got %d batches; want 1", len(sent))
+	}
+	for i, want := range []string{"1", "2", "3"} {
+		if string(sent[0][i]) != want {
+			t.Errorf("%d: got %q; want %q", i, sent[0][i], want)
+		}
+	}
+	a.Add([]byte("4"))
+	a.Flush()
+	a.Flush()
+	if len(sent) != 2 || len(sent[1]) != 1 || string(sent[1][0]) != "4" {
+		t.Errorf("got %q; want a second batch of [4]", sent[1:])
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// AdminKeyHeader is the header with the admin key; requests that change the
+// alerting or send commands to clients are refused without it.
+const AdminKeyHeader = "X-Autofact-Admin-Key"
+
+// authorizeAdmin returns whether the request has the admin key.  If it
+// doesn't, or the server has no admin key, the request is refused and false
+// is returned.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if srvr.AdminKey == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(AdminKeyHeader)), []byte(srvr.AdminKey)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthorizeAdmin(t *testing.T) {
+	defer func(key string) { srvr.AdminKey = key }(srvr.AdminKey)
+	tests := []struct {
+		adminKey string
+		key      string
+		code     int
+	}{
+		// no admin key: the endpoints can't be used.
+		{"", "", http.StatusForbidden},
+		{"secret", "", http.StatusForbidden},
+		{"secret", "other", http.StatusForbidden},
+		// authorized: the unknown command is refused by the handler.
+		{"secret", "secret", http.StatusBadRequest},
+	}
+	for i, test := range tests {
+		srvr.AdminKey = test.adminKey
+		r := httptest.NewRequest("POST", "/api/commands", strings.NewReader(`{"client": "abc", "command": "Unknown"}`))
+		if test.key != "" {
+			r.Header.Set(AdminKeyHeader, test.key)
+		}
+		w := httptest.NewRecorder()
+		serveCommands(w, r)
+		if w.Code != test.code {
+			t.Errorf("%d: got status %d; want %d", i, w.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mohae/autofact/db"
+	"github.com/mohae/autofact/message"
+	"github.com/mohae/autofact/util"
+	"github.com/uber-go/zap"
+)
+
+// Alert states.  An alert is pending while its rule's condition holds but
+// for less than the rule's For duration.
+const (
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+var (
+	// ResolvedRetention is how long a resolved alert is kept before it is
+	// removed.
+	ResolvedRetention = 24 * time.Hour
+	// AlertPeriod is how often client down alerts are checked and
+	// notifications are sent.
+	AlertPeriod = 5 * time.Second
+	// DefaultClientDownFor is how long a client must be down before its
+	// alert fires.
+	DefaultClientDownFor = time.Minute
+)
+
+// ClientDownRule is the name of the rule of the alerts for clients that have
+// disconnected or have stopped responding to healthbeats.
+const ClientDownRule = "ClientDown"
+
+// measurementKinds are the message kinds, by measurement name, of the points
+// that rules can be evaluated against.
+var measurementKinds = map[string]message.Kind{
+	"cpus":       message.CPUUtilization,
+	"loadavg":    message.LoadAvg,
+	"memory":     message.MemInfo,
+	"interfaces": message.NetUsage,
+}
+
+// rules is the format of the rules file.
+type rules struct {
+	Rules      []*rule        `json:"rules"`
+	ClientDown clientDownConf `json:"client_down"`
+	Notify     notifyConf     `json:"notify"`
+}
+
+// clientDownConf configures the alerts for clients that are down.
+type clientDownConf struct {
+	Disabled bool `json:"disabled"`
+	// For is how long a client must be down before the alert fires.
+	For      util.Duration `json:"for"`
+	Severity string        `json:"severity,omitempty"`
+}
+
+// rule is an alert rule: an alert fires, for each series the rule matches,
+// once the series' value has exceeded the threshold for the For duration.
+// It is resolved once the value no longer exceeds the Clear level.
+type rule struct {
+	// Name is the unique name of the rule.
+	Name string `json:"name"`
+	// Metric is the measurement and field, e.g. cpus.usage.
+	Metric string `json:"metric"`
+	// Of is an optional field, of the same measurement, that the value is a
+	// percentage of, e.g. total_ram for free_ram.
+	Of string `json:"of,omitempty"`
+	// Match are the client attributes and point tags, e.g. region or cpu,
+	// that a series must have for the rule to apply to it.
+	Match map[string]string `json:"match,omitempty"`
+	// Op is the comparison: >, >=, <, or <=.
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+	// Clear is the level the value must return past for a firing alert to
+	// be resolved; this provides hysteresis.  If not set, Threshold is used.
+	Clear *float64 `json:"clear,omitempty"`
+	// For is how long the threshold must be exceeded before the alert fires.
+	For      util.Duration     `json:"for"`
+	Severity string            `json:"severity,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// the measurement and field of Metric
+	name  string
+	field string
+}
+
+// Validate checks the rule and sets the parsed values.
+func (r *rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("name required")
+	}
+	i := strings.IndexByte(r.Metric, '.')
+	if i < 0 {
+		return fmt.Errorf("%s: invalid metric %q: expected measurement.field", r.Name, r.Metric)
+	}
+	r.name, r.field = r.Metric[:i], r.Metric[i+1:]
+	if _, ok := measurementKinds[r.name]; !ok {
+		return fmt.Errorf("%s: unsupported measurement %q", r.Name, r.name)
+	}
+	switch r.Op {
+	case ">", ">=":
+		if r.Clear != nil && *r.Clear > r.Threshold {
+			return fmt.Errorf("%s: clear must be <= threshold", r.Name)
+		}
+	case "<", "<=":
+		if r.Clear != nil && *r.Clear < r.Threshold {
+			return fmt.Errorf("%s: clear must be >= threshold", r.Name)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported op %q", r.Name, r.Op)
+	}
+	return nil
+}
+
+// Kind returns the message kind of the points the rule applies to.
+func (r *rule) Kind() message.Kind {
+	return measurementKinds[r.name]
+}
+
+// Value returns the point's value for the rule and whether the rule applies
+// to the point.
+func (r *rule) Value(pt point) (float64, bool) {
+	if pt.Name != r.name {
+		return 0, false
+	}
+	for k, v := range r.Match {
+		vv, ok := pt.Tags[k]
+		if !ok {
+			vv = pt.Resource[k]
+		}
+		if vv != v {
+			return 0, false
+		}
+	}
+	v, ok := toFloat64(pt.Fields[r.field])
+	if !ok {
+		return 0, false
+	}
+	if r.Of == "" {
+		return v, true
+	}
+	of, ok := toFloat64(pt.Fields[r.Of])
+	if !ok || of == 0 {
+		return 0, false
+	}
+	return 100 * v / of, true
+}
+
+// Exceeds returns whether v exceeds the threshold.
+func (r *rule) Exceeds(v float64) bool {
+	return compare(r.Op, v, r.Threshold)
+}
+
+// Cleared returns whether v is back past the clear level.
+func (r *rule) Cleared(v float64) bool {
+	if r.Clear == nil {
+		return !r.Exceeds(v)
+	}
+	return !compare(r.Op, v, *r.Clear)
+}
+
+func compare(op string, v, threshold float64) bool {
+	switch op {
+	case ">":
+		return v > threshold
+	case ">=":
+		return v >= threshold
+	case "<":
+		return v < threshold
+	case "<=":
+		return v <= threshold
+	}
+	return false
+}
+
+// alert is the state of a rule for a client's series.
+type alert struct {
+	Rule     string            `json:"rule"`
+	Client   string            `json:"client"`
+	Series   string            `json:"series"`
+	Severity string            `json:"severity,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	State    string            `json:"state"`
+	// Value is the most recent value of the series.
+	Value float64 `json:"value"`
+	// Silenced and Maintenance are set when the alert is listed; they
+	// aren't persisted.
+	Silenced    bool `json:"silenced,omitempty"`
+	Maintenance bool `json:"maintenance,omitempty"`
+	// ActiveAt is when the threshold was first exceeded.
+	ActiveAt   time.Time `json:"active_at"`
+	FiredAt    time.Time `json:"fired_at"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+// ID returns the alert's unique ID.
+func (a *alert) ID() string {
+	return alertID(a.Rule, a.Client, a.Series)
+}
+
+func alertID(rule, client, series string) string {
+	return rule + "/" + client + "/" + series
+}
+
+// alerter evaluates the alert rules against incoming points, tracks the
+// resulting alerts, and sends their notifications.  If the database is set,
+// alert state, silences, and maintenance windows are persisted to it so that
+// they survive restarts.
+type alerter struct {
+	mu         sync.Mutex
+	rules      []*rule
+	kinds      map[message.Kind]bool
+	alerts     map[string]*alert
+	silences   map[string]*silence
+	windows    map[string]*maintenance
+	clientDown clientDownConf
+	dispatcher *dispatcher
+	db         *db.Bolt
+	// targets, if set, returns the IDs of a maintenance window's clients.
+	targets func(*maintenance) []string
+	// annotate, if set, writes an event to the output for a client.
+	annotate func(client, kind, msg string)
+}
+
+func newAlerter(b *db.Bolt) *alerter {
+	d, _ := newDispatcher(notifyConf{})
+	return &alerter{
+		kinds:      make(map[message.Kind]bool),
+		alerts:     make(map[string]*alert),
+		silences:   make(map[string]*silence),
+		windows:    make(map[string]*maintenance),
+		clientDown: clientDownConf{For: util.Duration{DefaultClientDownFor}},
+		dispatcher: d,
+		db:         b,
+	}
+}
+
+// LoadRules reads the rules file and replaces the current rules and
+// notification configuration with its own.  Alerts for rules that no longer
+// exist are removed.  If the file can't be read, or anything in it is
+// invalid, the current configuration is kept.
+func (a *alerter) LoadRules(name string) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var rs rules
+	err = json.Unmarshal(b, &rs)
+	if err != nil {
+		return fmt.Errorf("%s unmarshal error: %s", name, err)
+	}
+	d, err := newDispatcher(rs.Notify)
+	if err != nil {
+		return err
+	}
+	if rs.ClientDown.For.Duration <= 0 {
+		rs.ClientDown.For.Duration = DefaultClientDownFor
+	}
+	err = a.SetRules(rs.Rules)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clientDown = rs.ClientDown
+	if rs.ClientDown.Disabled {
+		for id, al := range a.alerts {
+			if al.Rule == ClientDownRule {
+				a.delete(id)
+			}
+		}
+	}
+	// keep the notification state of the current groups so that a reload
+	// doesn't resend them.
+	d.groups = a.dispatcher.groups
+	a.dispatcher = d
+	return nil
+}
+
+// SetRules validates the rules and, if they are all valid, replaces the
+// current rules with them.  Alerts for rules that no longer exist are
+// removed.
+func (a *alerter) SetRules(rs []*rule) error {
+	names := make(map[string]struct{}, len(rs))
+	kinds := make(map[message.Kind]bool)
+	for _, r := range rs {
+		err := r.Validate()
+		if err != nil {
+			return err
+		}
+		if _, ok := names[r.Name]; ok || r.Name == ClientDownRule {
+			return fmt.Errorf("%s: duplicate rule name", r.Name)
+		}
+		names[r.Name] = struct{}{}
+		kinds[r.Kind()] = true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = rs
+	a.kinds = kinds
+	for id, al := range a.alerts {
+		if _, ok := names[al.Rule]; !ok && al.Rule != ClientDownRule {
+			a.delete(id)
+		}
+	}
+	return nil
+}
+
+// Restore loads the persisted alerts, silences, and maintenance windows.
+// Resolved alerts that are older than ResolvedRetention are removed.
+func (a *alerter) Restore() error {
+	if a.db == nil {
+		return nil
+	}
+	saved, err := a.db.Alerts()
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, p := range saved {
+		var al alert
+		err = json.Unmarshal(p, &al)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "restore alert"),
+				zap.String("id", id),
+			)
+			continue
+		}
+		if al.State == StateResolved && time.Since(al.ResolvedAt) > ResolvedRetention {
+			a.delete(id)
+			continue
+		}
+		a.alerts[id] = &al
+	}
+	err = a.restoreSilences()
+	if err != nil {
+		return err
+	}
+	return a.restoreMaintenance()
+}
+
+// Wants returns whether any rule applies to messages of kind k.
+func (a *alerter) Wants(k message.Kind) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.kinds[k]
+}
+
+// Eval evaluates the rules against the points and updates the alerts.
+func (a *alerter) Eval(pts []point) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, r := range a.rules {
+		for _, pt := range pts {
+			v, ok := r.Value(pt)
+			if !ok {
+				continue
+			}
+			a.eval(r, pt, v)
+		}
+	}
+}
+
+// eval updates the alert for the rule and the point's series with the
+// value.  This does not do any locking; it is assumed that the caller is
+// properly managing the lock's state.
+func (a *alerter) eval(r *rule, pt point, v float64) {
+	series := SeriesName(r.name, r.field, pt.Tags)
+	id := alertID(r.Name, pt.Client, series)
+	t := time.Unix(0, pt.Time)
+	al, ok := a.alerts[id]
+	if !ok || al.State == StateResolved {
+		if !r.Exceeds(v) {
+			if ok && t.Sub(al.ResolvedAt) > ResolvedRetention {
+				a.delete(id)
+			}
+			return
+		}
+		al = &alert{
+			Rule:     r.Name,
+			Client:   pt.Client,
+			Series:   series,
+			Severity: r.Severity,
+			Labels:   alertLabels(r, pt),
+			State:    StatePending,
+			Value:    v,
+			ActiveAt: t,
+		}
+		a.alerts[id] = al
+		if r.For.Duration <= 0 {
+			al.State = StateFiring
+			al.FiredAt = t
+		}
+		a.save(al)
+		return
+	}
+	al.Value = v
+	switch al.State {
+	case StatePending:
+		if !r.Exceeds(v) {
+			// it never fired; there's nothing to resolve.
+			a.delete(id)
+			return
+		}
+		if t.Sub(al.ActiveAt) >= r.For.Duration {
+			al.State = StateFiring
+			al.FiredAt = t
+			a.save(al)
+		}
+	case StateFiring:
+		if r.Cleared(v) {
+			al.State = StateResolved
+			al.ResolvedAt = t
+			a.save(al)
+		}
+	}
+}
+
+// alertLabels returns the labels for an alert: the point's resource and tags
+// along with the rule's labels, which take precedence.
+func alertLabels(r *rule, pt point) map[string]string {
+	labels := make(map[string]string, len(pt.Resource)+len(pt.Tags)+len(r.Labels))
+	for k, v := range pt.Resource {
+		labels[k] = v
+	}
+	for k, v := range pt.Tags {
+		labels[k] = v
+	}
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// save persists the alert.  Errors are logged.
+func (a *alerter) save(al *alert) {
+	if a.db == nil {
+		return
+	}
+	p, err := json.Marshal(al)
+	if err == nil {
+		err = a.db.SaveAlert(al.ID(), p)
+	}
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "save alert"),
+			zap.String("id", al.ID()),
+		)
+	}
+}
+
+// delete removes the alert.  Errors are logged.
+func (a *alerter) delete(id string) {
+	delete(a.alerts, id)
+	if a.db == nil {
+		return
+	}
+	err := a.db.DeleteAlert(id)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "delete alert"),
+			zap.String("id", id),
+		)
+	}
+}
+
+// ClientDown creates the client's down alert, if it doesn't already exist.  The
+// resource is the client's attributes.  The alert fires once the client has
+// been down for the configured duration.
+func (a *alerter) ClientDown(res map[string]string, t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.clientDown.Disabled {
+		return
+	}
+	id := alertID(ClientDownRule, res["client"], "up")
+	if al, ok := a.alerts[id]; ok && al.State != StateResolved {
+		return
+	}
+	labels := make(map[string]string, len(res))
+	for k, v := range res {
+		labels[k] = v
+	}
+	al := &alert{
+		Rule:     ClientDownRule,
+		Client:   res["client"],
+		Series:   "up",
+		Severity: a.clientDown.Severity,
+		Labels:   labels,
+		State:    StatePending,
+		ActiveAt: t,
+	}
+	a.alerts[id] = al
+	a.save(al)
+}
+
+// ClientUp resolves the client's down alert.  If it never fired, it is
+// removed.
+func (a *alerter) ClientUp(client string, t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	id := alertID(ClientDownRule, client, "up")
+	al, ok := a.alerts[id]
+	if !ok {
+		return
+	}
+	switch al.State {
+	case StatePending:
+		a.delete(id)
+	case StateFiring:
+		al.State = StateResolved
+		al.Value = 1
+		al.ResolvedAt = t
+		a.save(al)
+	}
+}
+
+// Run fires the client down alerts that are due and sends the notifications
+// every period.
+func (a *alerter) Run(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for t := range ticker.C {
+		a.Tick(t)
+	}
+}
+
+// Tick starts and ends maintenance windows, fires the client down alerts that
+// have been pending for long enough, expires silences, and sends the
+// notifications that are due.  Clients that are in maintenance don't have
+// their down alerts fired; if they are still down once the window ends,
+// their alerts fire then.
+func (a *alerter) Tick(t time.Time) {
+	a.mu.Lock()
+	anns := a.updateMaintenance(t)
+	var alerts []alert
+	for _, al := range a.alerts {
+		maint := a.inMaintenance(al, t)
+		if al.Rule == ClientDownRule && al.State == StatePending && !maint && t.Sub(al.ActiveAt) >= a.clientDown.For.Duration {
+			al.State = StateFiring
+			al.FiredAt = t
+			a.save(al)
+		}
+		if al.State == StatePending || maint || a.silenced(al, t) {
+			continue
+		}
+		alerts = append(alerts, *al)
+	}
+	a.expireSilences(t)
+	d := a.dispatcher
+	ns := d.Dispatch(alerts, t)
+	a.mu.Unlock()
+	// outputs and notifiers can be slow; don't hold the lock while writing
+	// or sending.
+	a.writeAnnotations(anns)
+	for _, n := range ns {
+		d.Send(n)
+	}
+}
+
+// Alerts returns the alerts, sorted by ID, that match all of the non-empty
+// arguments.
+func (a *alerter) Alerts(state, client, rule, severity string) []alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	alerts := []alert{}
+	for _, al := range a.alerts {
+		if (state != "" && al.State != state) ||
+			(client != "" && al.Client != client) ||
+			(rule != "" && al.Rule != rule) ||
+			(severity != "" && al.Severity != severity) {
+			continue
+		}
+		cp := *al
+		cp.Silenced = a.silenced(al, now)
+		cp.Maintenance = a.inMaintenance(al, now)
+		alerts = append(alerts, cp)
+	}
+	sort.Sort(byAlertID(alerts))
+	return alerts
+}
+
+// Rules returns the current rules.
+func (a *alerter) Rules() []*rule {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rs := make([]*rule, len(a.rules))
+	copy(rs, a.rules)
+	return rs
+}
+
+type byAlertID []alert
+
+func (b byAlertID) Len() int           { return len(b) }
+func (b byAlertID) Less(i, j int) bool { return b[i].ID() < b[j].ID() }
+func (b byAlertID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// serveAlerts handles /api/alerts.  The alerts can be filtered with the
+// state, client, rule, and severity parameters.
+func serveAlerts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	writeJSON(w, srvr.Alerts.Alerts(q.Get("state"), q.Get("client"), q.Get("rule"), q.Get("severity")))
+}
+
+// serveAlertRules handles /api/alerts/rules.
+func serveAlertRules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, srvr.Alerts.Rules())
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mohae/autofact/util"
+)
+
+func TestAlertRules(t *testing.T) {
+	clear := 85.0
+	a := newAlerter(nil)
+	err := a.SetRules([]*rule{
+		{Name: "HighCPU", Metric: "cpus.usage", Match: map[string]string{"region": "east"}, Op: ">", Threshold: 90, Clear: &clear},
+	})
+	if err != nil {
+		t.Fatalf("expected no error; got %s", err)
+	}
+	a.rules[0].For.Duration = 2 * time.Second
+	tests := []struct {
+		sec    int64
+		region string
+		value  float32
+		state  string
+	}{
+		{0, "east", 50, ""},
+		{1, "west", 95, ""}, // doesn't match
+		{2, "east", 95, StatePending},
+		{3, "east", 95, StatePending},
+		{4, "east", 95, StateFiring},
+		{5, "east", 88, StateFiring}, // hysteresis
+		{6, "east", 80, StateResolved},
+		{7, "east", 95, StatePending},
+		{8, "east", 50, ""}, // never fired
+	}
+	for i, test := range tests {
+		a.Eval([]point{{
+			Client:   "c1",
+			Name:     "cpus",
+			Resource: map[string]string{"client": "c1", "region": test.region},
+			Tags:     map[string]string{"cpu": "cpu0"},
+			Fields:   map[string]interface{}{"usage": test.value},
+			Time:     test.sec * int64(time.Second),
+		}})
+		alerts := a.Alerts("", "", "", "")
+		var state string
+		if len(alerts) > 0 {
+			state = alerts[0].State
+		}
+		if state != test.state {
+			t.Errorf("%d: got %q; want %q", i, state, test.state)
+		}
+	}
+}
+
+func TestAlertRuleValidate(t *testing.T) {
+	clear := 95.0
+	tests := []struct {
+		rule rule
+		ok   bool
+	}{
+		{rule{Name: "a", Metric: "loadavg.one", Op: ">", Threshold: 4}, true},
+		{rule{Name: "a", Metric: "memory.free_ram", Of: "total_ram", Op: "<", Threshold: 5}, true},
+		{rule{Metric: "loadavg.one", Op: ">"}, false},
+		{rule{Name: "a", Metric: "loadavg", Op: ">"}, false},
+		{rule{Name: "a", Metric: "disk.used", Op: ">"}, false},
+		{rule{Name: "a", Metric: "loadavg.one", Op: "=="}, false},
+		{rule{Name: "a", Metric: "loadavg.one", Op: ">", Threshold: 90, Clear: &clear}, false},
+	}
+	for i, test := range tests {
+		err := test.rule.Validate()
+		if (err == nil) != test.ok {
+			t.Errorf("%d: got %v; want ok == %t", i, err, test.ok)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	d, err := newDispatcher(notifyConf{})
+	if err != nil {
+		t.Fatalf("expected no error; got %s", err)
+	}
+	d.GroupWait = 10 * time.Second
+	d.RepeatInterval = time.Minute
+	start := time.Unix(0, 0)
+	firing := alert{Rule: "HighCPU", Client: "c1", Series: "cpus.usage", State: StateFiring}
+	firing2 := alert{Rule: "HighCPU", Client: "c2", Series: "cpus.usage", State: StateFiring}
+	resolved := firing
+	resolved.State = StateResolved
+	tests := []struct {
+		sec    int
+		alerts []alert
+		sent   int // the number of alerts sent; 0 if nothing was sent
+		status string
+	}{
+		{0, []alert{firing}, 0, ""},          // group wait
+		{5, []alert{firing, firing2}, 0, ""}, // group wait
+		{10, []alert{firing, firing2}, 2, StateFiring},
+		{20, []alert{firing, firing2}, 0, ""}, // nothing changed
+		{30, []alert{resolved, firing2}, 2, StateFiring},
+		{40, []alert{resolved, firing2}, 0, ""},          // resolved was sent
+		{90, []alert{resolved, firing2}, 1, StateFiring}, // repeat
+		{100, []alert{resolved}, 0, ""},                  // firing2 was never resolved, e.g. silenced
+	}
+	for i, test := range tests {
+		ns := d.Dispatch(test.alerts, start.Add(time.Duration(test.sec)*time.Second))
+		if test.sent == 0 {
+			if len(ns) != 0 {
+				t.Errorf("%d: expected no notifications; got %v", i, ns)
+			}
+			continue
+		}
+		if len(ns) != 1 {
+			t.Errorf("%d: expected 1 notification; got %d", i, len(ns))
+			continue
+		}
+		if len(ns[0].Alerts) != test.sent {
+			t.Errorf("%d: got %d alerts; want %d", i, len(ns[0].Alerts), test.sent)
+		}
+		if ns[0].Status != test.status {
+			t.Errorf("%d: got %q; want %q", i, ns[0].Status, test.status)
+		}
+		if ns[0].Group["rule"] != "HighCPU" {
+			t.Errorf("%d: got group %v; want rule=HighCPU", i, ns[0].Group)
+		}
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	a := newAlerter(nil)
+	var events []string
+	a.targets = func(m *maintenance) []string { return m.Clients }
+	a.annotate = func(client, kind, msg string) { events = append(events, client+" "+msg) }
+	start := time.Now()
+	_, err := a.AddMaintenance(maintenance{Clients: []string{"c1"}, StartsAt: start, Duration: util.Duration{time.Minute}, Comment: "patching"})
+	if err != nil {
+		t.Fatalf("expected no error; got %s", err)
+	}
+	a.ClientDown(map[string]string{"client": "c1"}, start)
+	a.ClientDown(map[string]string{"client": "c2"}, start)
+	a.Tick(start.Add(30 * time.Second))
+	if len(events) != 1 || events[0] != "c1 maintenance started: patching" {
+		t.Errorf("got %v; want the start of maintenance for c1", events)
+	}
+	alerts := a.Alerts("", "c1", "", "")
+	if len(alerts) != 1 || alerts[0].State != StatePending || !alerts[0].Maintenance {
+		t.Errorf("c1: got %+v; want a pending alert in maintenance", alerts)
+	}
+	// c1 is still down once maintenance is over
+	a.Tick(start.Add(90 * time.Second))
+	if len(events) != 2 || events[1] != "c1 maintenance ended: patching" {
+		t.Errorf("got %v; want the end of maintenance for c1", events)
+	}
+	for _, id := range []string{"c1", "c2"} {
+		alerts = a.Alerts("", id, "", "")
+		if len(alerts) != 1 || alerts[0].State != StateFiring {
+			t.Errorf("%s: got %+v; want a firing alert", id, alerts)
+		}
+	}
+	if len(a.MaintenanceWindows()) != 0 {
+		t.Error("expected the maintenance window to be removed")
+	}
+}
+
+func TestClientDownReplacedConnection(t *testing.T) {
+	a := newAlerter(nil)
+	old := srvr.newClient([]byte("alertreconnect"))
+	old.alerts = a
+	c := srvr.newClient([]byte("alertreconnect"))
+	c.alerts = a
+	srvr.Inventory.Connect(old)
+	srvr.Inventory.Connect(c)
+	defer srvr.Inventory.Disconnect(c)
+	// the old connection is torn down after the client reconnected.
+	old.Disconnected()
+	if alerts := a.Alerts("", "alertreconnect", "", ""); len(alerts) != 0 {
+		t.Errorf("got %d alerts; want none", len(alerts))
+	}
+	if !srvr.Inventory.Status([]byte("alertreconnect")).Connected {
+		t.Error("got disconnected; want connected")
+	}
+}
+
+func TestMaintenanceAnnotateUnlocked(t *testing.T) {
+	a := newAlerter(nil)
+	a.targets = func(m *maintenance) []string { return m.Clients }
+	var n int
+	// the annotations are written without holding the alerter's lock, so
+	// the output can use the alerter.
+	a.annotate = func(client, kind, msg string) { n += len(a.MaintenanceWindows()) }
+	start := time.Now()
+	_, err := a.AddMaintenance(maintenance{ID: "m1", Clients: []string{"c1"}, StartsAt: start, Duration: util.Duration{time.Minute}})
+	if err != nil {
+		t.Fatalf("expected no error; got %s", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		a.Tick(start.Add(time.Second))
+		a.DeleteMaintenance("m1")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("annotations were written while holding the alerter's lock")
+	}
+	// started with the window, ended after it was deleted.
+	if n != 1 {
+		t.Errorf("got %d windows seen by the annotations; want 1", n)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mohae/autofact/message"
+)
+
+// Anomaly detection defaults.
+var (
+	// DefaultAnomalyMetrics are the metrics whose baselines are tracked if
+	// none are specified.
+	DefaultAnomalyMetrics = []string{
+		"cpus.usage",
+		"loadavg.one",
+		"memory.free_ram",
+		"interfaces.received.bytes",
+		"interfaces.transmitted.bytes",
+	}
+	// DefaultAnomalyAlpha is the weight of each new sample in a baseline.
+	DefaultAnomalyAlpha = 0.01
+	// DefaultAnomalySigma is the number of standard deviations from the mean
+	// a sample has to be for it to be anomalous.
+	DefaultAnomalySigma = 3.0
+	// AnomalyWarmup is the number of samples a baseline needs before samples
+	// are checked against it.
+	AnomalyWarmup = 30
+)
+
+// hoursPerWeek is the number of seasonal baselines.
+const hoursPerWeek = 7 * 24
+
+// ewma is an exponentially weighted moving mean and variance.
+type ewma struct {
+	Mean float64
+	Var  float64
+	N    int
+}
+
+// Add adds the value to the moving mean and variance; alpha is the weight of
+// the value.
+func (e *ewma) Add(v, alpha float64) {
+	if e.N == 0 {
+		e.Mean = v
+		e.N = 1
+		return
+	}
+	diff := v - e.Mean
+	incr := alpha * diff
+	e.Mean += incr
+	e.Var = (1 - alpha) * (e.Var + diff*incr)
+	e.N++
+}
+
+// StdDev returns the standard deviation.
+func (e *ewma) StdDev() float64 {
+	return math.Sqrt(e.Var)
+}
+
+// baseline is what is expected of a series.
+type baseline struct {
+	all ewma
+	// weekly are the baselines for each hour of the week; it is nil unless
+	// seasonality is being used.
+	weekly []ewma
+	// anomalous is whether the last sample was anomalous.
+	anomalous bool
+}
+
+// anomaly is a sample that deviated from its series' baseline.
+type anomaly struct {
+	Client string
+	Series string
+	Value  float64
+	Mean   float64
+	StdDev float64
+	// Sigma is the number of standard deviations Value is from Mean.
+	Sigma float64
+	Time  int64
+}
+
+func (a anomaly) String() string {
+	return fmt.Sprintf("%s = %g is %.1f standard deviations from the mean %g (sd %g)", a.Series, a.Value, a.Sigma, a.Mean, a.StdDev)
+}
+
+// detector keeps a baseline for each client's series and detects samples
+// that deviate from it.
+type detector struct {
+	mu sync.Mutex
+	// Alpha is the weight of each new sample in a baseline.
+	Alpha float64
+	// Sigma is the number of standard deviations from the mean a sample has
+	// to be for it to be anomalous.
+	Sigma float64
+	// Seasonal is whether a baseline is also kept for each hour of the
+	// week.  Once an hour's baseline is warmed up, it is used instead of
+	// the overall baseline.
+	Seasonal bool
+	// fields are the fields being tracked, by measurement
+	fields    map[string][]string
+	kinds     map[message.Kind]bool
+	baselines map[string]*baseline
+}
+
+// newDetector returns a detector for the metrics, which are the measurement
+// and field, e.g. cpus.usage.
+func newDetector(metrics []string, alpha, sigma float64, seasonal bool) (*detector, error) {
+	if alpha <= 0 || alpha >= 1 {
+		return nil, errors.New("anomaly: alpha must be > 0 and < 1")
+	}
+	if sigma <= 0 {
+		return nil, errors.New("anomaly: sigma must be > 0")
+	}
+	d := &detector{
+		Alpha:     alpha,
+		Sigma:     sigma,
+		Seasonal:  seasonal,
+		fields:    make(map[string][]string),
+		kinds:     make(map[message.Kind]bool),
+		baselines: make(map[string]*baseline),
+	}
+	for _, m := range metrics {
+		i := strings.IndexByte(m, '.')
+		if i < 0 {
+			return nil, fmt.Errorf("anomaly: invalid metric %q: expected measurement.field", m)
+		}
+		k, ok := measurementKinds[m[:i]]
+		if !ok {
+			return nil, fmt.Errorf("anomaly: unsupported measurement %q", m[:i])
+		}
+		d.fields[m[:i]] = append(d.fields[m[:i]], m[i+1:])
+		d.kinds[k] = true
+	}
+	return d, nil
+}
+
+// Wants returns whether any of the tracked metrics are in messages of kind k.
+func (d *detector) Wants(k message.Kind) bool {
+	return d.kinds[k]
+}
+
+// Observe checks the points' tracked fields against their baselines, and then
+// adds them to the baselines.  The anomalies are returned.  Only the first
+// sample of a run of anomalous samples is returned.
+func (d *detector) Observe(pts []point) []anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var anomalies []anomaly
+	for _, pt := range pts {
+		for _, field := range d.fields[pt.Name] {
+			v, ok := toFloat64(pt.Fields[field])
+			if !ok {
+				continue
+			}
+			series := SeriesName(pt.Name, field, pt.Tags)
+			a, ok := d.observe(pt.Client+"/"+series, v, time.Unix(0, pt.Time))
+			if !ok {
+				continue
+			}
+			a.Client = pt.Client
+			a.Series = series
+			a.Time = pt.Time
+			anomalies = append(anomalies, a)
+		}
+	}
+	return anomalies
+}
+
+// observe checks the value against the baseline with the key and then adds
+// it to the baseline.  If the value is the first of a run of anomalous values
+// the anomaly is returned along with true.  This does not do any locking; it
+// is assumed that the caller is properly managing the lock's state.
+func (d *detector) observe(key string, v float64, t time.Time) (anomaly, bool) {
+	b, ok := d.baselines[key]
+	if !ok {
+		b = &baseline{}
+		if d.Seasonal {
+			b.weekly = make([]ewma, hoursPerWeek)
+		}
+		d.baselines[key] = b
+	}
+	expected := &b.all
+	var hour *ewma
+	if b.weekly != nil {
+		t = t.UTC()
+		hour = &b.weekly[int(t.Weekday())*24+t.Hour()]
+		if hour.N >= AnomalyWarmup {
+			expected = hour
+		}
+	}
+	var a anomaly
+	var anomalous bool
+	if sd := expected.StdDev(); expected.N >= AnomalyWarmup && sd > 0 {
+		a = anomaly{Value: v, Mean: expected.Mean, StdDev: sd, Sigma: math.Abs(v-expected.Mean) / sd}
+		anomalous = a.Sigma > d.Sigma
+	}
+	b.all.Add(v, d.Alpha)
+	if hour != nil {
+		hour.Add(v, d.Alpha)
+	}
+	first := anomalous && !b.anomalous
+	b.anomalous = anomalous
+	return a, first
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetector(t *testing.T) {
+	d, err := newDetector([]string{"loadavg.one"}, 0.1, 3, false)
+	if err != nil {
+		t.Fatalf("expected no error; got %s", err)
+	}
+	pt := func(sec int, v float64) []point {
+		return []point{{
+			Client: "c1",
+			Name:   "loadavg",
+			Fields: map[string]interface{}{"one": v, "five": 100.0},
+			Time:   int64(sec) * int64(time.Second),
+		}}
+	}
+	// alternate between 1 and 2: the mean is ~1.5 with a sd of ~0.5
+	var sec int
+	for ; sec < 100; sec++ {
+		a := d.Observe(pt(sec, float64(1+sec%2)))
+		if len(a) != 0 {
+			t.Fatalf("%d: expected no anomalies; got %v", sec, a)
+		}
+	}
+	a := d.Observe(pt(sec, 10))
+	if len(a) != 1 {
+		t.Fatalf("expected 1 anomaly; got %d", len(a))
+	}
+	if a[0].Series != "loadavg.one" || a[0].Client != "c1" || a[0].Value != 10 || a[0].Sigma <= 3 {
+		t.Errorf("unexpected anomaly: %+v", a[0])
+	}
+	// a run of anomalous samples is only reported once
+	if a = d.Observe(pt(sec+1, 20)); len(a) != 0 {
+		t.Errorf("expected no anomalies; got %v", a)
+	}
+}
+
+func TestDetectorWarmup(t *testing.T) {
+	d, err := newDetector([]string{"loadavg.one"}, 0.1, 3, true)
+	if err != nil {
+		t.Fatalf("expected no error; got %s", err)
+	}
+	for i := 0; i < AnomalyWarmup; i++ {
+		v := float64(i % 2)
+		if i == AnomalyWarmup-1 {
+			v = 1000
+		}
+		a := d.Observe([]point{{Client: "c1", Name: "loadavg", Fields: map[string]interface{}{"one": v}}})
+		if len(a) != 0 {
+			t.Errorf("%d: expected no anomalies during warmup; got %v", i, a)
+		}
+	}
+	for _, m := range []string{"loadavg", "disk.used"} {
+		_, err = newDetector([]string{m}, 0.1, 3, false)
+		if err == nil {
+			t.Errorf("%s: expected an error", m)
+		}
+	}
+}
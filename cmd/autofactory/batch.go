@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+// Defaults for outputs that batch their writes.
+var (
+	// BatchSize is the number of points that will trigger a flush.
+	BatchSize = 500
+	// FlushInterval is the longest that points will be held before a flush.
+	FlushInterval = 10 * time.Second
+	// Retries is the number of times a failed write will be retried.
+	Retries = 3
+	// RetryWait is the wait before the first retry; it is doubled on each
+	// subsequent retry.
+	RetryWait = 500 * time.Millisecond
+)
+
+// batcher accumulates points and flushes them when either the batch size has
+// been reached or the flush interval has elapsed, whichever comes first.
+type batcher struct {
+	name     string
+	size     int
+	interval time.Duration
+	pointsCh chan []point
+	doneCh   chan struct{}
+	flush    func([]point) error
+	mu       sync.Mutex
+	// closed is whether Close has been called; pointsCh is closed.
+	closed bool
+	// dropped is the number of points that were dropped because the queue
+	// was full or the batcher was closed.
+	dropped uint64
+}
+
+func newBatcher(name string, size int, interval time.Duration, flush func([]point) error) *batcher {
+	if size <= 0 {
+		size = BatchSize
+	}
+	if interval <= 0 {
+		interval = FlushInterval
+	}
+	return &batcher{
+		name:     name,
+		size:     size,
+		interval: interval,
+		pointsCh: make(chan []point, 100),
+		doneCh:   make(chan struct{}),
+		flush:    flush,
+	}
+}
+
+// Send queues the points for the next batch.  Send doesn't block: if the
+// queue is full, e.g. because the output is down and the flush is being
+// retried, or the batcher has been closed, the points are dropped.
+func (b *batcher) Send(pts []point) {
+	if len(pts) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		b.dropped += uint64(len(pts))
+		return
+	}
+	select {
+	case b.pointsCh <- pts:
+		return
+	default:
+	}
+	b.dropped += uint64(len(pts))
+	log.Warn(
+		"queue full: points dropped",
+		zap.String("op", "send batch"),
+		zap.String("output", b.name),
+		zap.Int("points", len(pts)),
+		zap.Uint64("dropped", b.dropped),
+	)
+}
+
+// Dropped returns the number of points that have been dropped.
+func (b *batcher) Dropped() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
+
+// Run accumulates the received points and flushes them on either size or
+// interval.  Run returns after Close has been called and any remaining points
+// have been flushed.
+func (b *batcher) Run() {
+	defer close(b.doneCh)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	buf := make([]point, 0, b.size)
+	for {
+		select {
+		case pts, ok := <-b.pointsCh:
+			if !ok {
+				b.write(buf)
+				return
+			}
+			buf = append(buf, pts...)
+			if len(buf) < b.size {
+				continue
+			}
+		case <-ticker.C:
+		}
+		b.write(buf)
+		buf = buf[:0]
+	}
+}
+
+// Close stops accepting points and waits for the pending points to be
+// flushed.  It is safe to call Close more than once.
+func (b *batcher) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.pointsCh)
+	}
+	b.mu.Unlock()
+	<-b.doneCh
+}
+
+func (b *batcher) write(pts []point) {
+	if len(pts) == 0 {
+		return
+	}
+	err := b.flush(pts)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "write batch"),
+			zap.String("output", b.name),
+			zap.Int("points", len(pts)),
+		)
+	}
+}
+
+// post POSTs the body to the url.  Failed requests are retried, with the
+// wait doubling between each attempt, until retries has been exhausted.
+// Client errors, other than 429, are not retried as resending the same body
+// will not change the result.
+func post(cl *http.Client, url, contentType string, body []byte, retries int) error {
+	var err error
+	wait := RetryWait
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		var resp *http.Response
+		resp, err = cl.Post(url, contentType, bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("%s: %s", url, resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return err
+		}
+	}
+	return err
+}
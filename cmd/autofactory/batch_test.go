@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+func TestBatcherSend(t *testing.T) {
+	defer func(l zap.Logger) { log = l }(log)
+	log = zap.New(zap.NewJSONEncoder(), zap.Output(zap.AddSync(ioutil.Discard)))
+	var flushed []point
+	b := newBatcher("test", 1000, time.Hour, func(pts []point) error {
+		flushed = append(flushed, pts...)
+		return nil
+	})
+	// Run isn't running, e.g. it is retrying a flush: once the queue is
+	// full, the points are dropped instead of blocking.
+	for i := 0; i < cap(b.pointsCh)+1; i++ {
+		b.Send([]point{{Name: "loadavg"}})
+	}
+	if b.Dropped() != 1 {
+		t.Errorf("queue full: got %d dropped; want 1", b.Dropped())
+	}
+	go b.Run()
+	b.Close()
+	if len(flushed) != cap(b.pointsCh) {
+		t.Errorf("got %d points flushed; want %d", len(flushed), cap(b.pointsCh))
+	}
+	// points sent after Close are dropped.
+	b.Send([]point{{Name: "loadavg"}})
+	if b.Dropped() != 2 {
+		t.Errorf("closed: got %d dropped; want 2", b.Dropped())
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mohae/autofact/conf"
+	"github.com/mohae/autofact/message"
+	"github.com/mohae/autofact/util"
+	"github.com/mohae/randchars"
+	"github.com/uber-go/zap"
+)
+
+const (
+	// ClusterPath is the path that the instances of a cluster replicate
+	// the client inventory with.
+	ClusterPath = "/cluster/clients"
+	// ClusterKeyHeader is the header with the cluster key; requests without
+	// the key are refused.
+	ClusterKeyHeader = "X-Autofact-Cluster-Key"
+	// ClusterNodeHeader is the header with the node of the instance that
+	// responded.
+	ClusterNodeHeader = "X-Autofact-Cluster-Node"
+)
+
+var (
+	// ClusterSyncInterval is how often an instance gets the clients that it
+	// doesn't know about from its peers.
+	ClusterSyncInterval = time.Minute
+	// ClusterTimeout is the time limit for a request to a peer.
+	ClusterTimeout = 10 * time.Second
+)
+
+// cluster shares the client inventory between the autofactory instances of a
+// cluster so that a client that fails over to another instance keeps its ID
+// and configuration.  Each instance has its own node, a prefix of the IDs it
+// gives new clients, so that IDs don't collide across instances.
+//
+// Clients are sent to the peers when they connect, with any changes to their
+// conf.  Because a peer may be unreachable at the time, each instance also
+// gets the clients it doesn't know about, or whose conf differs from its own,
+// from its peers every ClusterSyncInterval, and asks its peers about a client
+// it doesn't know about before giving the client a new ID.
+type cluster struct {
+	Node   string
+	Peers  []string // the base URLs of the peers, e.g. http://10.0.0.2:8675
+	key    string
+	client *http.Client
+}
+
+func newCluster(node string, peers []string, key string) (*cluster, error) {
+	if len(node) == 0 || len(node) > util.IDLen/2 {
+		return nil, fmt.Errorf("invalid cluster node %q: must be 1 to %d characters", node, util.IDLen/2)
+	}
+	for _, r := range node {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return nil, fmt.Errorf("invalid cluster node %q: must be alphanumeric", node)
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("a cluster key is required")
+	}
+	for _, p := range peers {
+		_, err := url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster peer %q: %s", p, err)
+		}
+	}
+	return &cluster{
+		Node:   node,
+		Peers:  peers,
+		key:    key,
+		client: &http.Client{Timeout: ClusterTimeout},
+	}, nil
+}
+
+// NewID returns a new client ID: the node followed by random characters.
+func (cl *cluster) NewID() []byte {
+	return append([]byte(cl.Node), randchars.AlphaNum(util.IDLen-len(cl.Node))...)
+}
+
+// Publish sends the client to the peers.  Peers that can't be reached get it
+// when they next sync.
+func (cl *cluster) Publish(c *conf.Client) {
+	var buf bytes.Buffer
+	message.WriteFrames(&buf, [][]byte{c.Serialize()})
+	for _, peer := range cl.Peers {
+		go func(peer string, body []byte) {
+			_, err := cl.do("POST", peer, "", body)
+			if err != nil {
+				log.Warn(
+					err.Error(),
+					zap.String("op", "publish client"),
+					zap.String("peer", peer),
+					zap.String("client", string(c.IDBytes())),
+				)
+			}
+		}(peer, buf.Bytes())
+	}
+}
+
+// Fetch asks the peers for the client and returns the first peer's answer.
+func (cl *cluster) Fetch(id []byte) (*conf.Client, bool) {
+	for _, peer := range cl.Peers {
+		clients, err := cl.do("GET", peer, string(id), nil)
+		if err != nil {
+			log.Debug(
+				err.Error(),
+				zap.String("op", "fetch client"),
+				zap.String("peer", peer),
+				zap.String("client", string(id)),
+			)
+			continue
+		}
+		for _, c := range clients {
+			if bytes.Equal(c.IDBytes(), id) {
+				return c, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Sync gets the clients that aren't in the inventory, or whose conf differs
+// from the inventory's, from the peers.  A client that is connected to this
+// instance keeps its conf: it is the current one, and the peers get it when
+// they sync.
+func (cl *cluster) Sync() {
+	for _, peer := range cl.Peers {
+		clients, err := cl.do("GET", peer, "", nil)
+		if err != nil {
+			log.Warn(
+				err.Error(),
+				zap.String("op", "sync clients"),
+				zap.String("peer", peer),
+			)
+			continue
+		}
+		var n int
+		for _, c := range clients {
+			if old, ok := srvr.Inventory.Client(c.IDBytes()); ok {
+				if bytes.Equal(old.Serialize(), c.Serialize()) || srvr.Inventory.Status(c.IDBytes()).Connected {
+					continue
+				}
+			}
+			srvr.AddReplicatedClient(c)
+			n++
+		}
+		if n > 0 {
+			log.Info(
+				"clients synced",
+				zap.String("op", "sync clients"),
+				zap.String("peer", peer),
+				zap.Int("count", n),
+			)
+		}
+	}
+}
+
+// Run syncs with the peers now and then every ClusterSyncInterval.
+func (cl *cluster) Run() {
+	cl.Sync()
+	ticker := time.NewTicker(ClusterSyncInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cl.Sync()
+	}
+}
+
+// do sends a request to the peer and returns the clients in the response.  If
+// id isn't empty, only that client is requested.
+func (cl *cluster) do(method, peer, id string, body []byte) ([]*conf.Client, error) {
+	u := strings.TrimSuffix(peer, "/") + ClusterPath
+	if id != "" {
+		u += "?id=" + url.QueryEscape(id)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", message.FrameContentType)
+	req.Header.Set(ClusterKeyHeader, cl.key)
+	resp, err := cl.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	if node := resp.Header.Get(ClusterNodeHeader); node == cl.Node {
+		return nil, fmt.Errorf("peer has the same cluster node, %q: each instance must have its own", node)
+	}
+	return readClients(resp.Body)
+}
+
+// readClients reads the clients, as frames of flatbuffer serialized
+// conf.Clients.
+func readClients(r io.Reader) ([]*conf.Client, error) {
+	frames, err := message.ReadFrames(r, srvr.MaxFrameSize)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]*conf.Client, 0, len(frames))
+	for _, p := range frames {
+		err = message.VerifyPayload(message.ClientConf, p)
+		if err != nil {
+			return nil, err
+		}
+		c := conf.GetRootAsClient(p, 0)
+		if len(c.IDBytes()) == 0 {
+			return nil, fmt.Errorf("client without an ID")
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+// serveCluster serves the client inventory to the instance's peers.  A GET
+// returns all of the clients, or, with an id parameter, that client; a POST
+// adds, or replaces, the clients in the request.  Clients are frames of
+// flatbuffer serialized conf.Clients.
+func serveCluster(w http.ResponseWriter, r *http.Request) {
+	cl := srvr.Cluster
+	if cl == nil || subtle.ConstantTimeCompare([]byte(r.Header.Get(ClusterKeyHeader)), []byte(cl.key)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set(ClusterNodeHeader, cl.Node)
+	switch r.Method {
+	case "GET":
+		var clients []*conf.Client
+		if id := r.URL.Query().Get("id"); id != "" {
+			c, ok := srvr.Inventory.Client([]byte(id))
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			clients = append(clients, c)
+		} else {
+			clients = srvr.Inventory.Clients()
+		}
+		frames := make([][]byte, len(clients))
+		for i, c := range clients {
+			frames[i] = c.Serialize()
+		}
+		var buf bytes.Buffer
+		message.WriteFrames(&buf, frames)
+		w.Header().Set("Content-Type", message.FrameContentType)
+		w.Write(buf.Bytes())
+	case "POST":
+		clients, err := readClients(http.MaxBytesReader(w, r.Body, MaxHTTPBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, c := range clients {
+			srvr.AddReplicatedClient(c)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mohae/autofact/conf"
+	"github.com/mohae/autofact/db"
+	"github.com/mohae/autofact/message"
+	"github.com/mohae/autofact/util"
+	"github.com/uber-go/zap"
+)
+
+func TestNewCluster(t *testing.T) {
+	tests := []struct {
+		node string
+		key  string
+		err  string
+	}{
+		{"a1", "secret", ""},
+		{"abcd", "secret", ""},
+		{"", "secret", "invalid cluster node"},
+		{"abcde", "secret", "invalid cluster node"},
+		{"a-1", "secret", "must be alphanumeric"},
+		{"a1", "", "a cluster key is required"},
+	}
+	for _, test := range tests {
+		_, err := newCluster(test.node, nil, test.key)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", test.node, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got %v; want an error containing %q", test.node, err, test.err)
+		}
+	}
+}
+
+func TestClusterNewID(t *testing.T) {
+	cl, err := newCluster("n2", nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := string(cl.NewID())
+		if len(id) != util.IDLen {
+			t.Errorf("%s: got length %d; want %d", id, len(id), util.IDLen)
+		}
+		if !strings.HasPrefix(id, "n2") {
+			t.Errorf("%s: want the prefix n2", id)
+		}
+		if seen[id] {
+			t.Errorf("%s: duplicate ID", id)
+		}
+		seen[id] = true
+	}
+}
+
+// useTestBolt replaces the server's database, and its logger, with ones for
+// the test; the returned func restores them.
+func useTestBolt(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "autofactory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, l := srvr.Bolt, log
+	log = zap.New(zap.NewJSONEncoder(), zap.Output(zap.AddSync(ioutil.Discard)))
+	srvr.Bolt = db.Bolt{}
+	err = srvr.Bolt.Open(filepath.Join(dir, "autofactory.bdb"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return func() {
+		srvr.Bolt.Close()
+		srvr.Bolt, log = b, l
+		os.RemoveAll(dir)
+	}
+}
+
+// testClientConf returns the conf of a client with the id and hostname.
+func testClientConf(id, hostname string) *conf.Client {
+	info := conf.ClientInfo{ID: []byte(id), Hostname: hostname}
+	return conf.GetRootAsClient(info.Serialize(), 0)
+}
+
+func TestClusterSync(t *testing.T) {
+	defer useTestBolt(t)()
+	srvr.Inventory.AddClient(testClientConf("sync1", "web01"))
+	srvr.Inventory.AddClient(testClientConf("sync2", "web02"))
+	// sync3 is connected to this instance: its conf is the current one.
+	srvr.Inventory.AddClient(testClientConf("sync3", "web03"))
+	c := srvr.newClient([]byte("sync3"))
+	srvr.Inventory.Connect(c)
+	defer srvr.Inventory.Disconnect(c)
+
+	// the peer has a new client, sync0, and has missed, or has newer,
+	// updates of sync2 and sync3.
+	peerClients := []*conf.Client{
+		testClientConf("sync0", "web00"),
+		testClientConf("sync1", "web01"),
+		testClientConf("sync2", "web02b"),
+		testClientConf("sync3", "web03b"),
+	}
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ClusterKeyHeader) != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		frames := make([][]byte, len(peerClients))
+		for i, c := range peerClients {
+			frames[i] = c.Serialize()
+		}
+		var buf bytes.Buffer
+		message.WriteFrames(&buf, frames)
+		w.Header().Set(ClusterNodeHeader, "b")
+		w.Write(buf.Bytes())
+	}))
+	defer peer.Close()
+	cl, err := newCluster("a", []string{peer.URL}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.Sync()
+	tests := []struct {
+		id       string
+		hostname string
+	}{
+		{"sync0", "web00"},
+		{"sync1", "web01"},
+		{"sync2", "web02b"},
+		{"sync3", "web03"},
+	}
+	for _, test := range tests {
+		c, ok := srvr.Inventory.Client([]byte(test.id))
+		if !ok {
+			t.Errorf("%s: not in the inventory", test.id)
+			continue
+		}
+		if string(c.Hostname()) != test.hostname {
+			t.Errorf("%s: got hostname %q; want %q", test.id, c.Hostname(), test.hostname)
+		}
+		// the synced clients are saved.
+		if test.id == "sync0" || test.id == "sync2" {
+			saved, err := srvr.Bolt.GetClient([]byte(test.id))
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.id, err)
+			} else if string(saved.Hostname()) != test.hostname {
+				t.Errorf("%s: saved client: got hostname %q; want %q", test.id, saved.Hostname(), test.hostname)
+			}
+		}
+	}
+}
+
+func TestServeCluster(t *testing.T) {
+	defer useTestBolt(t)()
+	defer func(cl *cluster) { srvr.Cluster = cl }(srvr.Cluster)
+	var err error
+	srvr.Cluster, err = newCluster("a", nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(serveCluster))
+	defer ts.Close()
+	// the peer publishes a client and then its update: the update replaces
+	// the client.
+	peer, err := newCluster("b", []string{ts.URL}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hostname := range []string{"web01", "web01b"} {
+		var buf bytes.Buffer
+		message.WriteFrames(&buf, [][]byte{testClientConf("serve1", hostname).Serialize()})
+		_, err = peer.do("POST", ts.URL, "", buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", hostname, err)
+		}
+	}
+	c, ok := peer.Fetch([]byte("serve1"))
+	if !ok {
+		t.Fatal("serve1: not found")
+	}
+	if string(c.Hostname()) != "web01b" {
+		t.Errorf("got hostname %q; want %q", c.Hostname(), "web01b")
+	}
+	if _, ok = peer.Fetch([]byte("serve2")); ok {
+		t.Error("serve2: got found; want not found")
+	}
+	// requests without the key are refused.
+	bad, err := newCluster("b", []string{ts.URL}, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = bad.do("GET", ts.URL, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("wrong key: got %v; want a 403 error", err)
+	}
+}
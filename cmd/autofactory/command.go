@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mohae/autofact/command"
+	"github.com/mohae/autofact/message"
+	"github.com/mohae/snoflinga"
+	"github.com/uber-go/zap"
+)
+
+// CommandTimeout is how long a client has to reply to a command.
+var CommandTimeout = 30 * time.Second
+
+var (
+	errNotConnected   = errors.New("client is not connected")
+	errCommandTimeout = errors.New("timed out waiting for the client's reply")
+	errNoCommands     = errors.New("client doesn't support commands")
+)
+
+// commandRequest is a request to send a command to a client.
+type commandRequest struct {
+	Client  string `json:"client"`
+	Command string `json:"command"`
+	Arg     string `json:"arg,omitempty"`
+}
+
+// commandReply is a client's reply to a command.
+type commandReply struct {
+	ID      string `json:"id"`
+	Client  string `json:"client"`
+	Command string `json:"command"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+// pendingCommand is a command that is waiting for its client's reply.
+type pendingCommand struct {
+	// client is the ID of the client the command was sent to; only its
+	// reply is accepted.
+	client string
+	ch     chan error
+}
+
+// commander sends commands to the connected clients and correlates their
+// replies with the commands.
+type commander struct {
+	mu sync.Mutex
+	// clients are the connected clients, by ID.
+	clients map[string]*Client
+	// pending are the commands waiting for a reply, by command ID.
+	pending map[string]pendingCommand
+	// flake returns the ID for a new command.
+	flake func() snoflinga.Flake
+}
+
+func newCommander(flake func() snoflinga.Flake) *commander {
+	return &commander{
+		clients: make(map[string]*Client),
+		pending: make(map[string]pendingCommand),
+		flake:   flake,
+	}
+}
+
+// Add adds the connected client.  If the client was already connected, the
+// earlier connection is replaced.
+func (cm *commander) Add(c *Client) {
+	cm.mu.Lock()
+	cm.clients[string(c.Conf.IDBytes())] = c
+	cm.mu.Unlock()
+}
+
+// Remove removes the client if c is its current connection.
+func (cm *commander) Remove(c *Client) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	id := string(c.Conf.IDBytes())
+	if cm.clients[id] == c {
+		delete(cm.clients, id)
+	}
+}
+
+// Send sends the command to the client and waits, up to the timeout, for its
+// reply.  If the command failed on the client, the reply's OK is false and
+// its Error is the client's error; an error is only returned if the command
+// couldn't be sent or the client didn't reply.
+func (cm *commander) Send(client string, op command.Op, arg string, timeout time.Duration) (commandReply, error) {
+	r := commandReply{Client: client, Command: op.String()}
+	cm.mu.Lock()
+	c, ok := cm.clients[client]
+	if !ok {
+		cm.mu.Unlock()
+		return r, errNotConnected
+	}
+	if !c.Protocol.Supports(message.Command) {
+		cm.mu.Unlock()
+		return r, errNoCommands
+	}
+	flake := cm.flake()
+	id := flake[:]
+	r.ID = fmt.Sprintf("%x", id)
+	ch := make(chan error, 1)
+	cm.pending[string(id)] = pendingCommand{client: client, ch: ch}
+	cm.mu.Unlock()
+
+	err := c.WriteMessage(websocket.BinaryMessage, message.Serialize(flake, message.Command, command.Serialize(id, op, arg)))
+	if err != nil {
+		cm.cancel(id)
+		return r, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-ch:
+		r.OK = err == nil
+		if err != nil {
+			r.Error = err.Error()
+		}
+		return r, nil
+	case <-timer.C:
+		cm.cancel(id)
+		return r, errCommandTimeout
+	}
+}
+
+// cancel stops waiting for the reply to the command with the id.
+func (cm *commander) cancel(id []byte) {
+	cm.mu.Lock()
+	delete(cm.pending, string(id))
+	cm.mu.Unlock()
+}
+
+// Reply passes the client's reply to the command that is waiting for it.
+// Replies to commands that are no longer being waited for, or that were sent
+// to another client, are logged and dropped.
+func (cm *commander) Reply(client string, r *command.Reply) {
+	cm.mu.Lock()
+	p, ok := cm.pending[string(r.IDBytes())]
+	if !ok {
+		cm.mu.Unlock()
+		log.Warn(
+			"reply to unknown command",
+			zap.String("op", "process command reply"),
+			zap.String("client", client),
+			zap.String("id", fmt.Sprintf("%x", r.IDBytes())),
+		)
+		return
+	}
+	if p.client != client {
+		cm.mu.Unlock()
+		log.Warn(
+			"reply to another client's command",
+			zap.String("op", "process command reply"),
+			zap.String("client", client),
+			zap.String("command client", p.client),
+			zap.String("id", fmt.Sprintf("%x", r.IDBytes())),
+		)
+		return
+	}
+	delete(cm.pending, string(r.IDBytes()))
+	cm.mu.Unlock()
+	p.ch <- r.Err()
+}
+
+// serveCommands handles /api/commands: POST sends the command in the body to
+// its client and responds with the client's reply.  It requires the admin
+// key.
+func serveCommands(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	var req commandRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	op := command.OpFromString(req.Command)
+	if op == command.Unknown {
+		http.Error(w, fmt.Sprintf("unknown command: %q", req.Command), http.StatusBadRequest)
+		return
+	}
+	if op == command.Burst {
+		_, _, err = command.ParseBurst(req.Arg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	reply, err := srvr.Commands.Send(req.Client, op, req.Arg, CommandTimeout)
+	switch err {
+	case nil:
+	case errNotConnected:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errNoCommands:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errCommandTimeout:
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	default:
+		log.Error(
+			err.Error(),
+			zap.String("op", "send command"),
+			zap.String("client", req.Client),
+			zap.String("command", op.String()),
+		)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, reply)
+}
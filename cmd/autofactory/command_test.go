@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mohae/autofact/command"
+	"github.com/mohae/autofact/message"
+	"github.com/mohae/snoflinga"
+	"github.com/uber-go/zap"
+)
+
+func TestCommander(t *testing.T) {
+	gen := snoflinga.New([]byte("server"))
+	cm := newCommander(func() snoflinga.Flake { return gen.Snowflake() })
+	_, err := cm.Send("abc", command.CollectNow, "", time.Second)
+	if err != errNotConnected {
+		t.Fatalf("got %v; want %v", err, errNotConnected)
+	}
+
+	// the client end of the connection
+	conns := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	defer ts.Close()
+	agent, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+	c := srvr.newClient([]byte("abc"))
+	c.WS = <-conns
+	c.Protocol.Kinds = []message.Kind{message.Command}
+	defer c.WS.Close()
+	cm.Add(c)
+
+	tests := []struct {
+		op    command.Op
+		arg   string
+		err   error
+		reply commandReply
+	}{
+		{command.SetLogLevel, "debug", nil, commandReply{Client: "abc", Command: "SetLogLevel", OK: true}},
+		{command.StopCollectors, "", errors.New("collectors are not running"), commandReply{Client: "abc", Command: "StopCollectors", Error: "collectors are not running"}},
+	}
+	for _, test := range tests {
+		// the agent replies to the command it receives
+		go func(replyErr error) {
+			_, p, err := agent.ReadMessage()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			msg := message.GetRootAsMessage(p, 0)
+			if message.Kind(msg.Kind()) != message.Command {
+				t.Errorf("kind: got %s; want %s", message.Kind(msg.Kind()), message.Command)
+				return
+			}
+			cmd := command.GetRootAsCommand(msg.DataBytes(), 0)
+			if command.Op(cmd.Op()) != test.op || string(cmd.Arg()) != test.arg {
+				t.Errorf("got %s %q; want %s %q", command.Op(cmd.Op()), cmd.Arg(), test.op, test.arg)
+			}
+			cm.Reply("abc", command.GetRootAsReply(command.SerializeReply(cmd.IDBytes(), replyErr), 0))
+		}(test.err)
+		r, err := cm.Send("abc", test.op, test.arg, time.Second)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.op, err)
+			continue
+		}
+		if r.ID == "" {
+			t.Errorf("%s: expected an id", test.op)
+		}
+		r.ID = ""
+		if r != test.reply {
+			t.Errorf("%s: got %+v; want %+v", test.op, r, test.reply)
+		}
+	}
+
+	// another client replies to the command with its ID: the forged reply
+	// is dropped and the command gets its client's reply.
+	defer func(l zap.Logger) { log = l }(log)
+	log = zap.New(zap.NewJSONEncoder(), zap.Output(zap.AddSync(ioutil.Discard)))
+	go func() {
+		_, p, err := agent.ReadMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		cmd := command.GetRootAsCommand(message.GetRootAsMessage(p, 0).DataBytes(), 0)
+		cm.Reply("xyz", command.GetRootAsReply(command.SerializeReply(cmd.IDBytes(), nil), 0))
+		cm.Reply("abc", command.GetRootAsReply(command.SerializeReply(cmd.IDBytes(), errors.New("collectors are not running")), 0))
+	}()
+	r, err := cm.Send("abc", command.StopCollectors, "", time.Second)
+	if err != nil {
+		t.Errorf("forged reply: unexpected error: %s", err)
+	} else if r.OK || r.Error != "collectors are not running" {
+		t.Errorf("forged reply: got %+v; want the client's error", r)
+	}
+
+	// the agent doesn't reply
+	go agent.ReadMessage()
+	_, err = cm.Send("abc", command.Reconnect, "", 10*time.Millisecond)
+	if err != errCommandTimeout {
+		t.Errorf("got %v; want %v", err, errCommandTimeout)
+	}
+	if len(cm.pending) != 0 {
+		t.Errorf("got %d pending commands; want 0", len(cm.pending))
+	}
+	cm.Remove(c)
+	_, err = cm.Send("abc", command.CollectNow, "", time.Second)
+	if err != errNotConnected {
+		t.Errorf("got %v; want %v", err, errNotConnected)
+	}
+}
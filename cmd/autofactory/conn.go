@@ -1,9 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"net/http"
+	"time"
 
-	"github.com/google/flatbuffers/go"
 	"github.com/gorilla/websocket"
 	"github.com/mohae/autofact"
 	"github.com/mohae/autofact/conf"
@@ -24,6 +25,12 @@ var upgrader = websocket.Upgrader{
 // information for the client, or creates a new client and clientID ( in
 // instances where the client has either never connected before or it's
 // information cannot be found)
+//
+// The client starts the handshake with a Hello that has its ID and the
+// protocol it supports; the server replies with a Hello with the protocol
+// they've agreed on, or, if they can't agree, closes the connection with the
+// reason.  Legacy clients start the handshake by sending their ID as a text
+// message instead.
 func serveClient(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -34,7 +41,15 @@ func serveClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
-	// first message is the clientID, if "" then get a new one
+	conn.SetReadLimit(srvr.MaxFrameSize)
+	// the read deadline is extended whenever a message, or a pong, is
+	// received.
+	conn.SetReadDeadline(time.Now().Add(autofact.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(autofact.PongWait))
+	})
+	// first message is the hello, or, for legacy clients, the clientID; if
+	// the clientID is "" then get a new one
 	typ, p, err := conn.ReadMessage()
 	if err != nil {
 		log.Error(
@@ -43,9 +58,37 @@ func serveClient(w http.ResponseWriter, r *http.Request) {
 		)
 		return
 	}
-	// if messageType isn't TextMessage, reject.  It should either be empty or
-	// have a client ID.
-	if typ != websocket.TextMessage {
+	var proto message.Protocol
+	switch typ {
+	case websocket.TextMessage:
+		proto = message.Protocol{
+			ID:         p,
+			Version:    message.LegacyProtocolVersion,
+			MinVersion: message.LegacyProtocolVersion,
+			Kinds:      message.LegacyKinds,
+			Codecs:     []string{message.CodecFlatbuffers},
+		}
+	case websocket.BinaryMessage:
+		err = message.Verify(p)
+		if err != nil {
+			closeHandshake(conn, "invalid hello: "+err.Error())
+			log.Error(
+				err.Error(),
+				zap.String("op", "verify hello"),
+			)
+			return
+		}
+		msg := message.GetRootAsMessage(p, 0)
+		if message.Kind(msg.Kind()) != message.Hello {
+			closeHandshake(conn, "expected a hello")
+			log.Error(
+				"invalid connection initiation message",
+				zap.String("kind", message.Kind(msg.Kind()).String()),
+			)
+			return
+		}
+		proto = message.GetProtocol(msg.DataBytes())
+	default:
 		conn.WriteMessage(websocket.CloseMessage, []byte("invalid socket initiation request"))
 		log.Error(
 			"invalid connection initation type",
@@ -53,9 +96,76 @@ func serveClient(w http.ResponseWriter, r *http.Request) {
 		)
 		return
 	}
+	proto, err = message.Negotiate(srvr.Protocol(), proto)
+	if err != nil {
+		closeHandshake(conn, err.Error())
+		log.Warn(
+			err.Error(),
+			zap.String("op", "negotiate protocol"),
+			zap.String("id", string(proto.ID)),
+		)
+		return
+	}
+	// clients that don't support pushing their healthbeat have it pulled.
+	c, b, err := connectClient(proto, srvr.HealthbeatPush && proto.Version >= message.PushHealthbeatVersion)
+	if err != nil { // don't do anything with error, func already handled logging.
+		closeHandshake(conn, refusal(err))
+		return
+	}
+	// the client needs the current connection
+	c.WS = conn
+	// send the agreed on protocol; legacy clients don't support it.
+	if proto.Supports(message.Hello) {
+		proto.ID = c.Conf.IDBytes()
+		srvr.WriteBinaryMessage(string(c.Conf.IDBytes()), c.WS, message.Hello, proto.Serialize())
+	}
+	// send the inf
+	c.WriteBinaryMessage(message.ClientConf, b)
+	// send EOM
+	c.WriteBinaryMessage(message.EOT, nil)
+	c.Connected()
+	// start a message handler for the client
+	doneCh := make(chan struct{})
+	if c.acks != nil {
+		go c.acks.Run(AckInterval, doneCh)
+	}
+	go c.Listen(conn, doneCh)
+	go c.Healthbeat(doneCh)
+	go c.Ping(conn, doneCh)
+	// wait for the done signal
+	<-doneCh
+	c.Disconnected()
+}
+
+// connectClient looks up the client with the protocol's ID, or, if the ID is
+// empty or unknown, creates a new client; sets up the client for the agreed
+// on protocol; and returns the client with its flatbuffer serialized
+// configuration.  push is whether the client is to push its healthbeat.  If
+// the client sent its host's fingerprint, the fingerprint may resolve to
+// another ID, see fingerprints.Resolve.  The hostname and labels the client
+// reported are saved to its configuration.
+func connectClient(proto message.Protocol, push bool) (*Client, []byte, error) {
 	var c *Client
 	var ok bool
-	if len(p) == 0 {
+	var err error
+	if srvr.Fingerprints != nil {
+		proto.ID, err = srvr.Fingerprints.Resolve(proto, &srvr.Inventory)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(proto.ID) > 0 {
+		c, ok = srvr.Client(proto.ID)
+		// the client may have been created by another instance of the
+		// cluster that hasn't been synced yet.
+		if !ok && srvr.Cluster != nil {
+			if v, found := srvr.Cluster.Fetch(proto.ID); found {
+				srvr.AddReplicatedClient(v)
+				c, ok = srvr.Client(proto.ID)
+			}
+		}
+	}
+	if !ok {
 		// get a new client and its ID
 		c, err = srvr.NewClient()
 		if err != nil {
@@ -63,52 +173,46 @@ func serveClient(w http.ResponseWriter, r *http.Request) {
 				err.Error(),
 				zap.String("op", "create client"),
 			)
-			return
+			return nil, nil, err
 		}
-		goto sendInf
 	}
-
-	c, ok = srvr.Client(p)
-	if !ok {
-		c, err = srvr.NewClient()
+	// c is a new Client for this connection: a connection that the client
+	// still has is left alone until Connected replaces it.
+	c.SetFuncs()
+	// update the client's conf with the host's current hostname and labels;
+	// clients that don't report them, or whose hostname or labels aren't
+	// valid, keep the ones they had.
+	info := c.Conf.Info()
+	if proto.Hostname != "" || len(proto.Labels) > 0 {
+		err = conf.ValidateHostname(proto.Hostname)
+		if err == nil {
+			err = info.SetLabels(proto.Labels)
+		}
 		if err != nil {
-			log.Error(
+			log.Warn(
 				err.Error(),
-				zap.String("op", "create client"),
+				zap.String("op", "set labels"),
+				zap.String("client", string(c.Conf.IDBytes())),
 			)
-			return
+		} else {
+			info.Hostname = proto.Hostname
 		}
 	}
-sendInf:
-	c.SetFuncs()
-	// update the node with the current inf
-	bldr := flatbuffers.NewBuilder(0)
-	h := bldr.CreateByteString(c.Conf.Hostname())
-	rr := bldr.CreateByteString(c.Conf.Region())
-	z := bldr.CreateByteString(c.Conf.Zone())
-	d := bldr.CreateByteString(c.Conf.DataCenter())
-	id := bldr.CreateByteVector(c.Conf.IDBytes())
-	conf.ClientStart(bldr)
-	conf.ClientAddID(bldr, id)
-	conf.ClientAddHostname(bldr, h)
-	conf.ClientAddRegion(bldr, rr)
-	conf.ClientAddZone(bldr, z)
-	conf.ClientAddDataCenter(bldr, d)
-	conf.ClientAddHealthbeatPeriod(bldr, c.Conf.HealthbeatPeriod())
-	conf.ClientAddMemInfoPeriod(bldr, c.Conf.MemInfoPeriod())
-	conf.ClientAddNetUsagePeriod(bldr, c.Conf.NetUsagePeriod())
-	conf.ClientAddCPUUtilizationPeriod(bldr, c.Conf.CPUUtilizationPeriod())
-	bldr.Finish(conf.ClientEnd(bldr))
-	b := bldr.Bytes[bldr.Head():]
+	info.HealthbeatPush = push
+	b := info.Serialize()
+	changed := !bytes.Equal(b, c.Conf.Serialize())
 	c.Conf = conf.GetRootAsClient(b, 0)
-	if err != nil {
-		log.Error(
-			err.Error(),
-			zap.String("op", "send message"),
-			zap.String("message type", "client configuration"),
-		)
-		return
+	if changed {
+		err = srvr.Bolt.SaveClient(c.Conf)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "save client"),
+				zap.String("client", string(c.Conf.IDBytes())),
+			)
+		}
 	}
+	c.setData()
 
 	log.Info(
 		"client connected",
@@ -117,16 +221,102 @@ sendInf:
 
 	// Add the client inf to the inventory
 	srvr.Inventory.AddClient(c.Conf)
-	// the client needs the current connection
-	c.WS = conn
-	// send the inf
-	srvr.WriteBinaryMessage(string(c.Conf.IDBytes()), c.WS, message.ClientConf, b)
-	// send EOM
-	srvr.WriteBinaryMessage(string(c.Conf.IDBytes()), c.WS, message.EOT, nil)
-	// start a message handler for the client
-	doneCh := make(chan struct{})
-	go c.Listen(doneCh)
-	go c.Healthbeat(doneCh)
-	// wait for the done signal
-	<-doneCh
+	if srvr.Fingerprints != nil {
+		srvr.Fingerprints.Map(&srvr.Bolt, proto.Fingerprint, c.Conf.IDBytes())
+	}
+	if srvr.Cluster != nil {
+		srvr.Cluster.Publish(c.Conf)
+	}
+	c.Protocol = proto
+	// the codec was negotiated from the server's codecs so this shouldn't fail.
+	c.codec, err = message.GetCodec(proto.Codec())
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "get codec"),
+			zap.String("client", string(c.Conf.IDBytes())),
+		)
+		return nil, nil, err
+	}
+	if proto.Supports(message.Ack) {
+		c.acks = newAcker(func(ids [][]byte) error {
+			return c.WriteMessage(websocket.BinaryMessage, message.Serialize(srvr.idGen.Snowflake(), message.Ack, message.SerializeAck(ids)))
+		})
+	}
+	if l := srvr.RateLimits.Get(string(c.Conf.IDBytes())); !l.Unlimited() {
+		c.limiter = newLimiter(l, time.Now())
+	}
+	return c, b, nil
+}
+
+// Connected records that the client has connected.  If the client still has
+// another connection, e.g. it reconnected before its old connection timed
+// out, the other connection is closed.
+func (c *Client) Connected() {
+	if prev := srvr.Inventory.Connect(c); prev != nil {
+		log.Warn(
+			"client reconnected: closing its previous connection",
+			zap.String("op", "connect"),
+			zap.String("client", string(c.Conf.IDBytes())),
+		)
+		prev.Close("replaced by a new connection")
+	}
+	srvr.Commands.Add(c)
+	c.Event("connected", "client connected")
+	if c.alerts != nil {
+		c.alerts.ClientUp(string(c.Conf.IDBytes()), time.Now())
+	}
+}
+
+// Disconnected records that the client has disconnected.  If it is a relay,
+// its clients are disconnected too.  If the client has already reconnected,
+// this connection was replaced: the client isn't down, so nothing is recorded
+// or alerted on.
+func (c *Client) Disconnected() {
+	c.endRelayedAll()
+	srvr.Commands.Remove(c)
+	if !srvr.Inventory.Disconnect(c) {
+		return
+	}
+	c.Event("disconnected", "client connection closed")
+	if c.alerts != nil {
+		c.alerts.ClientDown(c.Resource(), time.Now())
+	}
+}
+
+// Close ends the client's connection with the reason: a websocket connection
+// is closed, a relayed client's session is ended on its relay, and an HTTP
+// session is ended.  The connection's teardown is done by whatever is serving
+// it.
+func (c *Client) Close(reason string) {
+	switch {
+	case c.relay != nil:
+		c.relay.EndRelayed(c.dst, reason)
+	case c.WS != nil:
+		c.WS.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason), time.Now().Add(autofact.WriteWait))
+		// closing the connection unblocks its Listen.
+		c.WS.Close()
+	default:
+		srvr.HTTP.End(c)
+	}
+}
+
+// refusal returns the reason, for the client, that connectClient failed.
+func refusal(err error) string {
+	if err == errFingerprintConflict {
+		return err.Error()
+	}
+	return "internal error"
+}
+
+// closeHandshake closes the connection, during the handshake, with the reason.
+func closeHandshake(conn *websocket.Conn, reason string) {
+	err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, reason), time.Now().Add(autofact.WriteWait))
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "close connection"),
+			zap.String("reason", reason),
+		)
+	}
 }
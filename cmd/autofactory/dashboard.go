@@ -0,0 +1,247 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/uber-go/zap"
+)
+
+// clientInfo is a client's inventory entry as shown on the dashboard.
+type clientInfo struct {
+	ID   string            `json:"id"`
+	Tags map[string]string `json:"tags"`
+	clientStatus
+}
+
+// serveDashboard serves the dashboard's page.
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/dashboard/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHTML))
+}
+
+// serveDashboardClients serves the client inventory, with each client's
+// connection state, as JSON.
+func serveDashboardClients(w http.ResponseWriter, r *http.Request) {
+	ids := srvr.Inventory.Select(nil)
+	clients := make([]clientInfo, 0, len(ids))
+	for _, id := range ids {
+		c, ok := srvr.Inventory.Client([]byte(id))
+		if !ok {
+			continue
+		}
+		clients = append(clients, clientInfo{
+			ID:           id,
+			Tags:         resource(c),
+			clientStatus: srvr.Inventory.Status([]byte(id)),
+		})
+	}
+	writeJSON(w, clients)
+}
+
+// serveDashboardStream streams the decoded points of the requested clients,
+// client=id, as JSON over a websocket.
+func serveDashboardStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "upgrade dashboard connection"),
+		)
+		return
+	}
+	defer conn.Close()
+	sub := srvr.Stream.Subscribe(filter{Clients: splitValues(r.URL.Query()["client"])}, 0)
+	defer srvr.Stream.Unsubscribe(sub)
+	streamPoints(conn, sub)
+}
+
+// dashboardHTML is the dashboard: the client inventory and, for the selected
+// client, live charts of its data.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>autofactory</title>
+<style>
+body { font-family: sans-serif; margin: 1em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { padding: 0.25em 0.75em; text-align: left; border-bottom: 1px solid #ddd; }
+tr.client { cursor: pointer; }
+tr.client:hover, tr.selected { background: #eef; }
+.up { color: #080; }
+.down { color: #b00; }
+.chart { display: inline-block; margin: 0 1em 1em 0; }
+.chart h3 { margin: 0; font-size: 1em; }
+.legend span { margin-right: 1em; font-size: 0.8em; }
+</style>
+</head>
+<body>
+<h1>autofactory</h1>
+<table>
+<thead><tr><th>client</th><th>host</th><th>region</th><th>zone</th><th>datacenter</th><th>state</th><th>last seen</th></tr></thead>
+<tbody id="clients"></tbody>
+</table>
+<div id="selected"></div>
+<div id="charts"></div>
+<script>
+var windowMs = 5 * 60 * 1000;
+var colors = ["#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"];
+var selected = null;
+var ws = null;
+var charts = {};
+
+function text(v) { return v === undefined || v === null ? "" : String(v); }
+
+function ago(ts) {
+	var t = Date.parse(ts);
+	if (!t || t < 0) { return ""; }
+	var s = Math.round((Date.now() - t) / 1000);
+	return s < 60 ? s + "s ago" : Math.round(s / 60) + "m ago";
+}
+
+function loadClients() {
+	var req = new XMLHttpRequest();
+	req.onload = function() { renderClients(JSON.parse(req.responseText)); };
+	req.open("GET", "api/clients");
+	req.send();
+}
+
+function renderClients(clients) {
+	var tbody = document.getElementById("clients");
+	tbody.innerHTML = "";
+	clients.forEach(function(c) {
+		var tr = document.createElement("tr");
+		tr.className = "client" + (c.id === selected ? " selected" : "");
+		var state = c.connected ? "<span class=up>connected</span> " + ago(c.since) : "<span class=down>disconnected</span> " + ago(c.since);
+		[c.id, c.tags.host, c.tags.region, c.tags.zone, c.tags.datacenter].forEach(function(v) {
+			var td = document.createElement("td");
+			td.textContent = text(v);
+			tr.appendChild(td);
+		});
+		var td = document.createElement("td");
+		td.innerHTML = state;
+		tr.appendChild(td);
+		td = document.createElement("td");
+		td.textContent = ago(c.last_seen);
+		tr.appendChild(td);
+		tr.onclick = function() { selectClient(c.id); };
+		tbody.appendChild(tr);
+	});
+}
+
+function selectClient(id) {
+	if (ws) { ws.close(); }
+	selected = id;
+	charts = {};
+	document.getElementById("charts").innerHTML = "";
+	document.getElementById("selected").textContent = "client " + id;
+	["loadavg", "cpu %", "memory used %", "network bytes/s"].forEach(newChart);
+	var proto = location.protocol === "https:" ? "wss://" : "ws://";
+	ws = new WebSocket(proto + location.host + "/dashboard/stream?client=" + encodeURIComponent(id));
+	ws.onmessage = function(e) { handlePoint(JSON.parse(e.data)); };
+	loadClients();
+}
+
+function newChart(name) {
+	var div = document.createElement("div");
+	div.className = "chart";
+	var h = document.createElement("h3");
+	h.textContent = name;
+	var canvas = document.createElement("canvas");
+	canvas.width = 480;
+	canvas.height = 200;
+	var legend = document.createElement("div");
+	legend.className = "legend";
+	div.appendChild(h);
+	div.appendChild(canvas);
+	div.appendChild(legend);
+	document.getElementById("charts").appendChild(div);
+	charts[name] = {canvas: canvas, legend: legend, series: {}};
+}
+
+function add(chart, name, t, v) {
+	var c = charts[chart];
+	if (!c || !isFinite(v)) { return; }
+	var s = c.series[name];
+	if (!s) { s = c.series[name] = []; }
+	s.push([t, v]);
+	while (s.length && s[0][0] < t - windowMs) { s.shift(); }
+}
+
+function handlePoint(pt) {
+	var t = pt.time / 1e6;
+	var f = pt.fields;
+	switch (pt.name) {
+	case "loadavg":
+		add("loadavg", "one", t, f.one);
+		add("loadavg", "five", t, f.five);
+		add("loadavg", "fifteen", t, f.fifteen);
+		break;
+	case "cpus":
+		add("cpu %", pt.tags.cpu, t, f.usage);
+		break;
+	case "memory":
+		if (f.total_ram > 0) { add("memory used %", "ram", t, 100 * (1 - f.free_ram / f.total_ram)); }
+		if (f.total_swap > 0) { add("memory used %", "swap", t, 100 * (1 - f.free_swap / f.total_swap)); }
+		break;
+	case "interfaces":
+		var secs = pt.delta / 1e9;
+		if (secs > 0) {
+			add("network bytes/s", pt.tags.device + " rx", t, f["received.bytes"] / secs);
+			add("network bytes/s", pt.tags.device + " tx", t, f["transmitted.bytes"] / secs);
+		}
+		break;
+	}
+}
+
+function draw(c) {
+	var ctx = c.canvas.getContext("2d");
+	var w = c.canvas.width, h = c.canvas.height, pad = 40;
+	var now = Date.now(), lo = Infinity, hi = -Infinity;
+	var names = Object.keys(c.series).sort();
+	names.forEach(function(n) {
+		c.series[n].forEach(function(p) { lo = Math.min(lo, p[1]); hi = Math.max(hi, p[1]); });
+	});
+	ctx.clearRect(0, 0, w, h);
+	ctx.strokeStyle = "#ccc";
+	ctx.strokeRect(pad, 0, w - pad, h - 20);
+	if (lo === Infinity) { return; }
+	if (lo > 0) { lo = 0; }
+	if (hi === lo) { hi = lo + 1; }
+	ctx.fillStyle = "#666";
+	ctx.font = "10px sans-serif";
+	ctx.fillText(hi.toPrecision(3), 0, 10);
+	ctx.fillText(lo.toPrecision(3), 0, h - 22);
+	ctx.fillText("-5m", pad, h - 5);
+	ctx.fillText("now", w - 20, h - 5);
+	c.legend.innerHTML = "";
+	names.forEach(function(n, i) {
+		var color = colors[i % colors.length];
+		ctx.strokeStyle = color;
+		ctx.beginPath();
+		c.series[n].forEach(function(p, j) {
+			var x = pad + (w - pad) * (1 - (now - p[0]) / windowMs);
+			var y = (h - 20) * (1 - (p[1] - lo) / (hi - lo));
+			if (j === 0) { ctx.moveTo(x, y); } else { ctx.lineTo(x, y); }
+		});
+		ctx.stroke();
+		var span = document.createElement("span");
+		span.style.color = color;
+		span.textContent = n;
+		c.legend.appendChild(span);
+	});
+}
+
+setInterval(function() {
+	Object.keys(charts).forEach(function(k) { draw(charts[k]); });
+}, 1000);
+setInterval(loadClients, 5000);
+loadClients();
+</script>
+</body>
+</html>
+`
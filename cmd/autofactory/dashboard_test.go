@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInventoryConnect(t *testing.T) {
+	inv := newInventory()
+	old := srvr.newClient([]byte("abc"))
+	c := srvr.newClient([]byte("abc"))
+	if prev := inv.Connect(old); prev != nil {
+		t.Errorf("first connection: got a previous connection; want none")
+	}
+	// the client reconnects before its old connection is torn down.
+	if prev := inv.Connect(c); prev != old {
+		t.Errorf("reconnect: got %p; want the old connection %p", prev, old)
+	}
+	if inv.Disconnect(old) {
+		t.Error("old connection: got current; want replaced")
+	}
+	if !inv.Status([]byte("abc")).Connected {
+		t.Error("after the old connection's teardown: got disconnected; want connected")
+	}
+	if !inv.Disconnect(c) {
+		t.Error("current connection: got replaced; want current")
+	}
+	if inv.Status([]byte("abc")).Connected {
+		t.Error("after the current connection's teardown: got connected; want disconnected")
+	}
+}
+
+func TestServeDashboardClients(t *testing.T) {
+	old := srvr.newClient([]byte("dashboard1"))
+	c := srvr.newClient([]byte("dashboard1"))
+	srvr.Inventory.AddClient(c.Conf)
+	srvr.Inventory.AddClient(srvr.newClient([]byte("dashboard2")).Conf)
+	srvr.Inventory.Connect(old)
+	srvr.Inventory.Connect(c)
+	srvr.Inventory.Disconnect(old)
+
+	w := httptest.NewRecorder()
+	serveDashboardClients(w, httptest.NewRequest("GET", "/dashboard/api/clients", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q; want application/json", ct)
+	}
+	var clients []clientInfo
+	err := json.Unmarshal(w.Body.Bytes(), &clients)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the inventory is shared with the other tests: only check this test's
+	// clients.
+	got := make(map[string]clientInfo)
+	for _, v := range clients {
+		got[v.ID] = v
+	}
+	tests := []struct {
+		id        string
+		connected bool
+	}{
+		{"dashboard1", true},
+		{"dashboard2", false},
+	}
+	for _, test := range tests {
+		v, ok := got[test.id]
+		if !ok {
+			t.Errorf("%s: not found", test.id)
+			continue
+		}
+		if v.Connected != test.connected {
+			t.Errorf("%s: got connected %t; want %t", test.id, v.Connected, test.connected)
+		}
+		if v.Tags["client"] != test.id {
+			t.Errorf("%s: got client tag %q; want %q", test.id, v.Tags["client"], test.id)
+		}
+	}
+}
+
+func TestServeDashboard(t *testing.T) {
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/dashboard/", http.StatusOK},
+		{"/dashboard/other", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		serveDashboard(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.code {
+			t.Errorf("%s: got status %d; want %d", test.path, w.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mohae/autofact/db"
+	"github.com/mohae/autofact/message"
+	"github.com/uber-go/zap"
+)
+
+// EnforcePeriod is how often the embedded store's retention is enforced.
+var EnforcePeriod = time.Minute
+
+// newEmbeddedClient opens the embedded store and returns an EmbeddedClient
+// for it.  Writes are batched by size and interval.
+func newEmbeddedClient(name string, retention map[db.Resolution]time.Duration, size int, interval time.Duration) (*EmbeddedClient, error) {
+	s := db.NewStore()
+	for k, v := range retention {
+		s.Retention[k] = v
+	}
+	err := s.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	c := &EmbeddedClient{
+		Store:  s,
+		stopCh: make(chan struct{}),
+	}
+	c.batcher = newBatcher("embedded", size, interval, c.write)
+	return c, nil
+}
+
+// EmbeddedClient manages writing points to the embedded time-series store
+// and enforcing its retention.
+type EmbeddedClient struct {
+	*db.Store
+	*batcher
+	stopCh chan struct{}
+}
+
+// write writes the points' numeric fields as samples.  Each field of a point
+// is its own series; see SeriesName.
+func (c *EmbeddedClient) write(pts []point) error {
+	samples := make([]db.Sample, 0, len(pts)*4)
+	for _, pt := range pts {
+		for k, v := range pt.Fields {
+			f, ok := toFloat64(v)
+			if !ok {
+				continue
+			}
+			samples = append(samples, db.Sample{
+				Client: pt.Client,
+				Series: SeriesName(pt.Name, k, pt.Tags),
+				Time:   pt.Time,
+				Value:  f,
+			})
+		}
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+	return c.Store.Write(samples)
+}
+
+// Enforce enforces the store's retention, downsampling expired data, every
+// period until the client is closed.
+func (c *EmbeddedClient) Enforce(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			err := c.Store.Enforce(t)
+			if err != nil {
+				log.Error(
+					err.Error(),
+					zap.String("op", "enforce retention"),
+					zap.String("output", "embedded"),
+				)
+			}
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// Close flushes any pending points, stops the retention enforcement and
+// closes the store.
+func (c *EmbeddedClient) Close() {
+	c.batcher.Close()
+	select {
+	case <-c.stopCh:
+	default:
+		close(c.stopCh)
+	}
+	c.Store.Close()
+}
+
+// SeriesName returns the name of the series for a point's field:
+// name.field{tag=value,...}, with the tags sorted by key.  If there aren't
+// any tags, the braces are omitted, e.g. loadavg.one and
+// cpus.usage{cpu=cpu0}.
+func SeriesName(name, field string, tags map[string]string) string {
+	if len(tags) == 0 {
+		return name + "." + field
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + tags[k]
+	}
+	return name + "." + field + "{" + strings.Join(keys, ",") + "}"
+}
+
+// CPUUtilizationEmbedded processes CPUUtilization messages and writes them to
+// the embedded store.
+func (c *Client) CPUUtilizationEmbedded(msg *message.Message) {
+	c.Embedded.Send(c.CPUUtilizationPoints(msg))
+}
+
+// LoadAvgEmbedded processes LoadAvg messages and writes them to the embedded
+// store.
+func (c *Client) LoadAvgEmbedded(msg *message.Message) {
+	c.Embedded.Send(c.LoadAvgPoints(msg))
+}
+
+// MemInfoEmbedded processes MemInfo messages and writes them to the embedded
+// store.
+func (c *Client) MemInfoEmbedded(msg *message.Message) {
+	c.Embedded.Send(c.MemInfoPoints(msg))
+}
+
+// NetUsageEmbedded processes NetUsage messages and writes them to the
+// embedded store.
+func (c *Client) NetUsageEmbedded(msg *message.Message) {
+	c.Embedded.Send(c.NetUsagePoints(msg))
+}
+
+// EventEmbedded writes the event to the embedded store as an annotation: see
+// annotationPoint.
+func (c *Client) EventEmbedded(kind, msg string) {
+	c.Embedded.Send([]point{annotationPoint(c.EventPoint(kind, msg))})
+}
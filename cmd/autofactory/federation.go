@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mohae/autofact/conf"
+	"github.com/mohae/autofact/message"
+	"github.com/uber-go/zap"
+)
+
+const (
+	// FederationPath is the path that a regional autofactory forwards its
+	// clients' messages to.
+	FederationPath = "/federation/messages"
+	// FederationKeyHeader is the header with the federation key; requests
+	// without the key are refused.
+	FederationKeyHeader = "X-Autofact-Federation-Key"
+	// FederationTagsHeader is the header with the tags, as comma separated
+	// key=value pairs, that identify the region that forwarded the messages.
+	FederationTagsHeader = "X-Autofact-Federation-Tags"
+)
+
+// MaxForwardBuffer is the maximum number of messages held while the upstream
+// autofactory is unreachable; once there are more, the oldest are dropped.
+var MaxForwardBuffer = 65536
+
+// forwardKinds are the kinds of messages that are forwarded: the clients'
+// data.
+var forwardKinds = map[message.Kind]bool{
+	message.CPUUtilization: true,
+	message.LoadAvg:        true,
+	message.MemInfo:        true,
+	message.NetUsage:       true,
+	message.SysInfoJSON:    true,
+}
+
+// forwarded is a message, from a client, to forward.
+type forwarded struct {
+	client *conf.Client
+	p      []byte
+}
+
+// forwarder forwards the clients' messages to an upstream autofactory, e.g.
+// from the autofactory of each datacenter to a global one.  The messages are
+// forwarded as received, after being decoded with the client's codec, so that
+// the upstream autofactory writes them to its own output like the messages of
+// its own clients.  Each client's messages are preceded by its ClientConf so
+// that the upstream autofactory knows the client by its original ID.
+//
+// Messages are posted, in batches, every interval or once there are size of
+// them.  While the upstream autofactory is unreachable, the messages are
+// held, up to MaxForwardBuffer of them, and posted once it is reachable
+// again.
+type forwarder struct {
+	URL string
+	// Tags identify the region to the upstream autofactory; they are added
+	// to the data of the forwarded clients.
+	Tags     map[string]string
+	key      string
+	size     int
+	interval time.Duration
+	client   *http.Client
+	mu       sync.Mutex
+	queue    []forwarded
+	ready    chan struct{}
+	doneCh   chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+func newForwarder(addr, key string, tags map[string]string, size int, interval time.Duration) (*forwarder, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("an upstream address is required")
+	}
+	if key == "" {
+		return nil, fmt.Errorf("a federation key is required")
+	}
+	if size <= 0 {
+		size = BatchSize
+	}
+	if interval <= 0 {
+		interval = FlushInterval
+	}
+	return &forwarder{
+		URL:      strings.TrimRight(addr, "/") + FederationPath,
+		Tags:     tags,
+		key:      key,
+		size:     size,
+		interval: interval,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		ready:    make(chan struct{}, 1),
+		doneCh:   make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}, nil
+}
+
+// Forward queues the client's message to be forwarded.
+func (f *forwarder) Forward(c *conf.Client, p []byte) {
+	f.mu.Lock()
+	if len(f.queue) >= MaxForwardBuffer {
+		f.queue = f.queue[1:]
+		log.Warn(
+			"too many messages held: oldest message dropped",
+			zap.String("op", "forward"),
+		)
+	}
+	f.queue = append(f.queue, forwarded{client: c, p: p})
+	n := len(f.queue)
+	f.mu.Unlock()
+	if n >= f.size {
+		select {
+		case f.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run posts the queued messages every interval, or once there are size of
+// them, until Close is called.
+func (f *forwarder) Run() {
+	defer close(f.stopped)
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.ready:
+		case <-ticker.C:
+		case <-f.doneCh:
+			f.flush()
+			return
+		}
+		f.flush()
+	}
+}
+
+// Close stops forwarding and waits for one last attempt to post the queued
+// messages.  It is safe to call Close more than once.
+func (f *forwarder) Close() {
+	f.once.Do(func() { close(f.doneCh) })
+	<-f.stopped
+}
+
+// flush posts the queued messages in batches of size.  If a post fails, the
+// messages are put back and posted on the next flush; messages that the
+// upstream autofactory refused are dropped as posting them again won't change
+// that.
+func (f *forwarder) flush() {
+	for {
+		f.mu.Lock()
+		n := len(f.queue)
+		if n > f.size {
+			n = f.size
+		}
+		batch := f.queue[:n:n]
+		f.queue = f.queue[n:]
+		f.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		err := f.post(batch)
+		if err == nil {
+			continue
+		}
+		log.Error(
+			err.Error(),
+			zap.String("op", "forward"),
+			zap.String("upstream", f.URL),
+			zap.Int("count", n),
+		)
+		if se, ok := err.(statusError); ok && se.code < http.StatusInternalServerError && se.code != http.StatusTooManyRequests {
+			continue
+		}
+		f.mu.Lock()
+		f.queue = append(batch, f.queue...)
+		if len(f.queue) > MaxForwardBuffer {
+			f.queue = f.queue[len(f.queue)-MaxForwardBuffer:]
+		}
+		f.mu.Unlock()
+		return
+	}
+}
+
+// statusError is a response, from the upstream autofactory, that isn't OK.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.msg)
+}
+
+// post posts the messages, as frames, to the upstream autofactory.
+func (f *forwarder) post(batch []forwarded) error {
+	frames := make([][]byte, 0, len(batch)+1)
+	var last *conf.Client
+	for _, v := range batch {
+		if v.client != last {
+			frames = append(frames, message.Serialize(srvr.idGen.Snowflake(), message.ClientConf, v.client.Serialize()))
+			last = v.client
+		}
+		frames = append(frames, v.p)
+	}
+	var buf bytes.Buffer
+	message.WriteFrames(&buf, frames)
+	req, err := http.NewRequest("POST", f.URL, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", message.FrameContentType)
+	req.Header.Set(FederationKeyHeader, f.key)
+	req.Header.Set(FederationTagsHeader, formatTags(f.Tags))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return statusError{code: resp.StatusCode, msg: strings.TrimSpace(string(b))}
+	}
+	return nil
+}
+
+// formatTags formats the tags as comma separated key=value pairs, sorted by
+// key; parseTags parses them.
+func formatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]string, len(keys))
+	for i, k := range keys {
+		kvs[i] = k + "=" + tags[k]
+	}
+	return strings.Join(kvs, ",")
+}
+
+// federation holds the clients whose messages were forwarded by a regional
+// autofactory.
+type federation struct {
+	key     string
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+func newFederation(key string) *federation {
+	return &federation{key: key, clients: make(map[string]*Client)}
+}
+
+// Client returns the forwarded client for the conf, with the region's tags.
+// A client that isn't in the inventory, or whose conf has changed, is added to
+// it.
+func (fd *federation) Client(cf *conf.Client, tags map[string]string) *Client {
+	id := string(cf.IDBytes())
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	c, ok := fd.clients[id]
+	if ok && bytes.Equal(c.Conf.Serialize(), cf.Serialize()) && formatTags(c.tags) == formatTags(tags) {
+		return c
+	}
+	if old, ok := srvr.Inventory.Client(cf.IDBytes()); !ok || !bytes.Equal(old.Serialize(), cf.Serialize()) {
+		srvr.AddReplicatedClient(cf)
+	}
+	c, _ = srvr.Client(cf.IDBytes())
+	c.SetFuncs()
+	c.tags = tags
+	// forwarded clients aren't forwarded again.
+	c.forward = nil
+	c.setData()
+	fd.clients[id] = c
+	return c
+}
+
+// serveFederation receives the messages forwarded by a regional autofactory.
+// The request is frames of messages: a ClientConf message is followed by the
+// messages of that client.
+func serveFederation(w http.ResponseWriter, r *http.Request) {
+	fd := srvr.Federation
+	if fd == nil || subtle.ConstantTimeCompare([]byte(r.Header.Get(FederationKeyHeader)), []byte(fd.key)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tags, err := parseTags([]string{r.Header.Get(FederationTagsHeader)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msgs, err := message.ReadFrames(http.MaxBytesReader(w, r.Body, MaxHTTPBodySize), srvr.MaxFrameSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var c *Client
+	for _, p := range msgs {
+		err = message.Verify(p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		msg := message.GetRootAsMessage(p, 0)
+		k := message.Kind(msg.Kind())
+		if k == message.ClientConf {
+			cf := conf.GetRootAsClient(msg.DataBytes(), 0)
+			if len(cf.IDBytes()) == 0 {
+				http.Error(w, "client without an ID", http.StatusBadRequest)
+				return
+			}
+			c = fd.Client(cf, tags)
+			continue
+		}
+		if c == nil {
+			http.Error(w, "message without a client", http.StatusBadRequest)
+			return
+		}
+		if !forwardKinds[k] {
+			log.Warn(
+				"unexpected message kind",
+				zap.String("op", "federation"),
+				zap.String("client", string(c.Conf.IDBytes())),
+				zap.String("kind", k.String()),
+			)
+			continue
+		}
+		srvr.Inventory.Seen(c.Conf.IDBytes())
+		c.processBinaryMessage(p)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
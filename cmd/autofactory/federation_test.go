@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mohae/autofact/conf"
+	"github.com/mohae/autofact/message"
+	"github.com/mohae/snoflinga"
+)
+
+func TestFormatTags(t *testing.T) {
+	tags := map[string]string{"region": "us-east", "datacenter": "dc1"}
+	s := formatTags(tags)
+	if s != "datacenter=dc1,region=us-east" {
+		t.Errorf("got %q; want %q", s, "datacenter=dc1,region=us-east")
+	}
+	got, err := parseTags([]string{s})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got, tags) {
+		t.Errorf("got %v; want %v", got, tags)
+	}
+}
+
+func TestFederationTagsPrecedence(t *testing.T) {
+	info := conf.ClientInfo{
+		ID:       []byte("abcd1234"),
+		Hostname: "web01",
+	}
+	info.SetLabels(map[string]string{"region": "eu-west", "role": "web"})
+	// the client was forwarded by the us-east autofactory.
+	c := &Client{
+		Conf: conf.GetRootAsClient(info.Serialize(), 0),
+		tags: map[string]string{"region": "us-east", "client": "other", "host": "other"},
+	}
+	want := map[string]string{
+		"client": "abcd1234",
+		"host":   "web01",
+		"region": "us-east",
+		"role":   "web",
+	}
+	got := c.Resource()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestForwarderPost(t *testing.T) {
+	srvr.idGen = snoflinga.New([]byte("server"))
+	var frames [][]byte
+	var header http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		var err error
+		frames, err = message.ReadFrames(r.Body, message.DefaultMaxFrameSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	f, err := newForwarder(upstream.URL, "secret", map[string]string{"region": "us-east"}, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	a := srvr.newClient([]byte("a")).Conf
+	b := srvr.newClient([]byte("b")).Conf
+	var flake snoflinga.Flake
+	batch := []forwarded{
+		{client: a, p: message.Serialize(flake, message.LoadAvg, nil)},
+		{client: a, p: message.Serialize(flake, message.MemInfo, nil)},
+		{client: b, p: message.Serialize(flake, message.LoadAvg, nil)},
+	}
+	err = f.post(batch)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := header.Get(FederationKeyHeader); got != "secret" {
+		t.Errorf("key: got %q; want %q", got, "secret")
+	}
+	if got := header.Get(FederationTagsHeader); got != "region=us-east" {
+		t.Errorf("tags: got %q; want %q", got, "region=us-east")
+	}
+	// each client's messages are preceded by its conf.
+	want := []struct {
+		kind   message.Kind
+		client string
+	}{
+		{message.ClientConf, "a"},
+		{message.LoadAvg, ""},
+		{message.MemInfo, ""},
+		{message.ClientConf, "b"},
+		{message.LoadAvg, ""},
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("got %d frames; want %d", len(frames), len(want))
+	}
+	for i, w := range want {
+		msg := message.GetRootAsMessage(frames[i], 0)
+		if k := message.Kind(msg.Kind()); k != w.kind {
+			t.Errorf("%d: got kind %s; want %s", i, k, w.kind)
+			continue
+		}
+		if w.client == "" {
+			continue
+		}
+		c := conf.GetRootAsClient(msg.DataBytes(), 0)
+		if !bytes.Equal(c.IDBytes(), []byte(w.client)) {
+			t.Errorf("%d: got client %q; want %q", i, c.IDBytes(), w.client)
+		}
+	}
+}
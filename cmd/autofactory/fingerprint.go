@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mohae/autofact/db"
+	"github.com/mohae/autofact/message"
+	"github.com/uber-go/zap"
+)
+
+// Policies for a fingerprint conflict: either the client presented the ID of
+// a known client but its fingerprint belongs to another client, or its
+// fingerprint belongs to a client that is connected, e.g. the host was cloned
+// from the same image.
+const (
+	// ConflictKeep keeps the ID the client presented; a client that didn't
+	// present one gets a new ID.  The fingerprint is then mapped to the
+	// client's ID.
+	ConflictKeep = "keep"
+	// ConflictFingerprint uses the ID that the fingerprint is mapped to.
+	ConflictFingerprint = "fingerprint"
+	// ConflictReject refuses the client.
+	ConflictReject = "reject"
+)
+
+// errFingerprintConflict is returned when a client is refused because of a
+// fingerprint conflict.
+var errFingerprintConflict = errors.New("fingerprint conflict")
+
+// fingerprints maps the fingerprints of the clients' hosts to the clients'
+// IDs so that a client that has lost its ID, e.g. because its host was
+// reimaged, gets its ID back.  Each identifier of a fingerprint is mapped
+// separately: a host is recognized by any of them, in order of precedence.
+type fingerprints struct {
+	Policy string
+	mu     sync.Mutex
+	ids    map[string]string
+}
+
+func newFingerprints(policy string) (*fingerprints, error) {
+	switch policy {
+	case ConflictKeep, ConflictFingerprint, ConflictReject:
+	default:
+		return nil, fmt.Errorf("unknown fingerprint conflict policy: %q", policy)
+	}
+	return &fingerprints{Policy: policy, ids: make(map[string]string)}, nil
+}
+
+// Load loads the saved fingerprints.
+func (f *fingerprints) Load(b *db.Bolt) error {
+	fps, err := b.Fingerprints()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k, v := range fps {
+		f.ids[k] = string(v)
+	}
+	return nil
+}
+
+// Lookup returns the ID of the client that the fingerprint is mapped to; if
+// it isn't mapped to one, an empty string is returned.
+func (f *fingerprints) Lookup(fp message.Fingerprint) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, k := range fp.Keys() {
+		if id, ok := f.ids[k]; ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// Map maps the fingerprint to the client's ID and saves the identifiers whose
+// mapping changed.
+func (f *fingerprints) Map(b *db.Bolt, fp message.Fingerprint, id []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, k := range fp.Keys() {
+		if f.ids[k] == string(id) {
+			continue
+		}
+		f.ids[k] = string(id)
+		err := b.SaveFingerprint(k, id)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "save fingerprint"),
+				zap.String("client", string(id)),
+			)
+		}
+	}
+}
+
+// Resolve returns the ID to use for a client with the protocol's ID and
+// fingerprint.  A client without an ID, or with an unknown one, gets the ID
+// of the client its fingerprint is mapped to.  On a conflict, the policy is
+// applied; errFingerprintConflict is returned if the client is refused.
+func (f *fingerprints) Resolve(proto message.Protocol, inv *inventory) ([]byte, error) {
+	if proto.Fingerprint.IsZero() {
+		return proto.ID, nil
+	}
+	id := f.Lookup(proto.Fingerprint)
+	// the fingerprint isn't mapped, it is mapped to the client's ID, or the
+	// client it is mapped to no longer exists.
+	if id == "" || id == string(proto.ID) || !inv.ClientExists([]byte(id)) {
+		return proto.ID, nil
+	}
+	var conflict string
+	if len(proto.ID) > 0 && inv.ClientExists(proto.ID) {
+		conflict = "the fingerprint belongs to another client"
+	} else if inv.Status([]byte(id)).Connected {
+		conflict = "the fingerprint belongs to a connected client"
+	}
+	if conflict == "" {
+		log.Info(
+			"client recognized by fingerprint",
+			zap.String("op", "resolve fingerprint"),
+			zap.String("id", string(proto.ID)),
+			zap.String("client", id),
+		)
+		return []byte(id), nil
+	}
+	log.Warn(
+		conflict,
+		zap.String("op", "resolve fingerprint"),
+		zap.String("id", string(proto.ID)),
+		zap.String("client", id),
+		zap.String("policy", f.Policy),
+	)
+	switch f.Policy {
+	case ConflictFingerprint:
+		return []byte(id), nil
+	case ConflictReject:
+		return nil, errFingerprintConflict
+	}
+	return proto.ID, nil
+}
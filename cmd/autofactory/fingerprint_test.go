@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/mohae/autofact/message"
+	"github.com/uber-go/zap"
+)
+
+func TestFingerprintsResolve(t *testing.T) {
+	defer func(l zap.Logger) { log = l }(log)
+	log = zap.New(zap.NewJSONEncoder(), zap.Output(zap.AddSync(ioutil.Discard)))
+	inv := newInventory()
+	for _, id := range []string{"a", "b", "c"} {
+		inv.AddClient(testClientConf(id, id))
+	}
+	c := srvr.newClient([]byte("c"))
+	inv.Connect(c)
+	ids := map[string]string{
+		"machine-id:m1": "a",
+		// c is connected.
+		"machine-id:m2": "c",
+		// the client the fingerprint was mapped to no longer exists.
+		"machine-id:m3": "gone",
+	}
+	tests := []struct {
+		policy string
+		id     string
+		fp     string
+		want   string
+		err    error
+	}{
+		// no fingerprint: the ID is used as is.
+		{ConflictKeep, "b", "", "b", nil},
+		// the fingerprint isn't mapped.
+		{ConflictKeep, "", "m4", "", nil},
+		{ConflictReject, "b", "m4", "b", nil},
+		// the fingerprint is mapped to the client's ID.
+		{ConflictReject, "a", "m1", "a", nil},
+		// a client without an ID, or with an unknown one, gets its ID back.
+		{ConflictKeep, "", "m1", "a", nil},
+		{ConflictReject, "", "m1", "a", nil},
+		{ConflictKeep, "x", "m1", "a", nil},
+		// stale mapping: the client keeps the ID it presented.
+		{ConflictReject, "b", "m3", "b", nil},
+		{ConflictReject, "", "m3", "", nil},
+		// the fingerprint belongs to another client.
+		{ConflictKeep, "b", "m1", "b", nil},
+		{ConflictFingerprint, "b", "m1", "a", nil},
+		{ConflictReject, "b", "m1", "", errFingerprintConflict},
+		// the fingerprint belongs to a connected client, e.g. a clone.
+		{ConflictKeep, "", "m2", "", nil},
+		{ConflictFingerprint, "", "m2", "c", nil},
+		{ConflictReject, "", "m2", "", errFingerprintConflict},
+		{ConflictKeep, "x", "m2", "x", nil},
+	}
+	for i, test := range tests {
+		f, err := newFingerprints(test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range ids {
+			f.ids[k] = v
+		}
+		proto := message.Protocol{ID: []byte(test.id), Fingerprint: message.Fingerprint{MachineID: test.fp}}
+		id, err := f.Resolve(proto, &inv)
+		if err != test.err {
+			t.Errorf("%d: %s: got error %v; want %v", i, test.policy, err, test.err)
+			continue
+		}
+		if string(id) != test.want {
+			t.Errorf("%d: %s: got %q; want %q", i, test.policy, id, test.want)
+		}
+	}
+	if _, err := newFingerprints("other"); err == nil {
+		t.Error("unknown policy: expected an error; got none")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mohae/autofact"
+	"github.com/mohae/autofact/message"
+	"github.com/uber-go/zap"
+)
+
+var (
+	// HTTPSessionTimeout is how long a client using the HTTP transport can go
+	// without posting before it is disconnected.
+	HTTPSessionTimeout = time.Minute
+	// MaxHTTPPending is the maximum number of messages held for a client
+	// using the HTTP transport; when it is exceeded, the oldest message is
+	// dropped.
+	MaxHTTPPending = 1024
+	// MaxHTTPBodySize is the maximum size, in bytes, of a request body.
+	MaxHTTPBodySize int64 = 16 << 20
+)
+
+// httpSessions are the sessions of the clients using the HTTP transport, by
+// client ID.  A session starts with a Hello and lasts until the client
+// hasn't posted for HTTPSessionTimeout.
+type httpSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*httpSession
+}
+
+// httpSession is a connected client using the HTTP transport.
+type httpSession struct {
+	// mu serializes the client's requests.
+	mu   sync.Mutex
+	c    *Client
+	last time.Time
+	// done is closed when the session ends.
+	done chan struct{}
+}
+
+func newHTTPSessions() *httpSessions {
+	return &httpSessions{sessions: make(map[string]*httpSession)}
+}
+
+// Add starts a session for the connected client.  If the client already had
+// a session, it is ended.
+func (h *httpSessions) Add(c *Client, now time.Time) *httpSession {
+	sess := &httpSession{c: c, last: now, done: make(chan struct{})}
+	id := string(c.Conf.IDBytes())
+	h.mu.Lock()
+	prev, ok := h.sessions[id]
+	h.sessions[id] = sess
+	h.mu.Unlock()
+	if ok {
+		prev.end()
+	}
+	return sess
+}
+
+// Get returns the client's session and updates when it was last used.
+func (h *httpSessions) Get(id string, now time.Time) (*httpSession, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sess, ok := h.sessions[id]
+	if ok {
+		sess.last = now
+	}
+	return sess, ok
+}
+
+// Remove ends the session if it is the client's current session.
+func (h *httpSessions) Remove(sess *httpSession) {
+	h.mu.Lock()
+	id := string(sess.c.Conf.IDBytes())
+	ok := h.sessions[id] == sess
+	if ok {
+		delete(h.sessions, id)
+	}
+	h.mu.Unlock()
+	if ok {
+		sess.end()
+	}
+}
+
+// End ends the client's session if c is the session's client.
+func (h *httpSessions) End(c *Client) {
+	h.mu.Lock()
+	sess, ok := h.sessions[string(c.Conf.IDBytes())]
+	h.mu.Unlock()
+	if ok && sess.c == c {
+		h.Remove(sess)
+	}
+}
+
+// Expire ends the sessions that haven't been used for HTTPSessionTimeout.
+func (h *httpSessions) Expire(now time.Time) {
+	var expired []*httpSession
+	h.mu.Lock()
+	for id, sess := range h.sessions {
+		if now.Sub(sess.last) > HTTPSessionTimeout {
+			expired = append(expired, sess)
+			delete(h.sessions, id)
+		}
+	}
+	h.mu.Unlock()
+	for _, sess := range expired {
+		log.Warn(
+			"client timed out: ending session",
+			zap.String("op", "expire http session"),
+			zap.String("client", string(sess.c.Conf.IDBytes())),
+		)
+		sess.end()
+	}
+}
+
+// Run expires sessions every half HTTPSessionTimeout.
+func (h *httpSessions) Run() {
+	ticker := time.NewTicker(HTTPSessionTimeout / 2)
+	defer ticker.Stop()
+	for t := range ticker.C {
+		h.Expire(t)
+	}
+}
+
+// end ends the session.
+func (sess *httpSession) end() {
+	close(sess.done)
+	sess.c.Disconnected()
+}
+
+// serveHTTPHello starts the session of a client using the HTTP transport.
+// The request body is the client's Hello, as a frame.  The response body is
+// the server's Hello, the client's configuration, and an EOT, as frames; the
+// Hello is flatbuffer serialized, the others use the agreed on codec.
+//
+// Clients using the HTTP transport must support pushing their healthbeat
+// as it can't be pulled.
+func serveHTTPHello(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("%s not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	msgs, err := message.ReadFrames(http.MaxBytesReader(w, r.Body, MaxHTTPBodySize), srvr.MaxFrameSize)
+	if err == nil && len(msgs) != 1 {
+		err = fmt.Errorf("expected a hello, got %d messages", len(msgs))
+	}
+	if err == nil {
+		err = message.Verify(msgs[0])
+	}
+	var msg *message.Message
+	if err == nil {
+		msg = message.GetRootAsMessage(msgs[0], 0)
+		if message.Kind(msg.Kind()) != message.Hello {
+			err = fmt.Errorf("expected a hello, got %s", message.Kind(msg.Kind()))
+		}
+	}
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "http hello"),
+		)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	proto, err := message.Negotiate(srvr.Protocol(), message.GetProtocol(msg.DataBytes()))
+	if err == nil && proto.Version < message.PushHealthbeatVersion {
+		err = fmt.Errorf("the HTTP transport requires protocol version %d or later", message.PushHealthbeatVersion)
+	}
+	if err != nil {
+		log.Warn(
+			err.Error(),
+			zap.String("op", "negotiate protocol"),
+			zap.String("id", string(proto.ID)),
+		)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, b, err := connectClient(proto, true)
+	if err == errFingerprintConflict {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil { // don't do anything with error, func already handled logging.
+		http.Error(w, "unable to connect client", http.StatusInternalServerError)
+		return
+	}
+	// the client's other connection, if any, is closed before the session is
+	// started.
+	c.Connected()
+	sess := srvr.HTTP.Add(c, time.Now())
+	go c.Healthbeat(sess.done)
+
+	proto.ID = c.Conf.IDBytes()
+	resp := [][]byte{message.Serialize(srvr.idGen.Snowflake(), message.Hello, proto.Serialize())}
+	for _, m := range []struct {
+		k message.Kind
+		p []byte
+	}{{message.ClientConf, b}, {message.EOT, nil}} {
+		p, err := c.codec.Encode(message.Serialize(srvr.idGen.Snowflake(), m.k, m.p))
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "encode message"),
+				zap.String("client", string(c.Conf.IDBytes())),
+				zap.String("kind", m.k.String()),
+			)
+			http.Error(w, "unable to encode response", http.StatusInternalServerError)
+			return
+		}
+		resp = append(resp, p)
+	}
+	writeFrames(w, c, resp)
+}
+
+// serveHTTPMessages processes the messages posted by a client using the HTTP
+// transport.  The client is identified by the HTTPClientHeader; clients that
+// don't have a session get a 401 and are expected to send a Hello.  The
+// request body is the client's messages, as frames.  The messages are
+// processed like those received by websocket.  The response body is the
+// messages that are pending for the client, e.g. acknowledgements, commands,
+// and configuration, as frames.
+func serveHTTPMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("%s not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	sess, ok := srvr.HTTP.Get(r.Header.Get(autofact.HTTPClientHeader), time.Now())
+	if !ok {
+		http.Error(w, "no session: send a hello", http.StatusUnauthorized)
+		return
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	c := sess.c
+	msgs, err := message.ReadFrames(http.MaxBytesReader(w, r.Body, MaxHTTPBodySize), srvr.MaxFrameSize)
+	if err != nil {
+		c.Reject(err, "read messages")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	srvr.Inventory.Seen(c.Conf.IDBytes())
+	for _, p := range msgs {
+		if c.limiter != nil && !c.Limit(len(p)) {
+			if c.limiter.Policy == PolicyDisconnect {
+				srvr.HTTP.Remove(sess)
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			continue
+		}
+		p, err = c.codec.Decode(p)
+		if err != nil {
+			c.Reject(err, "decode message")
+			continue
+		}
+		err = message.Verify(p)
+		if err != nil {
+			c.Reject(err, "verify message")
+			continue
+		}
+		c.processBinaryMessage(p)
+	}
+	// acknowledge the messages in the response.
+	if c.acks != nil {
+		c.acks.Flush()
+	}
+	writeFrames(w, c, c.Pending())
+}
+
+// writeFrames writes the messages to the response as frames.
+func writeFrames(w http.ResponseWriter, c *Client, msgs [][]byte) {
+	var buf bytes.Buffer
+	message.WriteFrames(&buf, msgs)
+	w.Header().Set("Content-Type", message.FrameContentType)
+	_, err := w.Write(buf.Bytes())
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "write response"),
+			zap.String("client", string(c.Conf.IDBytes())),
+		)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClientPending(t *testing.T) {
+	max := MaxHTTPPending
+	MaxHTTPPending = 2
+	defer func() { MaxHTTPPending = max }()
+	// clients using the HTTP transport don't have a connection.
+	c := srvr.newClient([]byte("abc"))
+	err := c.WriteMessage(websocket.TextMessage, []byte("loadavg"))
+	if err == nil {
+		t.Error("text message: expected an error, got none")
+	}
+	for _, v := range []string{"a", "b", "c"} {
+		err = c.WriteMessage(websocket.BinaryMessage, []byte(v))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", v, err)
+		}
+	}
+	// the oldest message was dropped
+	want := [][]byte{[]byte("b"), []byte("c")}
+	if got := c.Pending(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got := c.Pending(); len(got) != 0 {
+		t.Errorf("got %q; want nothing pending", got)
+	}
+}
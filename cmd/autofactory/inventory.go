@@ -1,7 +1,9 @@
 package main
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/mohae/autofact/conf"
 )
@@ -9,12 +11,38 @@ import (
 // inventory holds information about all of the nodes the system knows about.
 type inventory struct {
 	clients map[string]*conf.Client
-	mu      sync.Mutex
+	status  map[string]*clientStatus
+	// conns are the clients' current connections.  A client that reconnects
+	// before its old connection has been torn down has both; only the
+	// current one changes its state.
+	conns map[string]*Client
+	mu    sync.Mutex
+}
+
+// clientStatus is the connection state of a client.
+type clientStatus struct {
+	Connected bool `json:"connected"`
+	// Since is when the client last connected or disconnected.
+	Since time.Time `json:"since"`
+	// LastSeen is when the last message was received from the client.
+	LastSeen time.Time `json:"last_seen"`
+	// LastHealthbeat is when the last healthbeat was received from the
+	// client.
+	LastHealthbeat time.Time `json:"last_healthbeat"`
+	// Rejected is the number of frames from the client that were rejected
+	// because they were too large or malformed.
+	Rejected uint64 `json:"rejected"`
+	// Throttled is the number of messages from the client that exceeded its
+	// rate limit; LastThrottled is when the last one was received.
+	Throttled     uint64    `json:"throttled"`
+	LastThrottled time.Time `json:"last_throttled"`
 }
 
 func newInventory() inventory {
 	return inventory{
 		clients: make(map[string]*conf.Client),
+		status:  make(map[string]*clientStatus),
+		conns:   make(map[string]*Client),
 	}
 }
 
@@ -51,3 +79,129 @@ func (i *inventory) Client(id []byte) (*conf.Client, bool) {
 	c, ok := i.clients[string(id)]
 	return c, ok
 }
+
+// Clients returns the information for all of the clients in the inventory.
+func (i *inventory) Clients() []*conf.Client {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	clients := make([]*conf.Client, 0, len(i.clients))
+	for _, c := range i.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// Select returns the IDs of the clients whose attributes match all of the
+// tags.  If there aren't any tags, all of the IDs are returned.
+func (i *inventory) Select(tags map[string]string) []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var ids []string
+	for id, c := range i.clients {
+		if matchTags(resource(c), tags) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// matchTags returns whether all of the tags are in attrs.
+func matchTags(attrs, tags map[string]string) bool {
+	for k, v := range tags {
+		if attrs[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Connect records that c is the client's current connection and returns the
+// connection it replaced, if any.
+func (i *inventory) Connect(c *Client) *Client {
+	id := c.Conf.IDBytes()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	prev := i.conns[string(id)]
+	i.conns[string(id)] = c
+	st := i.clientStatus(id)
+	st.Connected = true
+	st.Since = time.Now()
+	st.LastSeen = st.Since
+	st.LastHealthbeat = st.Since
+	return prev
+}
+
+// Disconnect records that c has disconnected and returns whether it was the
+// client's current connection.  A connection that has been replaced doesn't
+// change the client's state.
+func (i *inventory) Disconnect(c *Client) bool {
+	id := c.Conf.IDBytes()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.conns[string(id)] != c {
+		return false
+	}
+	delete(i.conns, string(id))
+	st := i.clientStatus(id)
+	st.Connected = false
+	st.Since = time.Now()
+	return true
+}
+
+// Seen updates when the client was last seen.
+func (i *inventory) Seen(id []byte) {
+	i.mu.Lock()
+	i.clientStatus(id).LastSeen = time.Now()
+	i.mu.Unlock()
+}
+
+// Reject increments the client's rejected frame count and returns it.
+func (i *inventory) Reject(id []byte) uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	st := i.clientStatus(id)
+	st.Rejected++
+	return st.Rejected
+}
+
+// Throttle increments the client's throttled message count and returns it.
+func (i *inventory) Throttle(id []byte, t time.Time) uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	st := i.clientStatus(id)
+	st.Throttled++
+	st.LastThrottled = t
+	return st.Throttled
+}
+
+// Healthbeat updates when the client's last healthbeat was received.
+func (i *inventory) Healthbeat(id []byte) {
+	i.mu.Lock()
+	i.clientStatus(id).LastHealthbeat = time.Now()
+	i.mu.Unlock()
+}
+
+// Status returns the client's connection state.  Clients that haven't
+// connected since autofactory started are not connected.
+func (i *inventory) Status(id []byte) clientStatus {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	st, ok := i.status[string(id)]
+	if !ok {
+		return clientStatus{}
+	}
+	return *st
+}
+
+// clientStatus returns the status for the ID, adding it if it doesn't exist.
+// This does not do any locking; it is assumed that the caller is properly
+// managing the lock's state.
+func (i *inventory) clientStatus(id []byte) *clientStatus {
+	st, ok := i.status[string(id)]
+	if !ok {
+		st = &clientStatus{}
+		i.status[string(id)] = st
+	}
+	return st
+}
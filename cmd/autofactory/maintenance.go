@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mohae/autofact/util"
+	"github.com/mohae/randchars"
+	"github.com/uber-go/zap"
+)
+
+// maintenance is a maintenance window.  While a window is active, its
+// clients' alerts aren't sent and their down alerts don't fire.  The clients
+// are the union of Clients and, if Tags is not empty, the clients whose
+// attributes, e.g. datacenter or group, match all of the Tags.
+type maintenance struct {
+	ID      string            `json:"id"`
+	Clients []string          `json:"clients,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	// Duration is used, for ad-hoc windows, to set EndsAt when it isn't
+	// specified; it is cleared once EndsAt is set.
+	Duration  util.Duration `json:"duration,omitempty"`
+	StartsAt  time.Time     `json:"starts_at"`
+	EndsAt    time.Time     `json:"ends_at"`
+	CreatedBy string        `json:"created_by,omitempty"`
+	Comment   string        `json:"comment,omitempty"`
+	// Started is whether the window's start has been written to the output
+	// and Targets are the clients it was written for; the end is written for
+	// the same clients.
+	Started bool     `json:"started"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+// Validate checks that the window can be used.
+func (m *maintenance) Validate() error {
+	if len(m.Clients) == 0 && len(m.Tags) == 0 {
+		return errors.New("maintenance: clients or tags required")
+	}
+	if !m.EndsAt.After(m.StartsAt) {
+		return errors.New("maintenance: ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// Active returns whether the window is in effect at t.
+func (m *maintenance) Active(t time.Time) bool {
+	return !t.Before(m.StartsAt) && t.Before(m.EndsAt)
+}
+
+// Match returns whether the client, with the attributes, is in the window.
+func (m *maintenance) Match(client string, attrs map[string]string) bool {
+	for _, v := range m.Clients {
+		if v == client {
+			return true
+		}
+	}
+	return len(m.Tags) > 0 && matchTags(attrs, m.Tags)
+}
+
+// AddMaintenance adds the maintenance window and persists it.  If the window
+// doesn't have an ID, one is generated; if it doesn't have a start, it starts
+// now, and if it doesn't have an end, it ends after its duration.
+func (a *alerter) AddMaintenance(m maintenance) (maintenance, error) {
+	if m.StartsAt.IsZero() {
+		m.StartsAt = time.Now()
+	}
+	if m.EndsAt.IsZero() {
+		m.EndsAt = m.StartsAt.Add(m.Duration.Duration)
+	}
+	m.Duration.Duration = 0
+	m.Started = false
+	m.Targets = nil
+	err := m.Validate()
+	if err != nil {
+		return m, err
+	}
+	var anns []annotation
+	a.mu.Lock()
+	if m.ID == "" {
+		for {
+			m.ID = string(randchars.AlphaNum(util.IDLen))
+			if _, ok := a.windows[m.ID]; !ok {
+				break
+			}
+		}
+	}
+	// a replaced window that has started is ended first.
+	if w, ok := a.windows[m.ID]; ok && w.Started {
+		anns = a.endMaintenance(w)
+	}
+	a.windows[m.ID] = &m
+	a.saveMaintenance(&m)
+	a.mu.Unlock()
+	a.writeAnnotations(anns)
+	return m, nil
+}
+
+// DeleteMaintenance removes the maintenance window, ending it if it has
+// started.  False is returned if it doesn't exist.
+func (a *alerter) DeleteMaintenance(id string) bool {
+	var anns []annotation
+	a.mu.Lock()
+	w, ok := a.windows[id]
+	if !ok {
+		a.mu.Unlock()
+		return false
+	}
+	if w.Started {
+		anns = a.endMaintenance(w)
+	}
+	a.deleteMaintenance(id)
+	a.mu.Unlock()
+	a.writeAnnotations(anns)
+	return true
+}
+
+// MaintenanceWindows returns the maintenance windows, sorted by start.
+func (a *alerter) MaintenanceWindows() []maintenance {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	windows := make([]maintenance, 0, len(a.windows))
+	for _, w := range a.windows {
+		windows = append(windows, *w)
+	}
+	sort.Sort(byMaintenanceStart(windows))
+	return windows
+}
+
+// inMaintenance returns whether the alert's client is in an active maintenance
+// window at t.  This does not do any locking; it is assumed that the caller is
+// properly managing the lock's state.
+func (a *alerter) inMaintenance(al *alert, t time.Time) bool {
+	for _, w := range a.windows {
+		if w.Active(t) && w.Match(al.Client, al.Labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateMaintenance starts the windows that are due and ends the windows that
+// are over.  The annotations of the windows that started or ended are returned
+// so that they can be written once the lock is released.  This does not do
+// any locking; it is assumed that the caller is properly managing the lock's
+// state.
+func (a *alerter) updateMaintenance(t time.Time) []annotation {
+	var anns []annotation
+	for id, w := range a.windows {
+		if !w.Started && w.Active(t) {
+			anns = append(anns, a.startMaintenance(w)...)
+		}
+		if !t.Before(w.EndsAt) {
+			if w.Started {
+				anns = append(anns, a.endMaintenance(w)...)
+			}
+			a.deleteMaintenance(id)
+		}
+	}
+	return anns
+}
+
+// annotation is an event to write to the output for a client.
+type annotation struct {
+	client string
+	msg    string
+}
+
+// startMaintenance starts the window and returns the annotations of its start
+// for each of its clients.
+func (a *alerter) startMaintenance(w *maintenance) []annotation {
+	w.Started = true
+	if a.targets != nil {
+		w.Targets = a.targets(w)
+	}
+	a.saveMaintenance(w)
+	return maintenanceAnnotations(w, "maintenance started")
+}
+
+// endMaintenance ends the window and returns the annotations of its end for
+// each of the clients its start was written for.
+func (a *alerter) endMaintenance(w *maintenance) []annotation {
+	w.Started = false
+	return maintenanceAnnotations(w, "maintenance ended")
+}
+
+// maintenanceAnnotations returns an annotation with the msg, and the window's
+// comment, for each of the window's clients.
+func maintenanceAnnotations(w *maintenance, msg string) []annotation {
+	if w.Comment != "" {
+		msg += ": " + w.Comment
+	}
+	anns := make([]annotation, len(w.Targets))
+	for i, id := range w.Targets {
+		anns[i] = annotation{client: id, msg: msg}
+	}
+	return anns
+}
+
+// writeAnnotations writes the annotations to the output as maintenance
+// events.  Outputs can be slow so the alerter's lock must not be held.
+func (a *alerter) writeAnnotations(anns []annotation) {
+	if a.annotate == nil {
+		return
+	}
+	for _, v := range anns {
+		a.annotate(v.client, "maintenance", v.msg)
+	}
+}
+
+// saveMaintenance persists the window.  Errors are logged.
+func (a *alerter) saveMaintenance(w *maintenance) {
+	if a.db == nil {
+		return
+	}
+	p, err := json.Marshal(w)
+	if err == nil {
+		err = a.db.SaveMaintenanceWindow(w.ID, p)
+	}
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "save maintenance window"),
+			zap.String("id", w.ID),
+		)
+	}
+}
+
+// deleteMaintenance removes the window.  Errors are logged.
+func (a *alerter) deleteMaintenance(id string) {
+	delete(a.windows, id)
+	if a.db == nil {
+		return
+	}
+	err := a.db.DeleteMaintenanceWindow(id)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "delete maintenance window"),
+			zap.String("id", id),
+		)
+	}
+}
+
+// restoreMaintenance loads the persisted maintenance windows.  This does not
+// do any locking; it is assumed that the caller is properly managing the
+// lock's state.
+func (a *alerter) restoreMaintenance() error {
+	saved, err := a.db.MaintenanceWindows()
+	if err != nil {
+		return err
+	}
+	for id, p := range saved {
+		var w maintenance
+		err = json.Unmarshal(p, &w)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "restore maintenance window"),
+				zap.String("id", id),
+			)
+			continue
+		}
+		a.windows[id] = &w
+	}
+	return nil
+}
+
+type byMaintenanceStart []maintenance
+
+func (b byMaintenanceStart) Len() int           { return len(b) }
+func (b byMaintenanceStart) Less(i, j int) bool { return b[i].StartsAt.Before(b[j].StartsAt) }
+func (b byMaintenanceStart) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// serveMaintenance handles /api/maintenance: GET lists the windows, POST adds
+// the window in the body, and DELETE removes the window with the id
+// parameter.  POST and DELETE require the admin key.
+func serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, srvr.Alerts.MaintenanceWindows())
+	case "POST":
+		if !authorizeAdmin(w, r) {
+			return
+		}
+		var m maintenance
+		err := json.NewDecoder(r.Body).Decode(&m)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m, err = srvr.Alerts.AddMaintenance(m)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, m)
+	case "DELETE":
+		if !authorizeAdmin(w, r) {
+			return
+		}
+		if !srvr.Alerts.DeleteMaintenance(r.URL.Query().Get("id")) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
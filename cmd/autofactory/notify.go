@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mohae/autofact/util"
+	"github.com/uber-go/zap"
+)
+
+// Notification defaults.
+var (
+	// DefaultGroupWait is how long a new group's alerts are held so that
+	// alerts that fire together are sent together.
+	DefaultGroupWait = 30 * time.Second
+	// DefaultRepeatInterval is how often a group that is still firing is
+	// sent again.
+	DefaultRepeatInterval = 4 * time.Hour
+	// NotifyTimeout is how long a notifier has to send a notification.
+	NotifyTimeout = 30 * time.Second
+)
+
+// notifyConf is the notification configuration in the rules file.
+type notifyConf struct {
+	// GroupBy are the labels alerts are grouped by; each group is sent as a
+	// single notification.  rule and severity can be used along with the
+	// alert labels.  Defaults to rule.
+	GroupBy        []string        `json:"group_by,omitempty"`
+	GroupWait      util.Duration   `json:"group_wait"`
+	RepeatInterval util.Duration   `json:"repeat_interval"`
+	Notifiers      []*notifierConf `json:"notifiers"`
+}
+
+// notifierConf configures a notification channel.
+type notifierConf struct {
+	Name string `json:"name"`
+	// Type is webhook, smtp, or exec.
+	Type string `json:"type"`
+	// Match are the labels an alert must have to be sent by this notifier,
+	// e.g. severity=critical.  rule and severity can be used along with the
+	// alert labels.
+	Match map[string]string `json:"match,omitempty"`
+
+	// webhook
+	URL string `json:"url,omitempty"`
+	// Format is the webhook payload: json, slack, or pagerduty.  Defaults to
+	// json.
+	Format     string `json:"format,omitempty"`
+	RoutingKey string `json:"routing_key,omitempty"` // pagerduty
+
+	// smtp
+	Address  string   `json:"address,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+
+	// exec
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Notifier returns the notifier for the configuration.
+func (c *notifierConf) Notifier() (notifier, error) {
+	if c.Name == "" {
+		return nil, errors.New("notifier: name required")
+	}
+	switch c.Type {
+	case "webhook":
+		if c.URL == "" {
+			return nil, fmt.Errorf("%s: url required", c.Name)
+		}
+		switch c.Format {
+		case "":
+			c.Format = "json"
+		case "json", "slack":
+		case "pagerduty":
+			if c.RoutingKey == "" {
+				return nil, fmt.Errorf("%s: routing_key required", c.Name)
+			}
+		default:
+			return nil, fmt.Errorf("%s: unsupported webhook format %q", c.Name, c.Format)
+		}
+		return &webhookNotifier{
+			URL:        c.URL,
+			Format:     c.Format,
+			RoutingKey: c.RoutingKey,
+			Conn:       &http.Client{Timeout: NotifyTimeout},
+		}, nil
+	case "smtp":
+		if c.Address == "" || c.From == "" || len(c.To) == 0 {
+			return nil, fmt.Errorf("%s: address, from, and to required", c.Name)
+		}
+		return &smtpNotifier{
+			Address:  c.Address,
+			From:     c.From,
+			To:       c.To,
+			Username: c.Username,
+			Password: c.Password,
+		}, nil
+	case "exec":
+		if c.Command == "" {
+			return nil, fmt.Errorf("%s: command required", c.Name)
+		}
+		return &execNotifier{Command: c.Command, Args: c.Args}, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported notifier type %q", c.Name, c.Type)
+	}
+}
+
+// notifier sends notifications.
+type notifier interface {
+	Notify(n notification) error
+}
+
+// notification is a group of alerts that is sent together.
+type notification struct {
+	// Status is firing if any of the alerts are firing, otherwise resolved.
+	Status string `json:"status"`
+	// Group are the labels the alerts were grouped by.
+	Group  map[string]string `json:"group"`
+	Alerts []alert           `json:"alerts"`
+}
+
+// Summary returns a one line description of the notification.
+func (n *notification) Summary() string {
+	var firing, resolved int
+	for _, a := range n.Alerts {
+		if a.State == StateFiring {
+			firing++
+		} else {
+			resolved++
+		}
+	}
+	keys := make([]string, 0, len(n.Group))
+	for k := range n.Group {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + n.Group[k]
+	}
+	return fmt.Sprintf("[%s] %s: %d firing, %d resolved", strings.ToUpper(n.Status), strings.Join(keys, " "), firing, resolved)
+}
+
+// Text returns the notification as plain text: the summary followed by a line
+// per alert.
+func (n *notification) Text() string {
+	var buf bytes.Buffer
+	buf.WriteString(n.Summary())
+	buf.WriteString("\n\n")
+	for _, a := range n.Alerts {
+		buf.WriteString(a.Summary())
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// Summary returns a one line description of the alert.
+func (a *alert) Summary() string {
+	s := fmt.Sprintf("[%s] %s: %s %s = %g", strings.ToUpper(a.State), a.Rule, a.Client, a.Series, a.Value)
+	if a.Severity != "" {
+		s += " (" + a.Severity + ")"
+	}
+	return s
+}
+
+// webhookNotifier POSTs notifications as JSON.
+type webhookNotifier struct {
+	URL        string
+	Format     string
+	RoutingKey string
+	Conn       *http.Client
+}
+
+// Notify sends the notification.  For pagerduty, each alert is sent as its
+// own event, using the alert ID as the dedup key.
+func (w *webhookNotifier) Notify(n notification) error {
+	switch w.Format {
+	case "slack":
+		return w.post(map[string]string{"text": n.Text()})
+	case "pagerduty":
+		for _, a := range n.Alerts {
+			err := w.post(w.pagerDutyEvent(a))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return w.post(n)
+	}
+}
+
+func (w *webhookNotifier) post(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return post(w.Conn, w.URL, "application/json", b, 0)
+}
+
+// pagerDutyEvent returns the Events API v2 event for the alert.
+func (w *webhookNotifier) pagerDutyEvent(a alert) map[string]interface{} {
+	action := "trigger"
+	if a.State == StateResolved {
+		action = "resolve"
+	}
+	// PagerDuty only accepts these severities
+	severity := a.Severity
+	switch severity {
+	case "critical", "error", "warning", "info":
+	default:
+		severity = "error"
+	}
+	source := a.Labels["host"]
+	if source == "" {
+		source = a.Client
+	}
+	return map[string]interface{}{
+		"routing_key":  w.RoutingKey,
+		"event_action": action,
+		"dedup_key":    a.ID(),
+		"payload": map[string]interface{}{
+			"summary":        a.Summary(),
+			"source":         source,
+			"severity":       severity,
+			"timestamp":      a.ActiveAt.Format(time.RFC3339),
+			"custom_details": a.Labels,
+		},
+	}
+}
+
+// smtpNotifier emails notifications.
+type smtpNotifier struct {
+	Address  string
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+// Notify sends the notification as a plain text email.  If a username is set,
+// PLAIN authentication is used.
+func (s *smtpNotifier) Notify(n notification) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Address)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.Summary())
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(n.Text(), "\n", "\r\n", -1))
+	return smtp.SendMail(s.Address, auth, s.From, s.To, msg.Bytes())
+}
+
+// execNotifier runs a command for each notification.  The notification is
+// written to the command's stdin as JSON; its status and summary are also
+// in the AUTOFACT_STATUS and AUTOFACT_SUMMARY environment variables.
+type execNotifier struct {
+	Command string
+	Args    []string
+}
+
+// Notify runs the command.  The command is killed if it doesn't finish within
+// the NotifyTimeout.
+func (e *execNotifier) Notify(n notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), NotifyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Env = append(os.Environ(), "AUTOFACT_STATUS="+n.Status, "AUTOFACT_SUMMARY="+n.Summary())
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s: %s", e.Command, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// namedNotifier is a notifier along with the labels that select the alerts
+// it sends.
+type namedNotifier struct {
+	Name  string
+	Match map[string]string
+	notifier
+}
+
+// notifyGroup is the notification state of a group of alerts.
+type notifyGroup struct {
+	Labels map[string]string
+	// Created is when the group was first seen.
+	Created time.Time
+	// LastSent is when the group was last sent; it is zero if the group
+	// hasn't been sent.
+	LastSent time.Time
+	// Sent is the state of each alert, by alert ID, when it was last sent.
+	Sent map[string]string
+}
+
+// dispatcher groups alerts and decides when each group is sent.
+type dispatcher struct {
+	GroupBy        []string
+	GroupWait      time.Duration
+	RepeatInterval time.Duration
+	Notifiers      []namedNotifier
+	groups         map[string]*notifyGroup
+}
+
+// newDispatcher returns a dispatcher for the configuration.  An error is
+// returned if any of the notifiers are invalid.
+func newDispatcher(c notifyConf) (*dispatcher, error) {
+	d := &dispatcher{
+		GroupBy:        c.GroupBy,
+		GroupWait:      c.GroupWait.Duration,
+		RepeatInterval: c.RepeatInterval.Duration,
+		groups:         make(map[string]*notifyGroup),
+	}
+	if len(d.GroupBy) == 0 {
+		d.GroupBy = []string{"rule"}
+	}
+	if d.GroupWait <= 0 {
+		d.GroupWait = DefaultGroupWait
+	}
+	if d.RepeatInterval <= 0 {
+		d.RepeatInterval = DefaultRepeatInterval
+	}
+	names := make(map[string]struct{}, len(c.Notifiers))
+	for _, nc := range c.Notifiers {
+		n, err := nc.Notifier()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := names[nc.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate notifier name", nc.Name)
+		}
+		names[nc.Name] = struct{}{}
+		d.Notifiers = append(d.Notifiers, namedNotifier{Name: nc.Name, Match: nc.Match, notifier: n})
+	}
+	return d, nil
+}
+
+// label returns the value of the alert's label.  rule and severity are the
+// alert's rule and severity.
+func (a *alert) label(k string) string {
+	switch k {
+	case "rule":
+		return a.Rule
+	case "severity":
+		return a.Severity
+	}
+	return a.Labels[k]
+}
+
+// matchLabels returns whether the alert has all of the labels.
+func (a *alert) matchLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		if a.label(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Dispatch returns the notifications that are due.  The alerts are the
+// alerts that can be notified, i.e. they aren't silenced.  A group is sent
+// once it is GroupWait old and either one of its alerts has fired or
+// resolved since it was last sent or it is still firing and RepeatInterval
+// has elapsed.  Resolved alerts are only sent if their firing was sent.
+func (d *dispatcher) Dispatch(alerts []alert, now time.Time) []notification {
+	current := make(map[string][]alert)
+	for _, a := range alerts {
+		labels := make([]string, len(d.GroupBy))
+		for i, k := range d.GroupBy {
+			labels[i] = k + "=" + a.label(k)
+		}
+		key := strings.Join(labels, ",")
+		g, ok := d.groups[key]
+		if !ok {
+			if a.State != StateFiring {
+				continue
+			}
+			g = &notifyGroup{
+				Labels:  make(map[string]string, len(d.GroupBy)),
+				Created: now,
+				Sent:    make(map[string]string),
+			}
+			for _, k := range d.GroupBy {
+				g.Labels[k] = a.label(k)
+			}
+			d.groups[key] = g
+		}
+		if a.State == StateResolved && g.Sent[a.ID()] != StateFiring {
+			continue
+		}
+		current[key] = append(current[key], a)
+	}
+	var ns []notification
+	for key, g := range d.groups {
+		as := current[key]
+		var firing, changed bool
+		for _, a := range as {
+			if a.State == StateFiring {
+				firing = true
+			}
+			if g.Sent[a.ID()] != a.State {
+				changed = true
+			}
+		}
+		// alerts that were sent as firing and are now gone, e.g. they were
+		// silenced, are forgotten.
+		for id := range g.Sent {
+			var ok bool
+			for _, a := range as {
+				if a.ID() == id {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				delete(g.Sent, id)
+			}
+		}
+		if len(as) == 0 {
+			delete(d.groups, key)
+			continue
+		}
+		if now.Sub(g.Created) < d.GroupWait {
+			continue
+		}
+		if !changed && !(firing && now.Sub(g.LastSent) >= d.RepeatInterval) {
+			continue
+		}
+		n := notification{Status: StateResolved, Group: g.Labels, Alerts: as}
+		if firing {
+			n.Status = StateFiring
+		}
+		ns = append(ns, n)
+		g.LastSent = now
+		for _, a := range as {
+			if a.State == StateResolved {
+				delete(g.Sent, a.ID())
+				continue
+			}
+			g.Sent[a.ID()] = a.State
+		}
+		if !firing {
+			delete(d.groups, key)
+		}
+	}
+	return ns
+}
+
+// Send sends the notification with each notifier that has alerts in it.
+// Errors are logged.
+func (d *dispatcher) Send(n notification) {
+	for _, nn := range d.Notifiers {
+		nt := n
+		if len(nn.Match) > 0 {
+			nt.Alerts = nil
+			for _, a := range n.Alerts {
+				if a.matchLabels(nn.Match) {
+					nt.Alerts = append(nt.Alerts, a)
+				}
+			}
+			if len(nt.Alerts) == 0 {
+				continue
+			}
+			nt.Status = StateResolved
+			for _, a := range nt.Alerts {
+				if a.State == StateFiring {
+					nt.Status = StateFiring
+					break
+				}
+			}
+		}
+		err := nn.Notify(nt)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "send notification"),
+				zap.String("notifier", nn.Name),
+				zap.String("summary", nt.Summary()),
+			)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mohae/autofact/message"
+)
+
+// newOpenTSDBClient returns an OpenTSDBClient that writes to the HTTP API at
+// addr.  Writes are batched by size and interval.
+func newOpenTSDBClient(addr string, size int, interval time.Duration, retries int) *OpenTSDBClient {
+	c := &OpenTSDBClient{
+		URL:     strings.TrimRight(addr, "/") + "/api/put",
+		Conn:    &http.Client{Timeout: 10 * time.Second},
+		Retries: retries,
+	}
+	c.batcher = newBatcher("opentsdb", size, interval, c.put)
+	return c
+}
+
+// OpenTSDBClient manages the writing of points to OpenTSDB's /api/put
+// endpoint.
+type OpenTSDBClient struct {
+	URL     string
+	Conn    *http.Client
+	Retries int
+	*batcher
+}
+
+// tsdbDatapoint is a datapoint as expected by /api/put.
+type tsdbDatapoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// put writes the points to OpenTSDB.  Each field of a point is a separate
+// metric: autofact.<name>.<field>.  Timestamps are sent in milliseconds.
+func (c *OpenTSDBClient) put(pts []point) error {
+	dps := make([]tsdbDatapoint, 0, len(pts)*4)
+	for _, pt := range pts {
+		tags := tsdbTags(pt)
+		for k, v := range pt.Fields {
+			f, ok := toFloat64(v)
+			if !ok {
+				continue
+			}
+			dps = append(dps, tsdbDatapoint{
+				Metric:    "autofact." + pt.Name + "." + k,
+				Timestamp: pt.Time / int64(time.Millisecond),
+				Value:     f,
+				Tags:      tags,
+			})
+		}
+	}
+	if len(dps) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(dps)
+	if err != nil {
+		return err
+	}
+	return post(c.Conn, c.URL, "application/json", b, c.Retries)
+}
+
+// tsdbTags returns the point's resource and tags as OpenTSDB tags.  OpenTSDB
+// rejects empty tag values and restricts the allowed characters, so empty
+// values are skipped and invalid characters are replaced with an _.
+func tsdbTags(pt point) map[string]string {
+	tags := make(map[string]string, len(pt.Resource)+len(pt.Tags))
+	for k, v := range pt.Resource {
+		if v != "" {
+			tags[tsdbSanitize(k)] = tsdbSanitize(v)
+		}
+	}
+	for k, v := range pt.Tags {
+		if v != "" {
+			tags[tsdbSanitize(k)] = tsdbSanitize(v)
+		}
+	}
+	return tags
+}
+
+func tsdbSanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-', r == '_', r == '.', r == '/':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// CPUUtilizationOpenTSDB processes CPUUtilization messages and writes them to
+// OpenTSDB.
+func (c *Client) CPUUtilizationOpenTSDB(msg *message.Message) {
+	c.OpenTSDB.Send(c.CPUUtilizationPoints(msg))
+}
+
+// LoadAvgOpenTSDB processes LoadAvg messages and writes them to OpenTSDB.
+func (c *Client) LoadAvgOpenTSDB(msg *message.Message) {
+	c.OpenTSDB.Send(c.LoadAvgPoints(msg))
+}
+
+// MemInfoOpenTSDB processes MemInfo messages and writes them to OpenTSDB.
+func (c *Client) MemInfoOpenTSDB(msg *message.Message) {
+	c.OpenTSDB.Send(c.MemInfoPoints(msg))
+}
+
+// NetUsageOpenTSDB processes NetUsage messages and writes them to OpenTSDB.
+func (c *Client) NetUsageOpenTSDB(msg *message.Message) {
+	c.OpenTSDB.Send(c.NetUsagePoints(msg))
+}
+
+// EventOpenTSDB writes the event to OpenTSDB as an annotation: see
+// annotationPoint.
+func (c *Client) EventOpenTSDB(kind, msg string) {
+	c.OpenTSDB.Send([]point{annotationPoint(c.EventPoint(kind, msg))})
+}
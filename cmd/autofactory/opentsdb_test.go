@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTSDBTags(t *testing.T) {
+	pt := point{
+		Resource: map[string]string{"client": "abc", "host": "web 01", "zone": "", "team:name": "ops"},
+		Tags:     map[string]string{"device": "eth0@1", "bad key": "x"},
+	}
+	want := map[string]string{
+		"client":    "abc",
+		"host":      "web_01",
+		"team_name": "ops",
+		"device":    "eth0_1",
+		"bad_key":   "x",
+	}
+	if got := tsdbTags(pt); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+// byMetric sorts datapoints by metric.
+type byMetric []tsdbDatapoint
+
+func (b byMetric) Len() int           { return len(b) }
+func (b byMetric) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byMetric) Less(i, j int) bool { return b[i].Metric < b[j].Metric }
+
+func TestOpenTSDBPut(t *testing.T) {
+	var body []byte
+	var path, ct string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		ct = r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	c := &OpenTSDBClient{
+		URL:  srv.URL + "/api/put",
+		Conn: &http.Client{Timeout: time.Second},
+	}
+	ts := int64(1500000000123) * int64(time.Millisecond)
+	err := c.put([]point{
+		{
+			Client:   "abc",
+			Name:     "loadavg",
+			Resource: map[string]string{"client": "abc", "host": "web01"},
+			Fields:   map[string]interface{}{"one": 0.5, "five": float32(0.25)},
+			Time:     ts,
+		},
+		// non-numeric fields aren't written.
+		{
+			Client:   "abc",
+			Name:     "events",
+			Resource: map[string]string{"client": "abc"},
+			Tags:     map[string]string{"kind": "connected"},
+			Fields:   map[string]interface{}{"message": "client connected"},
+			Time:     ts,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if path != "/api/put" || ct != "application/json" {
+		t.Errorf("got %s %s; want /api/put application/json", path, ct)
+	}
+	var dps []tsdbDatapoint
+	err = json.Unmarshal(body, &dps)
+	if err != nil {
+		t.Fatalf("unexpected error: %s: %s", err, body)
+	}
+	sort.Sort(byMetric(dps))
+	tags := map[string]string{"client": "abc", "host": "web01"}
+	want := []tsdbDatapoint{
+		{Metric: "autofact.loadavg.five", Timestamp: 1500000000123, Value: 0.25, Tags: tags},
+		{Metric: "autofact.loadavg.one", Timestamp: 1500000000123, Value: 0.5, Tags: tags},
+	}
+	if !reflect.DeepEqual(dps, want) {
+		t.Errorf("got %+v; want %+v", dps, want)
+	}
+	// nothing to write: nothing is posted.
+	body = nil
+	err = c.put([]point{{Name: "events", Fields: map[string]interface{}{"message": "x"}}})
+	if err != nil || body != nil {
+		t.Errorf("got %v, posted %q; want no error and nothing posted", err, body)
+	}
+}
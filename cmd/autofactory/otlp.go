@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohae/autofact/message"
+)
+
+// otlpResourceKeys maps resource tags to their OpenTelemetry semantic
+// convention names.  Tags that aren't in the map are prefixed with autofact.
+var otlpResourceKeys = map[string]string{
+	"client": "autofact.client.id",
+	"host":   "host.name",
+	"region": "cloud.region",
+	"zone":   "cloud.availability_zone",
+}
+
+// newOTLPClient returns an OTLPClient that exports to the OTLP/HTTP receiver
+// at addr.  Writes are batched by size and interval.
+func newOTLPClient(addr string, size int, interval time.Duration, retries int) *OTLPClient {
+	c := &OTLPClient{
+		URL:     strings.TrimRight(addr, "/") + "/v1/metrics",
+		Conn:    &http.Client{Timeout: 10 * time.Second},
+		Retries: retries,
+	}
+	c.batcher = newBatcher("otlp", size, interval, c.export)
+	return c
+}
+
+// OTLPClient manages the exporting of points to an OpenTelemetry collector
+// using OTLP/HTTP with JSON encoding.
+type OTLPClient struct {
+	URL     string
+	Conn    *http.Client
+	Retries int
+	*batcher
+}
+
+// The OTLP JSON encoding of ExportMetricsServiceRequest.  Only what autofact
+// uses is defined.  Per the proto3 JSON mapping, 64 bit integers are strings.
+type otlpExportRequest struct {
+	ResourceMetrics []*otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource        `json:"resource"`
+	ScopeMetrics []*otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope     `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          *float64       `json:"asDouble,omitempty"`
+	AsInt             string         `json:"asInt,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// aggregationTemporalityDelta is AGGREGATION_TEMPORALITY_DELTA.
+const aggregationTemporalityDelta = 1
+
+// export sends the points to the collector.  Points are grouped by client,
+// with the client's attributes as the resource.  Points with a Delta are
+// monotonic delta sums, everything else is a gauge.
+func (c *OTLPClient) export(pts []point) error {
+	var req otlpExportRequest
+	scopes := make(map[string]*otlpScopeMetrics)
+	metrics := make(map[string]*otlpMetric)
+	for _, pt := range pts {
+		sm, ok := scopes[pt.Client]
+		if !ok {
+			sm = &otlpScopeMetrics{Scope: otlpScope{Name: "autofact"}}
+			scopes[pt.Client] = sm
+			req.ResourceMetrics = append(req.ResourceMetrics, &otlpResourceMetrics{
+				Resource:     otlpResource{Attributes: otlpResourceAttributes(pt.Resource)},
+				ScopeMetrics: []*otlpScopeMetrics{sm},
+			})
+		}
+		attrs := otlpAttributes(pt.Tags)
+		for k, v := range pt.Fields {
+			dp := otlpNumberDataPoint{
+				Attributes:   attrs,
+				TimeUnixNano: strconv.FormatInt(pt.Time, 10),
+			}
+			if s, ok := intString(v); ok {
+				dp.AsInt = s
+			} else if f, ok := toFloat64(v); ok {
+				dp.AsDouble = &f
+			} else {
+				continue
+			}
+			name := "autofact." + pt.Name + "." + k
+			m, ok := metrics[pt.Client+" "+name]
+			if !ok {
+				m = &otlpMetric{Name: name}
+				if pt.Delta > 0 {
+					m.Sum = &otlpSum{AggregationTemporality: aggregationTemporalityDelta, IsMonotonic: true}
+				} else {
+					m.Gauge = &otlpGauge{}
+				}
+				metrics[pt.Client+" "+name] = m
+				sm.Metrics = append(sm.Metrics, m)
+			}
+			if m.Sum != nil {
+				dp.StartTimeUnixNano = strconv.FormatInt(pt.Time-pt.Delta, 10)
+				m.Sum.DataPoints = append(m.Sum.DataPoints, dp)
+				continue
+			}
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, dp)
+		}
+	}
+	if len(req.ResourceMetrics) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return post(c.Conn, c.URL, "application/json", b, c.Retries)
+}
+
+// otlpResourceAttributes returns the client's resource tags as OTLP resource
+// attributes.
+func otlpResourceAttributes(res map[string]string) []otlpKeyValue {
+	attrs := []otlpKeyValue{{Key: "service.name", Value: otlpAnyValue{StringValue: "autofact"}}}
+	for _, k := range sortedKeys(res) {
+		name, ok := otlpResourceKeys[k]
+		if !ok {
+			name = "autofact." + k
+		}
+		attrs = append(attrs, otlpKeyValue{Key: name, Value: otlpAnyValue{StringValue: res[k]}})
+	}
+	return attrs
+}
+
+// otlpAttributes returns the tags as OTLP attributes.
+func otlpAttributes(tags map[string]string) []otlpKeyValue {
+	if len(tags) == 0 {
+		return nil
+	}
+	attrs := make([]otlpKeyValue, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		attrs = append(attrs, otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: tags[k]}})
+	}
+	return attrs
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CPUUtilizationOTLP processes CPUUtilization messages and exports them using
+// OTLP.
+func (c *Client) CPUUtilizationOTLP(msg *message.Message) {
+	c.OTLP.Send(c.CPUUtilizationPoints(msg))
+}
+
+// LoadAvgOTLP processes LoadAvg messages and exports them using OTLP.
+func (c *Client) LoadAvgOTLP(msg *message.Message) {
+	c.OTLP.Send(c.LoadAvgPoints(msg))
+}
+
+// MemInfoOTLP processes MemInfo messages and exports them using OTLP.
+func (c *Client) MemInfoOTLP(msg *message.Message) {
+	c.OTLP.Send(c.MemInfoPoints(msg))
+}
+
+// NetUsageOTLP processes NetUsage messages and exports them using OTLP.
+func (c *Client) NetUsageOTLP(msg *message.Message) {
+	c.OTLP.Send(c.NetUsagePoints(msg))
+}
+
+// EventOTLP exports the event using OTLP as an annotation: see
+// annotationPoint.
+func (c *Client) EventOTLP(kind, msg string) {
+	c.OTLP.Send([]point{annotationPoint(c.EventPoint(kind, msg))})
+}
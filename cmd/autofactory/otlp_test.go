@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOTLPExport(t *testing.T) {
+	var body []byte
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+	c := newOTLPClient(srv.URL+"/", 10, time.Minute, 0)
+	c.Conn.Timeout = time.Second
+	res := map[string]string{"client": "abc", "host": "web01", "role": "db"}
+	err := c.export([]point{
+		{Client: "abc", Name: "loadavg", Resource: res, Fields: map[string]interface{}{"one": 0.5}, Time: 2000},
+		{Client: "abc", Name: "interfaces", Resource: res, Tags: map[string]string{"device": "eth0"}, Fields: map[string]interface{}{"received.bytes": uint64(42)}, Time: 2000, Delta: 1000},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if path != "/v1/metrics" {
+		t.Errorf("got path %s; want /v1/metrics", path)
+	}
+	var req otlpExportRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s: %s", err, body)
+	}
+	if len(req.ResourceMetrics) != 1 {
+		t.Fatalf("got %d resource metrics; want 1", len(req.ResourceMetrics))
+	}
+	rm := req.ResourceMetrics[0]
+	attrs := make(map[string]string)
+	for _, kv := range rm.Resource.Attributes {
+		attrs[kv.Key] = kv.Value.StringValue
+	}
+	wantAttrs := map[string]string{
+		"service.name":       "autofact",
+		"autofact.client.id": "abc",
+		"host.name":          "web01",
+		"autofact.role":      "db",
+	}
+	if !reflect.DeepEqual(attrs, wantAttrs) {
+		t.Errorf("got resource attributes %v; want %v", attrs, wantAttrs)
+	}
+	if len(rm.ScopeMetrics) != 1 || len(rm.ScopeMetrics[0].Metrics) != 2 {
+		t.Fatalf("got %+v; want 1 scope with 2 metrics", rm.ScopeMetrics)
+	}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		switch m.Name {
+		case "autofact.loadavg.one":
+			if m.Gauge == nil || len(m.Gauge.DataPoints) != 1 {
+				t.Errorf("%s: got %+v; want a gauge with 1 datapoint", m.Name, m)
+				continue
+			}
+			dp := m.Gauge.DataPoints[0]
+			if dp.AsDouble == nil || *dp.AsDouble != 0.5 || dp.TimeUnixNano != "2000" {
+				t.Errorf("%s: got %+v; want 0.5 at 2000", m.Name, dp)
+			}
+		case "autofact.interfaces.received.bytes":
+			if m.Sum == nil || !m.Sum.IsMonotonic || m.Sum.AggregationTemporality != aggregationTemporalityDelta || len(m.Sum.DataPoints) != 1 {
+				t.Errorf("%s: got %+v; want a monotonic delta sum with 1 datapoint", m.Name, m)
+				continue
+			}
+			dp := m.Sum.DataPoints[0]
+			if dp.AsInt != "42" || dp.StartTimeUnixNano != "1000" || dp.TimeUnixNano != "2000" {
+				t.Errorf("%s: got %+v; want 42 from 1000 to 2000", m.Name, dp)
+			}
+			if len(dp.Attributes) != 1 || dp.Attributes[0].Key != "device" || dp.Attributes[0].Value.StringValue != "eth0" {
+				t.Errorf("%s: got attributes %+v; want device=eth0", m.Name, dp.Attributes)
+			}
+		default:
+			t.Errorf("unexpected metric %s", m.Name)
+		}
+	}
+}
@@ -13,6 +13,10 @@ const (
 	Unsupported Type = iota
 	File
 	InfluxDB
+	OpenTSDB
+	OTLP
+	Postgres
+	Embedded
 )
 
 // TypeFromString returns the Type for a given string.  All input strings are
@@ -24,6 +28,14 @@ func TypeFromString(s string) Type {
 		return File
 	case "influxdb", "influx":
 		return InfluxDB
+	case "opentsdb", "tsdb":
+		return OpenTSDB
+	case "otlp", "opentelemetry":
+		return OTLP
+	case "postgres", "postgresql", "timescaledb":
+		return Postgres
+	case "embedded":
+		return Embedded
 	default:
 		return Unsupported
 	}
@@ -4,9 +4,9 @@ package output
 
 import "fmt"
 
-const _Type_name = "UnsupportedFileInfluxDB"
+const _Type_name = "UnsupportedFileInfluxDBOpenTSDBOTLPPostgresEmbedded"
 
-var _Type_index = [...]uint8{0, 11, 15, 23}
+var _Type_index = [...]uint8{0, 11, 15, 23, 31, 35, 43, 51}
 
 func (i Type) String() string {
 	if i < 0 || i >= Type(len(_Type_index)-1) {
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohae/autofact/conf"
+	"github.com/mohae/autofact/message"
+	"github.com/mohae/joefriday/cpu/cpuutil/flat"
+	"github.com/mohae/joefriday/net/netusage/flat"
+	"github.com/mohae/joefriday/sysinfo/loadavg/flat"
+	"github.com/mohae/joefriday/sysinfo/mem/flat"
+)
+
+// point is an output agnostic representation of a decoded datapoint.  Outputs
+// that don't have their own client library build their payloads from points.
+type point struct {
+	// Client is the ID of the client the point came from.
+	Client string `json:"client"`
+	// Name is the measurement name, e.g. cpus, loadavg.
+	Name string `json:"name"`
+	// Resource contains the attributes of the client that the data came from.
+	Resource map[string]string `json:"resource,omitempty"`
+	// Tags contains point specific tags, e.g. the cpu or device.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Fields contains the values, keyed by field name.
+	Fields map[string]interface{} `json:"fields"`
+	// Time is the timestamp of the point in nanoseconds.
+	Time int64 `json:"time"`
+	// Delta is the period, in nanoseconds, that the fields cover when the
+	// fields are counters over an interval instead of gauges.  For gauges
+	// this is 0.
+	Delta int64 `json:"delta,omitempty"`
+}
+
+// Resource returns the client's attributes, keyed by tag name.  Only the
+// attributes that have a value are included.
+func (c *Client) Resource() map[string]string {
+	r := resource(c.Conf)
+	// the tags identify the region that forwarded the client's data: they
+	// take precedence over the client's own attributes, other than its ID
+	// and hostname.
+	for k, v := range c.tags {
+		if k == "client" || k == "host" {
+			continue
+		}
+		r[k] = v
+	}
+	return r
+}
+
+// resource returns the attributes of the client conf, keyed by tag name.  The
+// client's labels are included; they don't override its ID, hostname,
+// location, or role.
+func resource(c *conf.Client) map[string]string {
+	r := make(map[string]string)
+	for i := 0; i < c.LabelsLength(); i++ {
+		kv := strings.SplitN(string(c.Labels(i)), "=", 2)
+		if len(kv) == 2 && len(kv[1]) > 0 {
+			r[kv[0]] = kv[1]
+		}
+	}
+	attrs := []struct {
+		k string
+		v []byte
+	}{
+		{"client", c.IDBytes()},
+		{"host", c.Hostname()},
+		{conf.LabelRegion, c.Region()},
+		{conf.LabelZone, c.Zone()},
+		{conf.LabelDataCenter, c.DataCenter()},
+		{conf.LabelRole, c.Role()},
+	}
+	for _, a := range attrs {
+		if len(a.v) > 0 {
+			r[a.k] = string(a.v)
+		} else {
+			delete(r, a.k)
+		}
+	}
+	return r
+}
+
+// CPUUtilizationPoints returns the points for a CPUUtilization message; each
+// cpu is its own point.
+func (c *Client) CPUUtilizationPoints(msg *message.Message) []point {
+	cpus := cpuutil.Deserialize(msg.DataBytes())
+	res := c.Resource()
+	pts := make([]point, 0, len(cpus.CPU))
+	for _, cpu := range cpus.CPU {
+		pts = append(pts, point{
+			Client:   res["client"],
+			Name:     "cpus",
+			Resource: res,
+			Tags:     map[string]string{"cpu": cpu.ID},
+			Fields: map[string]interface{}{
+				"usage":  float32(cpu.Usage) / 100.0,
+				"user":   float32(cpu.User) / 100.0,
+				"nice":   float32(cpu.Nice) / 100.0,
+				"system": float32(cpu.System) / 100.0,
+				"idle":   float32(cpu.Idle) / 100.0,
+				"iowait": float32(cpu.IOWait) / 100.0,
+			},
+			Time: cpus.Timestamp,
+		})
+	}
+	return pts
+}
+
+// LoadAvgPoints returns the point for a LoadAvg message.
+func (c *Client) LoadAvgPoints(msg *message.Message) []point {
+	l := loadavg.Deserialize(msg.DataBytes())
+	res := c.Resource()
+	return []point{
+		{
+			Client:   res["client"],
+			Name:     "loadavg",
+			Resource: res,
+			Fields: map[string]interface{}{
+				"one":     l.One,
+				"five":    l.Five,
+				"fifteen": l.Fifteen,
+			},
+			Time: l.Timestamp,
+		},
+	}
+}
+
+// MemInfoPoints returns the point for a MemInfo message.
+func (c *Client) MemInfoPoints(msg *message.Message) []point {
+	m := mem.Deserialize(msg.DataBytes())
+	res := c.Resource()
+	return []point{
+		{
+			Client:   res["client"],
+			Name:     "memory",
+			Resource: res,
+			Fields: map[string]interface{}{
+				"total_ram":  m.TotalRAM,
+				"free_ram":   m.FreeRAM,
+				"shared_ram": m.SharedRAM,
+				"buffer_ram": m.BufferRAM,
+				"total_swap": m.TotalSwap,
+				"free_swap":  m.FreeSwap,
+			},
+			Time: m.Timestamp,
+		},
+	}
+}
+
+// NetUsagePoints returns the points for a NetUsage message; each interface is
+// its own point.  The values are the usage since the previous collection so
+// the point's Delta is set to the message's TimeDelta.
+func (c *Client) NetUsagePoints(msg *message.Message) []point {
+	devs := netusage.Deserialize(msg.DataBytes())
+	res := c.Resource()
+	pts := make([]point, 0, len(devs.Device))
+	for _, dev := range devs.Device {
+		pts = append(pts, point{
+			Client:   res["client"],
+			Name:     "interfaces",
+			Resource: res,
+			Tags:     map[string]string{"device": dev.Name},
+			Fields: map[string]interface{}{
+				"received.bytes":         dev.RBytes,
+				"received.packets":       dev.RPackets,
+				"received.errs":          dev.RErrs,
+				"received.drop":          dev.RDrop,
+				"received.fifo":          dev.RFIFO,
+				"received.frame":         dev.RFrame,
+				"received.compressed":    dev.RCompressed,
+				"received.multicast":     dev.RMulticast,
+				"transmitted.bytes":      dev.TBytes,
+				"transmitted.packets":    dev.TPackets,
+				"transmitted.errs":       dev.TErrs,
+				"transmitted.drop":       dev.TDrop,
+				"transmitted.fifo":       dev.TFIFO,
+				"transmitted.colls":      dev.TColls,
+				"transmitted.carrier":    dev.TCarrier,
+				"transmitted.compressed": dev.TCompressed,
+			},
+			Time:  devs.Timestamp,
+			Delta: devs.TimeDelta,
+		})
+	}
+	return pts
+}
+
+// EventPoint returns the point for an event, e.g. a client connecting.  The
+// point's time is the current time.
+func (c *Client) EventPoint(kind, msg string) point {
+	res := c.Resource()
+	return point{
+		Client:   res["client"],
+		Name:     "events",
+		Resource: res,
+		Tags:     map[string]string{"kind": kind},
+		Fields:   map[string]interface{}{"message": msg},
+		Time:     time.Now().UnixNano(),
+	}
+}
+
+// annotationPoint returns the event point as an annotation for outputs that
+// only handle numeric data: its count field is 1 and its kind is a tag.  The
+// event's message isn't included.
+func annotationPoint(pt point) point {
+	pt.Fields = map[string]interface{}{"count": int64(1)}
+	return pt
+}
+
+// toFloat64 returns the field value as a float64.  False is returned if the
+// value isn't a supported numeric type.
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// intString returns the field value as a decimal string if it is an integer
+// type.  False is returned for non-integer values.
+func intString(v interface{}) (string, bool) {
+	switch n := v.(type) {
+	case int64:
+		return strconv.FormatInt(n, 10), true
+	case uint64:
+		return strconv.FormatUint(n, 10), true
+	case int:
+		return strconv.Itoa(n), true
+	case int32:
+		return strconv.FormatInt(int64(n), 10), true
+	case int16:
+		return strconv.FormatInt(int64(n), 10), true
+	default:
+		return "", false
+	}
+}
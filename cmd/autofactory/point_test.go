@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mohae/autofact/conf"
+)
+
+func TestResource(t *testing.T) {
+	info := conf.ClientInfo{
+		ID:       []byte("abcd1234"),
+		Hostname: "web01",
+	}
+	info.SetLabels(map[string]string{"region": "us-east", "role": "web", "env": "prod", "host": "other", "zone": ""})
+	c := &Client{
+		Conf: conf.GetRootAsClient(info.Serialize(), 0),
+		tags: map[string]string{"env": "staging", "federation": "eu", "host": "web02"},
+	}
+	want := map[string]string{
+		"client":     "abcd1234",
+		"host":       "web01",
+		"region":     "us-east",
+		"role":       "web",
+		"env":        "staging",
+		"federation": "eu",
+	}
+	got := c.Resource()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	tags := c.influxTags()
+	delete(want, "client")
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("influx tags: got %v; want %v", tags, want)
+	}
+}
+
+func TestAnnotationPoint(t *testing.T) {
+	c := srvr.newClient([]byte("abcd1234"))
+	pt := c.EventPoint("anomaly", "cpus.usage is 5 sigma from its mean")
+	a := annotationPoint(pt)
+	if a.Name != "events" || a.Client != "abcd1234" || a.Tags["kind"] != "anomaly" {
+		t.Errorf("got %s %s %v; want events abcd1234 map[kind:anomaly]", a.Name, a.Client, a.Tags)
+	}
+	if !reflect.DeepEqual(a.Fields, map[string]interface{}{"count": int64(1)}) {
+		t.Errorf("got fields %v; want map[count:1]", a.Fields)
+	}
+	// the event point is unchanged.
+	if pt.Fields["message"] != "cpus.usage is 5 sigma from its mean" {
+		t.Errorf("got event fields %v; want the message", pt.Fields)
+	}
+	if got := SeriesName(a.Name, "count", a.Tags); got != "events.count{kind=anomaly}" {
+		t.Errorf("got series %q; want %q", got, "events.count{kind=anomaly}")
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mohae/autofact/message"
+)
+
+// pgTable describes a table that autofactory writes to.  Every table has the
+// time and client columns, followed by the client's location columns, the
+// labels column, the table's tag column, if any, and its field columns.
+type pgTable struct {
+	Name string
+	// Point is the name of the points that are written to this table.
+	Point string
+	// Tag is the point tag that is stored in its own column, if any.
+	Tag string
+	// Fields are the point's fields, in column order.
+	Fields []string
+	// FieldType is the SQL type of the field columns.
+	FieldType string
+}
+
+// pgLocation are the location columns; these are the client's resource
+// tags.
+var pgLocation = []string{"host", "region", "zone", "datacenter", "role"}
+
+// pgLabels is the column that the client's other resource tags, i.e. its
+// labels, are written to as a JSON object.
+const pgLabels = "labels"
+
+// pgAddedColumns are the columns that were added after the tables were first
+// created; they are added to existing tables that don't have them.
+var pgAddedColumns = []string{"role text", pgLabels + " jsonb"}
+
+// pgTables are the tables autofactory writes to.
+var pgTables = []*pgTable{
+	{
+		Name:      "cpu",
+		Point:     "cpus",
+		Tag:       "cpu",
+		Fields:    []string{"usage", "user", "nice", "system", "idle", "iowait"},
+		FieldType: "real",
+	},
+	{
+		Name:      "loadavg",
+		Point:     "loadavg",
+		Fields:    []string{"one", "five", "fifteen"},
+		FieldType: "double precision",
+	},
+	{
+		Name:      "memory",
+		Point:     "memory",
+		Fields:    []string{"total_ram", "free_ram", "shared_ram", "buffer_ram", "total_swap", "free_swap"},
+		FieldType: "bigint",
+	},
+	{
+		Name:  "interfaces",
+		Point: "interfaces",
+		Tag:   "device",
+		Fields: []string{
+			"received.bytes", "received.packets", "received.errs", "received.drop",
+			"received.fifo", "received.frame", "received.compressed", "received.multicast",
+			"transmitted.bytes", "transmitted.packets", "transmitted.errs", "transmitted.drop",
+			"transmitted.fifo", "transmitted.colls", "transmitted.carrier", "transmitted.compressed",
+		},
+		FieldType: "bigint",
+	},
+	{
+		Name:      "events",
+		Point:     "events",
+		Tag:       "kind",
+		Fields:    []string{"message"},
+		FieldType: "text",
+	},
+}
+
+// pgColumn returns the column name for a field; dots aren't valid in
+// unquoted identifiers so they are replaced with an _.
+func pgColumn(s string) string {
+	return strings.Replace(s, ".", "_", -1)
+}
+
+// Columns returns the table's column names, in order.
+func (t *pgTable) Columns() []string {
+	cols := append([]string{"time", "client"}, pgLocation...)
+	cols = append(cols, pgLabels)
+	if t.Tag != "" {
+		cols = append(cols, t.Tag)
+	}
+	for _, f := range t.Fields {
+		cols = append(cols, pgColumn(f))
+	}
+	return cols
+}
+
+// Create returns the CREATE TABLE statement for the table.
+func (t *pgTable) Create() string {
+	cols := []string{"time timestamptz NOT NULL", "client text NOT NULL"}
+	for _, v := range pgLocation {
+		cols = append(cols, v+" text")
+	}
+	cols = append(cols, pgLabels+" jsonb")
+	if t.Tag != "" {
+		cols = append(cols, t.Tag+" text")
+	}
+	for _, f := range t.Fields {
+		cols = append(cols, pgColumn(f)+" "+t.FieldType)
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", t.Name, strings.Join(cols, ", "))
+}
+
+// Alter returns the ALTER TABLE statements that add the pgAddedColumns to
+// the table if it doesn't have them.
+func (t *pgTable) Alter() []string {
+	stmts := make([]string, len(pgAddedColumns))
+	for i, v := range pgAddedColumns {
+		stmts[i] = fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s", t.Name, v)
+	}
+	return stmts
+}
+
+// Values returns the point's values in column order.  Missing tags are
+// written as NULL.
+func (t *pgTable) Values(pt point) []interface{} {
+	vals := []interface{}{time.Unix(0, pt.Time).UTC(), pt.Client}
+	for _, v := range pgLocation {
+		vals = append(vals, pgNullString(pt.Resource[v]))
+	}
+	vals = append(vals, pgLabelsJSON(pt.Resource))
+	if t.Tag != "" {
+		vals = append(vals, pgNullString(pt.Tags[t.Tag]))
+	}
+	for _, f := range t.Fields {
+		vals = append(vals, pt.Fields[f])
+	}
+	return vals
+}
+
+func pgNullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// pgLabelsJSON returns the resource tags that don't have their own column as
+// a JSON object.  If there aren't any, NULL is written.
+func pgLabelsJSON(res map[string]string) interface{} {
+	labels := make(map[string]string)
+	for k, v := range res {
+		labels[k] = v
+	}
+	delete(labels, "client")
+	for _, v := range pgLocation {
+		delete(labels, v)
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	// a map of strings always marshals.
+	b, _ := json.Marshal(labels)
+	return string(b)
+}
+
+// newPostgresClient connects to the database and creates any tables that
+// don't exist.  Existing tables are validated; if one is missing a column an
+// error is returned.  If timescale is true, the tables are converted to
+// TimescaleDB hypertables.
+func newPostgresClient(dsn string, timescale bool, size int, interval time.Duration, retries int) (*PostgresClient, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("PostgreSQL: open failed: %s", err)
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("PostgreSQL: connect failed: %s", err)
+	}
+	c := &PostgresClient{
+		DB:        db,
+		Timescale: timescale,
+		Retries:   retries,
+	}
+	err = c.CreateTables()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	c.batcher = newBatcher("postgres", size, interval, c.write)
+	return c, nil
+}
+
+// PostgresClient manages the writing of points to PostgreSQL, or
+// TimescaleDB.
+type PostgresClient struct {
+	DB        *sql.DB
+	Timescale bool
+	Retries   int
+	*batcher
+}
+
+// CreateTables creates the tables, if they don't exist, adds any columns that
+// tables created by an earlier version don't have, and validates that the
+// tables have the expected columns.
+func (c *PostgresClient) CreateTables() error {
+	for _, t := range pgTables {
+		_, err := c.DB.Exec(t.Create())
+		if err != nil {
+			return fmt.Errorf("PostgreSQL: create table %s: %s", t.Name, err)
+		}
+		for _, stmt := range t.Alter() {
+			_, err = c.DB.Exec(stmt)
+			if err != nil {
+				return fmt.Errorf("PostgreSQL: alter table %s: %s", t.Name, err)
+			}
+		}
+		if c.Timescale {
+			_, err = c.DB.Exec("SELECT create_hypertable($1, 'time', if_not_exists => TRUE)", t.Name)
+			if err != nil {
+				return fmt.Errorf("PostgreSQL: create hypertable %s: %s", t.Name, err)
+			}
+		}
+		err = c.validate(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks that the table has all of the expected columns.
+func (c *PostgresClient) validate(t *pgTable) error {
+	rows, err := c.DB.Query("SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", t.Name)
+	if err != nil {
+		return fmt.Errorf("PostgreSQL: validate table %s: %s", t.Name, err)
+	}
+	defer rows.Close()
+	cols := make(map[string]struct{})
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return fmt.Errorf("PostgreSQL: validate table %s: %s", t.Name, err)
+		}
+		cols[name] = struct{}{}
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("PostgreSQL: validate table %s: %s", t.Name, err)
+	}
+	for _, v := range t.Columns() {
+		if _, ok := cols[v]; !ok {
+			return fmt.Errorf("PostgreSQL: validate table %s: column %s does not exist", t.Name, v)
+		}
+	}
+	return nil
+}
+
+// write writes the points to their tables.  If the write fails, it is
+// retried, with backoff, until the retries have been exhausted.
+func (c *PostgresClient) write(pts []point) error {
+	var err error
+	wait := RetryWait
+	for i := 0; i <= c.Retries; i++ {
+		if i > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		err = c.copy(pts)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// copy writes the points, using COPY, in a single transaction.
+func (c *PostgresClient) copy(pts []point) error {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+	for _, t := range pgTables {
+		err = copyTable(tx, t, pts)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("PostgreSQL: copy to %s: %s", t.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// copyTable copies the points that belong to the table.
+func copyTable(tx *sql.Tx, t *pgTable, pts []point) error {
+	var stmt *sql.Stmt
+	var err error
+	for _, pt := range pts {
+		if pt.Name != t.Point {
+			continue
+		}
+		if stmt == nil {
+			stmt, err = tx.Prepare(pq.CopyIn(t.Name, t.Columns()...))
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+		}
+		_, err = stmt.Exec(t.Values(pt)...)
+		if err != nil {
+			return err
+		}
+	}
+	if stmt == nil {
+		return nil
+	}
+	// an Exec without args flushes the COPY
+	_, err = stmt.Exec()
+	return err
+}
+
+// Close flushes any pending points and closes the database.
+func (c *PostgresClient) Close() {
+	c.batcher.Close()
+	c.DB.Close()
+}
+
+// CPUUtilizationPostgres processes CPUUtilization messages and writes them to
+// PostgreSQL.
+func (c *Client) CPUUtilizationPostgres(msg *message.Message) {
+	c.Postgres.Send(c.CPUUtilizationPoints(msg))
+}
+
+// LoadAvgPostgres processes LoadAvg messages and writes them to PostgreSQL.
+func (c *Client) LoadAvgPostgres(msg *message.Message) {
+	c.Postgres.Send(c.LoadAvgPoints(msg))
+}
+
+// MemInfoPostgres processes MemInfo messages and writes them to PostgreSQL.
+func (c *Client) MemInfoPostgres(msg *message.Message) {
+	c.Postgres.Send(c.MemInfoPoints(msg))
+}
+
+// NetUsagePostgres processes NetUsage messages and writes them to
+// PostgreSQL.
+func (c *Client) NetUsagePostgres(msg *message.Message) {
+	c.Postgres.Send(c.NetUsagePoints(msg))
+}
+
+// EventPostgres writes the event to PostgreSQL.
+func (c *Client) EventPostgres(kind, msg string) {
+	c.Postgres.Send([]point{c.EventPoint(kind, msg)})
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohae/autofact/db"
+	"github.com/uber-go/zap"
+)
+
+// Query defaults and limits.
+var (
+	// DefaultQueryRange is the range used when a query doesn't specify a start.
+	DefaultQueryRange = time.Hour
+	// DefaultQueryStep is the step used when a query doesn't specify one.
+	DefaultQueryStep = time.Minute
+	// MaxQueryPoints is the maximum number of points per series a query may
+	// request: (end - start) / step.
+	MaxQueryPoints int64 = 11000
+)
+
+// query is a request for series from the embedded store.  The clients are
+// the union of Clients and, if Tags is not empty, the clients whose
+// attributes match all of the Tags.  If neither are specified, all clients
+// are queried.
+type query struct {
+	Clients []string
+	Tags    map[string]string
+	// Metric is either a series name, e.g. cpus.usage{cpu=cpu0}, or the
+	// name without the tags, e.g. cpus.usage, which matches all of the
+	// series of that name.
+	Metric string
+	Start  int64 // nanoseconds
+	End    int64 // nanoseconds
+	Step   int64 // nanoseconds
+	Agg    string
+}
+
+// series is a query result.  Datapoints are [value, unix milliseconds], which
+// is what Grafana's JSON datasource expects.
+type series struct {
+	Target     string            `json:"target"`
+	Client     string            `json:"client"`
+	Metric     string            `json:"metric"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Datapoints [][2]float64      `json:"datapoints"`
+}
+
+// Query returns the aligned series that match the query.
+func (s *server) Query(q query) ([]series, error) {
+	if s.Embedded == nil {
+		return nil, errors.New("query: the embedded store is not in use")
+	}
+	if q.Metric == "" {
+		return nil, errors.New("query: metric required")
+	}
+	if q.Step <= 0 {
+		return nil, errors.New("query: step must be > 0")
+	}
+	if q.End < q.Start {
+		return nil, errors.New("query: end is before start")
+	}
+	if (q.End-q.Start)/q.Step > MaxQueryPoints {
+		return nil, fmt.Errorf("query: too many points: reduce the range or increase the step to have at most %d points", MaxQueryPoints)
+	}
+	if q.Agg == "" {
+		q.Agg = "avg"
+	}
+	fn, ok := db.AggregatorFromString(q.Agg)
+	if !ok {
+		return nil, fmt.Errorf("query: unsupported aggregation %q", q.Agg)
+	}
+	results := []series{}
+	for _, id := range s.queryClients(q) {
+		names, err := s.Embedded.Series(id)
+		if err != nil {
+			return nil, err
+		}
+		var tags map[string]string
+		if c, ok := s.Inventory.Client([]byte(id)); ok {
+			tags = resource(c)
+		}
+		for _, name := range names {
+			if name != q.Metric && metricName(name) != q.Metric {
+				continue
+			}
+			aggs, err := s.Embedded.Read(id, name, q.Start, q.End)
+			if err != nil {
+				return nil, err
+			}
+			vals := db.Align(aggs, q.Start, q.End, q.Step, fn)
+			pts := make([][2]float64, 0, len(vals))
+			for _, v := range vals {
+				pts = append(pts, [2]float64{v.Value, float64(v.Time / int64(time.Millisecond))})
+			}
+			results = append(results, series{
+				Target:     id + " " + name,
+				Client:     id,
+				Metric:     name,
+				Tags:       tags,
+				Datapoints: pts,
+			})
+		}
+	}
+	return results, nil
+}
+
+// queryClients returns the IDs of the clients that the query selects.
+func (s *server) queryClients(q query) []string {
+	if len(q.Clients) == 0 {
+		return s.Inventory.Select(q.Tags)
+	}
+	if len(q.Tags) == 0 {
+		return q.Clients
+	}
+	ids := s.Inventory.Select(q.Tags)
+	seen := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		seen[id] = struct{}{}
+	}
+	for _, id := range q.Clients {
+		if _, ok := seen[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// MetricNames returns the names of all stored metrics, without their tags,
+// in sorted order.
+func (s *server) MetricNames() ([]string, error) {
+	if s.Embedded == nil {
+		return nil, errors.New("the embedded store is not in use")
+	}
+	seen := make(map[string]struct{})
+	for _, id := range s.Inventory.Select(nil) {
+		names, err := s.Embedded.Series(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range names {
+			seen[metricName(v)] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for k := range seen {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// metricName returns the series name without its tags.
+func metricName(series string) string {
+	if i := strings.IndexByte(series, '{'); i >= 0 {
+		return series[:i]
+	}
+	return series
+}
+
+// serveQuery handles /api/query.  The query is specified with the following
+// parameters:
+//
+//	metric: the metric or series name; required
+//	client: client IDs, comma separated or repeated
+//	tag:    key=value attribute selectors, comma separated or repeated
+//	start:  RFC3339 or unix seconds; defaults to an hour before end
+//	end:    RFC3339 or unix seconds; defaults to now
+//	step:   a duration, e.g. 30s, or seconds; defaults to 1m
+//	agg:    avg, min, max, last, or p95; defaults to avg
+func serveQuery(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := query{
+		Metric: r.Form.Get("metric"),
+		Agg:    r.Form.Get("agg"),
+		End:    time.Now().UnixNano(),
+		Step:   int64(DefaultQueryStep),
+	}
+	q.Clients = splitValues(r.Form["client"])
+	q.Tags, err = parseTags(r.Form["tag"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := r.Form.Get("end"); v != "" {
+		q.End, err = parseQueryTime(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	q.Start = q.End - int64(DefaultQueryRange)
+	if v := r.Form.Get("start"); v != "" {
+		q.Start, err = parseQueryTime(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.Form.Get("step"); v != "" {
+		q.Step, err = parseQueryStep(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	results, err := srvr.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, results)
+}
+
+// grafanaQuery is a Grafana JSON datasource query request.
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs int64           `json:"intervalMs"`
+	Targets    []grafanaTarget `json:"targets"`
+}
+
+// grafanaTarget is a metric to query.  The payload, or data for the older
+// simple JSON datasource, selects the clients and the aggregation.
+type grafanaTarget struct {
+	Target  string          `json:"target"`
+	Payload *grafanaPayload `json:"payload"`
+	Data    *grafanaPayload `json:"data"`
+}
+
+type grafanaPayload struct {
+	Clients []string          `json:"clients"`
+	Tags    map[string]string `json:"tags"`
+	Agg     string            `json:"agg"`
+}
+
+// serveGrafana handles the endpoints used by Grafana's JSON datasource:
+//
+//	/grafana/:        connection test
+//	/grafana/search:  metric names, for the simple JSON datasource
+//	/grafana/metrics: metric names
+//	/grafana/query:   the series for the targets
+func serveGrafana(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/grafana"), "/") {
+	case "":
+		w.WriteHeader(http.StatusOK)
+	case "/search":
+		names, err := srvr.MetricNames()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, names)
+	case "/metrics":
+		names, err := srvr.MetricNames()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		metrics := make([]map[string]string, 0, len(names))
+		for _, v := range names {
+			metrics = append(metrics, map[string]string{"label": v, "value": v})
+		}
+		writeJSON(w, metrics)
+	case "/query":
+		var gq grafanaQuery
+		err := json.NewDecoder(r.Body).Decode(&gq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		step := gq.IntervalMs * int64(time.Millisecond)
+		if step <= 0 {
+			step = int64(DefaultQueryStep)
+		}
+		results := []series{}
+		for _, t := range gq.Targets {
+			q := query{
+				Metric: t.Target,
+				Start:  gq.Range.From.UnixNano(),
+				End:    gq.Range.To.UnixNano(),
+				Step:   step,
+			}
+			p := t.Payload
+			if p == nil {
+				p = t.Data
+			}
+			if p != nil {
+				q.Clients = p.Clients
+				q.Tags = p.Tags
+				q.Agg = p.Agg
+			}
+			res, err := srvr.Query(q)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			results = append(results, res...)
+		}
+		writeJSON(w, results)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeJSON writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "write json response"),
+		)
+	}
+}
+
+// splitValues splits each value on commas and returns all non-empty values.
+func splitValues(vals []string) []string {
+	var s []string
+	for _, v := range vals {
+		for _, vv := range strings.Split(v, ",") {
+			if vv = strings.TrimSpace(vv); vv != "" {
+				s = append(s, vv)
+			}
+		}
+	}
+	return s
+}
+
+// parseTags parses key=value tag selectors, which may be comma separated.  If
+// there aren't any selectors, nil is returned.
+func parseTags(vals []string) (map[string]string, error) {
+	var tags map[string]string
+	for _, v := range splitValues(vals) {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid tag selector %q: expected key=value", v)
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[kv[0]] = kv[1]
+	}
+	return tags, nil
+}
+
+// parseQueryTime parses either unix seconds or RFC3339 and returns the time
+// in nanoseconds.
+func parseQueryTime(s string) (int64, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n * int64(time.Second), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: expected RFC3339 or unix seconds", s)
+	}
+	return t.UnixNano(), nil
+}
+
+// parseQueryStep parses either a duration or seconds and returns the step in
+// nanoseconds.
+func parseQueryStep(s string) (int64, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n * int64(time.Second), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid step %q: expected a duration or seconds", s)
+	}
+	return int64(d), nil
+}
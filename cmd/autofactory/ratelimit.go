@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate limit policies: what is done with the messages of a client that
+// exceeds its rate limit.
+const (
+	// PolicyDrop drops the messages that exceed the limit.
+	PolicyDrop = "drop"
+	// PolicyThrottle stops reading from the client's connection until the
+	// message is within the limit; the client is slowed down by TCP
+	// backpressure.
+	PolicyThrottle = "throttle"
+	// PolicyDisconnect closes the client's connection.
+	PolicyDisconnect = "disconnect"
+)
+
+// rateLimit is the ingest rate limit of a client.  A rate of 0 is unlimited.
+type rateLimit struct {
+	// Messages is the number of messages per second.
+	Messages float64 `json:"messages"`
+	// Bytes is the number of bytes per second.
+	Bytes float64 `json:"bytes"`
+	// Policy is what is done with messages that exceed the limit.
+	Policy string `json:"policy"`
+}
+
+// Validate returns an error if the rate limit isn't valid.
+func (r *rateLimit) Validate() error {
+	if r.Messages < 0 || r.Bytes < 0 {
+		return fmt.Errorf("invalid rate limit: %v messages/s, %v bytes/s: rates can't be negative", r.Messages, r.Bytes)
+	}
+	switch r.Policy {
+	case PolicyDrop, PolicyThrottle, PolicyDisconnect:
+		return nil
+	}
+	return fmt.Errorf("unknown rate limit policy: %q", r.Policy)
+}
+
+// Unlimited returns whether neither rate is limited.
+func (r *rateLimit) Unlimited() bool {
+	return r.Messages == 0 && r.Bytes == 0
+}
+
+// rateLimits are the ingest rate limits: a default and, optionally, one per
+// client.  Clients without a rate limit of their own use the default.
+type rateLimits struct {
+	mu      sync.Mutex
+	Default rateLimit            `json:"default"`
+	Clients map[string]rateLimit `json:"clients"`
+}
+
+// Load reads the rate limits file; the rate limits in it replace the current
+// per client rate limits.  The default is replaced only if the file has one.
+// If the file can't be read, or any of its rate limits are invalid, the
+// current rate limits are kept.
+func (r *rateLimits) Load(name string) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var rl struct {
+		Default *rateLimit           `json:"default"`
+		Clients map[string]rateLimit `json:"clients"`
+	}
+	err = json.Unmarshal(b, &rl)
+	if err != nil {
+		return fmt.Errorf("%s unmarshal error: %s", name, err)
+	}
+	if rl.Default != nil {
+		err = rl.Default.Validate()
+		if err != nil {
+			return fmt.Errorf("default: %s", err)
+		}
+	}
+	for id, l := range rl.Clients {
+		err = l.Validate()
+		if err != nil {
+			return fmt.Errorf("%s: %s", id, err)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rl.Default != nil {
+		r.Default = *rl.Default
+	}
+	r.Clients = rl.Clients
+	return nil
+}
+
+// Get returns the client's rate limit.
+func (r *rateLimits) Get(id string) rateLimit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.Clients[id]
+	if !ok {
+		return r.Default
+	}
+	return l
+}
+
+// bucket is a token bucket that holds up to a second's worth of tokens.
+type bucket struct {
+	rate   float64 // tokens per second; 0 is unlimited
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, now time.Time) *bucket {
+	return &bucket{rate: rate, tokens: rate, last: now}
+}
+
+// fill adds the tokens accrued since the bucket was last filled.
+func (b *bucket) fill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// need is the number of tokens that must be in the bucket to take n.  So
+// that n larger than the bucket can be taken, a full bucket is enough;
+// taking them puts the bucket in debt.
+func (b *bucket) need(n float64) float64 {
+	return math.Min(n, b.rate)
+}
+
+// wait returns how long until n tokens can be taken.
+func (b *bucket) wait(n float64) time.Duration {
+	short := b.need(n) - b.tokens
+	if short <= 0 {
+		return 0
+	}
+	return time.Duration(short / b.rate * float64(time.Second))
+}
+
+// limiter limits the rate of a client's messages.  It is not safe for
+// concurrent use: it's used by the client's read loop.
+type limiter struct {
+	rateLimit
+	msgs  *bucket // nil if unlimited
+	bytes *bucket // nil if unlimited
+}
+
+func newLimiter(l rateLimit, now time.Time) *limiter {
+	lim := &limiter{rateLimit: l}
+	if l.Messages > 0 {
+		lim.msgs = newBucket(l.Messages, now)
+	}
+	if l.Bytes > 0 {
+		lim.bytes = newBucket(l.Bytes, now)
+	}
+	return lim
+}
+
+// Wait returns how long until a message of n bytes is within the limit.
+func (l *limiter) Wait(n int, now time.Time) time.Duration {
+	var d time.Duration
+	if l.msgs != nil {
+		l.msgs.fill(now)
+		d = l.msgs.wait(1)
+	}
+	if l.bytes != nil {
+		l.bytes.fill(now)
+		if w := l.bytes.wait(float64(n)); w > d {
+			d = w
+		}
+	}
+	return d
+}
+
+// Take takes the tokens for a message of n bytes.  Take should be called
+// once Wait returns 0.
+func (l *limiter) Take(n int) {
+	if l.msgs != nil {
+		l.msgs.tokens--
+	}
+	if l.bytes != nil {
+		l.bytes.tokens -= float64(n)
+	}
+}
+
+// Allow returns whether a message of n bytes is within the limit; if it is,
+// its tokens are taken.
+func (l *limiter) Allow(n int, now time.Time) bool {
+	if l.Wait(n, now) > 0 {
+		return false
+	}
+	l.Take(n)
+	return true
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	start := time.Unix(1000, 0)
+	l := newLimiter(rateLimit{Messages: 2, Bytes: 100, Policy: PolicyDrop}, start)
+	tests := []struct {
+		n     int
+		t     time.Time
+		allow bool
+	}{
+		{10, start, true},
+		{10, start, true},
+		// out of messages
+		{10, start, false},
+		// half a second accrues a message
+		{10, start.Add(500 * time.Millisecond), true},
+		{60, start.Add(time.Second), true},
+		// a full bucket takes a message larger than the bucket; the debt is
+		// repaid before the next message is allowed.
+		{500, start.Add(3 * time.Second), true},
+		{1, start.Add(5 * time.Second), false},
+		{1, start.Add(8 * time.Second), true},
+	}
+	for i, test := range tests {
+		if allow := l.Allow(test.n, test.t); allow != test.allow {
+			t.Errorf("%d: got %t; want %t", i, allow, test.allow)
+		}
+	}
+	// waiting for the tokens to accrue
+	l = newLimiter(rateLimit{Messages: 10, Policy: PolicyThrottle}, start)
+	for i := 0; i < 10; i++ {
+		l.Allow(1, start)
+	}
+	if d := l.Wait(1, start); d != 100*time.Millisecond {
+		t.Errorf("got %s; want 100ms", d)
+	}
+}
+
+func TestRateLimitsLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autofactory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "ratelimits.json")
+	r := &rateLimits{Default: rateLimit{Messages: 10, Policy: PolicyDrop}}
+
+	err = ioutil.WriteFile(name, []byte(`{"clients": {"abc": {"messages": 100, "bytes": 1000, "policy": "throttle"}}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Load(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if l := r.Get("abc"); l.Messages != 100 || l.Bytes != 1000 || l.Policy != PolicyThrottle {
+		t.Errorf("abc: got %+v", l)
+	}
+	// clients without their own rate limit use the default
+	if l := r.Get("def"); l.Messages != 10 || l.Policy != PolicyDrop {
+		t.Errorf("def: got %+v", l)
+	}
+
+	// an invalid rate limit keeps the current rate limits
+	err = ioutil.WriteFile(name, []byte(`{"clients": {"abc": {"messages": 1, "policy": "block"}}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Load(name)
+	if err == nil {
+		t.Error("expected an error, got none")
+	}
+	if l := r.Get("abc"); l.Messages != 100 {
+		t.Errorf("abc: got %+v; want the current rate limit", l)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/mohae/autofact/message"
+	"github.com/uber-go/zap"
+)
+
+// relayed is a client connected through a relay: an agent that multiplexes
+// the connections of other agents over its own connection.
+type relayed struct {
+	c *Client
+	// done is closed when the client's session ends.
+	done chan struct{}
+}
+
+// Relay processes a message that the relay forwarded from one of its clients.
+// The first message of a client is its Hello; the client then has a session
+// until either side ends it with an EOT.  The client's messages are processed
+// like those received by websocket.
+func (c *Client) Relay(msg *message.Message) {
+	dst := msg.DstID()
+	p := msg.DataBytes()
+	if dst == 0 {
+		c.Reject(fmt.Errorf("relay message without a DstID"), "relay message")
+		return
+	}
+	c.rmu.Lock()
+	r, ok := c.relayed[dst]
+	c.rmu.Unlock()
+	if !ok {
+		c.relayHello(dst, p)
+		return
+	}
+	v := r.c
+	srvr.Inventory.Seen(v.Conf.IDBytes())
+	if v.limiter != nil && !v.Limit(len(p)) {
+		if v.limiter.Policy == PolicyDisconnect {
+			c.EndRelayed(dst, "rate limit exceeded")
+		}
+		return
+	}
+	p, err := v.codec.Decode(p)
+	if err != nil {
+		v.Reject(err, "decode message")
+		return
+	}
+	err = message.Verify(p)
+	if err != nil {
+		v.Reject(err, "verify message")
+		return
+	}
+	v.processBinaryMessage(p)
+}
+
+// relayHello starts the session of a relayed client with its Hello.  Relayed
+// clients must support pushing their healthbeat as it can't be pulled.
+func (c *Client) relayHello(dst uint32, p []byte) {
+	err := message.Verify(p)
+	var msg *message.Message
+	if err == nil {
+		msg = message.GetRootAsMessage(p, 0)
+		if message.Kind(msg.Kind()) != message.Hello {
+			err = fmt.Errorf("expected a hello, got %s", message.Kind(msg.Kind()))
+		}
+	}
+	if err != nil {
+		c.Reject(err, "relay hello")
+		c.endRelay(dst, "invalid hello: "+err.Error())
+		return
+	}
+	proto, err := message.Negotiate(srvr.Protocol(), message.GetProtocol(msg.DataBytes()))
+	if err == nil && proto.Version < message.PushHealthbeatVersion {
+		err = fmt.Errorf("relayed clients require protocol version %d or later", message.PushHealthbeatVersion)
+	}
+	if err != nil {
+		log.Warn(
+			err.Error(),
+			zap.String("op", "negotiate protocol"),
+			zap.String("id", string(proto.ID)),
+			zap.String("relay", string(c.Conf.IDBytes())),
+		)
+		c.endRelay(dst, err.Error())
+		return
+	}
+	v, b, err := connectClient(proto, true)
+	if err != nil { // don't do anything with error, func already handled logging.
+		c.endRelay(dst, refusal(err))
+		return
+	}
+	v.relay = c
+	v.dst = dst
+	log.Info(
+		"client connected through relay",
+		zap.String("id", string(v.Conf.IDBytes())),
+		zap.String("relay", string(c.Conf.IDBytes())),
+	)
+	// the Hello is always flatbuffer serialized.
+	proto.ID = v.Conf.IDBytes()
+	err = c.WriteMessage(websocket.BinaryMessage, message.SerializeRelay(dst, message.Serialize(srvr.idGen.Snowflake(), message.Hello, proto.Serialize())))
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "write message"),
+			zap.String("client", string(v.Conf.IDBytes())),
+			zap.String("relay", string(c.Conf.IDBytes())),
+		)
+		return
+	}
+	v.WriteBinaryMessage(message.ClientConf, b)
+	v.WriteBinaryMessage(message.EOT, nil)
+	r := &relayed{c: v, done: make(chan struct{})}
+	c.rmu.Lock()
+	if c.relayed == nil {
+		c.relayed = make(map[uint32]*relayed)
+	}
+	c.relayed[dst] = r
+	c.rmu.Unlock()
+	v.Connected()
+	if v.acks != nil {
+		go v.acks.Run(AckInterval, r.done)
+	}
+	go v.Healthbeat(r.done)
+}
+
+// EndRelayed ends the session of the relayed client dst and tells the relay
+// why, so that it can close the client's connection.
+func (c *Client) EndRelayed(dst uint32, reason string) {
+	if c.removeRelayed(dst) {
+		c.endRelay(dst, reason)
+	}
+}
+
+// removeRelayed ends the session of the relayed client dst, if it has one,
+// and returns whether it did.
+func (c *Client) removeRelayed(dst uint32) bool {
+	c.rmu.Lock()
+	r, ok := c.relayed[dst]
+	delete(c.relayed, dst)
+	c.rmu.Unlock()
+	if !ok {
+		return false
+	}
+	close(r.done)
+	r.c.Disconnected()
+	return true
+}
+
+// endRelay tells the relay that the session of its client dst has ended.
+func (c *Client) endRelay(dst uint32, reason string) {
+	err := c.WriteMessage(websocket.BinaryMessage, message.SerializeRelayEnd(dst, reason))
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "end relayed session"),
+			zap.String("relay", string(c.Conf.IDBytes())),
+		)
+	}
+}
+
+// endRelayedAll ends the sessions of all of the relay's clients; this is done
+// when the relay disconnects.
+func (c *Client) endRelayedAll() {
+	c.rmu.Lock()
+	dsts := make([]uint32, 0, len(c.relayed))
+	for dst := range c.relayed {
+		dsts = append(dsts, dst)
+	}
+	c.rmu.Unlock()
+	for _, dst := range dsts {
+		c.removeRelayed(dst)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mohae/autofact/message"
+)
+
+func TestRelayedWriteMessage(t *testing.T) {
+	// the relay uses the HTTP transport so that what is written to it is
+	// held.
+	r := srvr.newClient([]byte("relay"))
+	c := srvr.newClient([]byte("abc"))
+	c.relay = r
+	c.dst = 7
+	err := c.WriteMessage(websocket.TextMessage, []byte("loadavg"))
+	if err == nil {
+		t.Error("text message: expected an error, got none")
+	}
+	err = c.WriteMessage(websocket.BinaryMessage, []byte("a"))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if got := c.Pending(); len(got) != 0 {
+		t.Errorf("got %q held for the relayed client; want nothing", got)
+	}
+	got := r.Pending()
+	if len(got) != 1 {
+		t.Fatalf("got %d messages held for the relay; want 1", len(got))
+	}
+	msg := message.GetRootAsMessage(got[0], 0)
+	if message.Kind(msg.Kind()) != message.Relay {
+		t.Errorf("got kind %s; want %s", message.Kind(msg.Kind()), message.Relay)
+	}
+	if msg.DstID() != 7 {
+		t.Errorf("got DstID %d; want 7", msg.DstID())
+	}
+	if !bytes.Equal(msg.DataBytes(), []byte("a")) {
+		t.Errorf("got data %q; want %q", msg.DataBytes(), "a")
+	}
+}
+
+func TestCloseReplacedConnection(t *testing.T) {
+	// the client was relayed; it reconnects, and takes over its ID, before
+	// the relay ended its session.
+	r := srvr.newClient([]byte("relay"))
+	prev := srvr.newClient([]byte("takeover"))
+	prev.relay = r
+	prev.dst = 7
+	done := make(chan struct{})
+	r.relayed = map[uint32]*relayed{7: {c: prev, done: done}}
+	c := srvr.newClient([]byte("takeover"))
+	srvr.Inventory.Connect(prev)
+	if got := srvr.Inventory.Connect(c); got != prev {
+		t.Fatalf("got %p; want the previous connection %p", got, prev)
+	}
+	defer srvr.Inventory.Disconnect(c)
+	prev.Close("replaced by a new connection")
+	select {
+	case <-done:
+	default:
+		t.Error("the relayed session wasn't ended")
+	}
+	if _, ok := r.relayed[7]; ok {
+		t.Error("the relayed client wasn't removed from the relay")
+	}
+	got := r.Pending()
+	if len(got) != 1 {
+		t.Fatalf("got %d messages held for the relay; want 1", len(got))
+	}
+	msg := message.GetRootAsMessage(got[0], 0)
+	if message.Kind(msg.Kind()) != message.EOT || msg.DstID() != 7 {
+		t.Errorf("got %s for %d; want %s for 7", message.Kind(msg.Kind()), msg.DstID(), message.EOT)
+	}
+	if !srvr.Inventory.Status([]byte("takeover")).Connected {
+		t.Error("got disconnected; want connected")
+	}
+	// the client was using HTTP.
+	prev = srvr.newClient([]byte("takeover"))
+	sess := srvr.HTTP.Add(prev, time.Now())
+	prev.Close("replaced by a new connection")
+	select {
+	case <-sess.done:
+	default:
+		t.Error("the HTTP session wasn't ended")
+	}
+	if _, ok := srvr.HTTP.Get("takeover", time.Now()); ok {
+		t.Error("the HTTP session wasn't removed")
+	}
+}
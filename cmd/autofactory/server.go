@@ -1,7 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"net"
 	"net/url"
+	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/flatbuffers/go"
@@ -9,6 +14,7 @@ import (
 	influx "github.com/influxdata/influxdb/client/v2"
 	"github.com/mohae/autofact"
 	"github.com/mohae/autofact/cmd/autofactory/output"
+	"github.com/mohae/autofact/command"
 	"github.com/mohae/autofact/conf"
 	"github.com/mohae/autofact/db"
 	"github.com/mohae/autofact/message"
@@ -44,6 +50,56 @@ type server struct {
 	db.Bolt `json:"-"`
 	// InfluxDB client
 	*InfluxClient `json:"-"`
+	// OpenTSDB client
+	OpenTSDB *OpenTSDBClient `json:"-"`
+	// OTLP client
+	OTLP *OTLPClient `json:"-"`
+	// PostgreSQL client
+	Postgres *PostgresClient `json:"-"`
+	// Embedded time-series store
+	Embedded *EmbeddedClient `json:"-"`
+	// Stream distributes incoming data to subscribers.
+	Stream *hub `json:"-"`
+	// Alerts evaluates the alert rules against incoming data.
+	Alerts *alerter `json:"-"`
+	// RulesFile is the location of the alert rules file.
+	RulesFile string `json:"-"`
+	// Anomalies detects incoming data that deviates from its baseline.
+	Anomalies *detector `json:"-"`
+	// Commands sends commands to the connected clients.
+	Commands *commander `json:"-"`
+	// MinProtocolVersion is the oldest client protocol version that is
+	// accepted.
+	MinProtocolVersion int16 `json:"-"`
+	// HealthbeatPush is whether clients that support it push their
+	// healthbeat instead of the server pulling it.
+	HealthbeatPush bool `json:"-"`
+	// MaxFrameSize is the maximum size, in bytes, of a message read from a
+	// client.
+	MaxFrameSize int64 `json:"-"`
+	// HTTP are the sessions of the clients using the HTTP transport.
+	HTTP *httpSessions `json:"-"`
+	// RateLimits are the clients' ingest rate limits.
+	RateLimits *rateLimits `json:"-"`
+	// RateLimitsFile is the location of the per client rate limits file.
+	RateLimitsFile string `json:"-"`
+	// Cluster shares the inventory with the other instances of the cluster;
+	// it is nil if the server isn't part of a cluster.
+	Cluster *cluster `json:"-"`
+	// Fingerprints maps the fingerprints of the clients' hosts to their IDs.
+	Fingerprints *fingerprints `json:"-"`
+	// Forward forwards the clients' data to an upstream autofactory; it is
+	// nil if the data isn't forwarded.
+	Forward *forwarder `json:"-"`
+	// Federation receives the data forwarded by regional autofactories; it
+	// is nil if forwarded data isn't accepted.
+	Federation *federation `json:"-"`
+	// AdminKey is the key that requests to the admin endpoints must have;
+	// if it is empty, the admin endpoints refuse all changes.
+	AdminKey string `json:"-"`
+	// Dedup remembers the received message IDs so that retransmitted
+	// messages aren't processed again.
+	Dedup *dedup `json:"-"`
 	// DB info.
 	// TODO: should this be persisted; if not, remove the json tags
 	BoltDBFile    string `json:"bolt_db_file"`
@@ -57,9 +113,17 @@ type server struct {
 }
 
 func newServer() *server {
-	return &server{
-		Inventory: newInventory(),
+	s := &server{
+		Inventory:          newInventory(),
+		Stream:             newHub(),
+		Dedup:              newDedup(DedupWindow),
+		MinProtocolVersion: message.LegacyProtocolVersion,
+		MaxFrameSize:       message.DefaultMaxFrameSize,
+		RateLimits:         &rateLimits{Default: rateLimit{Policy: PolicyDrop}},
+		HTTP:               newHTTPSessions(),
 	}
+	s.Commands = newCommander(func() snoflinga.Flake { return s.idGen.Snowflake() })
+	return s
 }
 
 // NewSnowflakeGenerator gets a new snowflake generator for message id
@@ -68,6 +132,32 @@ func (s *server) NewSnowflakeGenerator() {
 	s.idGen = snoflinga.New(s.ID)
 }
 
+// serverKinds are the message kinds the server understands.
+var serverKinds = []message.Kind{
+	message.EOT,
+	message.ClientConf,
+	message.Hello,
+	message.Command,
+	message.CommandReply,
+	message.Ack,
+	message.SysInfoJSON,
+	message.CPUUtilization,
+	message.LoadAvg,
+	message.MemInfo,
+	message.NetUsage,
+	message.Relay,
+}
+
+// Protocol returns the protocol the server supports.
+func (s *server) Protocol() message.Protocol {
+	return message.Protocol{
+		Version:    message.ProtocolVersion,
+		MinVersion: s.MinProtocolVersion,
+		Kinds:      serverKinds,
+		Codecs:     message.CodecNames(),
+	}
+}
+
 // LoadInventory populates the server's inventory from the database.  This
 // is a cached list of clients.
 // TODO: should the client configs be cached or should they be read from
@@ -108,6 +198,245 @@ func (s *server) SetInfluxDB(u, p string) error {
 	return nil
 }
 
+// SetOpenTSDB sets up the OpenTSDB output and starts its writer.
+func (s *server) SetOpenTSDB(addr string, size int, interval time.Duration, retries int) {
+	s.OpenTSDB = newOpenTSDBClient(addr, size, interval, retries)
+	go s.OpenTSDB.Run()
+}
+
+// SetOTLP sets up the OTLP output and starts its exporter.
+func (s *server) SetOTLP(addr string, size int, interval time.Duration, retries int) {
+	s.OTLP = newOTLPClient(addr, size, interval, retries)
+	go s.OTLP.Run()
+}
+
+// SetPostgres connects to PostgreSQL, creating the tables if necessary, and
+// starts its writer.
+func (s *server) SetPostgres(dsn string, timescale bool, size int, interval time.Duration, retries int) error {
+	var err error
+	s.Postgres, err = newPostgresClient(dsn, timescale, size, interval, retries)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "connect to postgres"),
+		)
+		return err
+	}
+	go s.Postgres.Run()
+	return nil
+}
+
+// SetEmbedded opens the embedded time-series store and starts its writer and
+// retention enforcement.
+func (s *server) SetEmbedded(name string, retention map[db.Resolution]time.Duration, size int, interval time.Duration) error {
+	var err error
+	s.Embedded, err = newEmbeddedClient(name, retention, size, interval)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "open embedded store"),
+			zap.String("file", name),
+		)
+		return err
+	}
+	go s.Embedded.Run()
+	go s.Embedded.Enforce(EnforcePeriod)
+	return nil
+}
+
+// SetAlerts restores the persisted alerts and silences, loads the alert rules,
+// and starts sending notifications.  If the rules file doesn't exist, there
+// won't be any rules, or notifiers, until it is created and the rules are
+// reloaded.  This must be called after the database is opened.
+func (s *server) SetAlerts(name string) error {
+	s.RulesFile = name
+	s.Alerts = newAlerter(&s.Bolt)
+	s.Alerts.targets = s.maintenanceTargets
+	s.Alerts.annotate = s.ClientEvent
+	err := s.Alerts.Restore()
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "restore alerts"),
+		)
+		return err
+	}
+	err = s.Alerts.LoadRules(name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error(
+				err.Error(),
+				zap.String("op", "load alert rules"),
+				zap.String("file", name),
+			)
+			return err
+		}
+		log.Warn(
+			"alert rules file not found, no alert rules will be evaluated",
+			zap.String("op", "load alert rules"),
+			zap.String("file", name),
+		)
+	}
+	go s.Alerts.Run(AlertPeriod)
+	return nil
+}
+
+// maintenanceTargets returns the IDs of the maintenance window's clients.
+func (s *server) maintenanceTargets(m *maintenance) []string {
+	if len(m.Tags) == 0 {
+		return m.Clients
+	}
+	return s.queryClients(query{Clients: m.Clients, Tags: m.Tags})
+}
+
+// ClientEvent writes an event to the output for the client.  The client
+// doesn't need to be connected; it only needs to be in the inventory.
+func (s *server) ClientEvent(id, kind, msg string) {
+	c, ok := s.Client([]byte(id))
+	if !ok {
+		return
+	}
+	c.SetFuncs()
+	c.Event(kind, msg)
+}
+
+// ReloadRules reloads the alert rules from the rules file.  If the reload
+// fails, the current rules are kept.
+func (s *server) ReloadRules() error {
+	err := s.Alerts.LoadRules(s.RulesFile)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "reload alert rules"),
+			zap.String("file", s.RulesFile),
+		)
+		return err
+	}
+	log.Info(
+		"alert rules reloaded",
+		zap.String("op", "reload alert rules"),
+		zap.String("file", s.RulesFile),
+	)
+	return nil
+}
+
+// SetRateLimits sets the default rate limit and loads the per client rate
+// limits.  If the rate limits file doesn't exist, all clients use the
+// default.
+func (s *server) SetRateLimits(def rateLimit, name string) error {
+	err := def.Validate()
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "set rate limits"),
+		)
+		return err
+	}
+	s.RateLimitsFile = name
+	s.RateLimits = &rateLimits{Default: def}
+	err = s.RateLimits.Load(name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error(
+				err.Error(),
+				zap.String("op", "load rate limits"),
+				zap.String("file", name),
+			)
+			return err
+		}
+		log.Debug(
+			"rate limits file not found, all clients will use the default rate limit",
+			zap.String("op", "load rate limits"),
+			zap.String("file", name),
+		)
+	}
+	return nil
+}
+
+// SetCluster makes the server an instance of a cluster that shares its
+// client inventory.
+func (s *server) SetCluster(node string, peers []string, key string) error {
+	var err error
+	s.Cluster, err = newCluster(node, peers, key)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "set cluster"),
+		)
+		return err
+	}
+	return nil
+}
+
+// SetFingerprints sets the fingerprint conflict policy and loads the saved
+// fingerprints.
+func (s *server) SetFingerprints(policy string) error {
+	var err error
+	s.Fingerprints, err = newFingerprints(policy)
+	if err == nil {
+		err = s.Fingerprints.Load(&s.Bolt)
+	}
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "set fingerprints"),
+		)
+		return err
+	}
+	return nil
+}
+
+// SetForward forwards the clients' data to the upstream autofactory at addr.
+// The tags identify this autofactory's region.
+func (s *server) SetForward(addr, key string, tags map[string]string, size int, interval time.Duration) error {
+	var err error
+	s.Forward, err = newForwarder(addr, key, tags, size, interval)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "set forward"),
+		)
+		return err
+	}
+	go s.Forward.Run()
+	return nil
+}
+
+// ReloadRateLimits reloads the per client rate limits from the rate limits
+// file.  The new rate limits apply to clients when they next connect.  If the
+// reload fails, the current rate limits are kept.
+func (s *server) ReloadRateLimits() error {
+	err := s.RateLimits.Load(s.RateLimitsFile)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "reload rate limits"),
+			zap.String("file", s.RateLimitsFile),
+		)
+		return err
+	}
+	log.Info(
+		"rate limits reloaded",
+		zap.String("op", "reload rate limits"),
+		zap.String("file", s.RateLimitsFile),
+	)
+	return nil
+}
+
+// SetAnomalies sets up anomaly detection for the metrics.
+func (s *server) SetAnomalies(metrics []string, alpha, sigma float64, seasonal bool) error {
+	var err error
+	s.Anomalies, err = newDetector(metrics, alpha, sigma, seasonal)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "set up anomaly detection"),
+		)
+		return err
+	}
+	return nil
+}
+
 // connects to InfluxDB
 func (s *server) connectToInfluxDB() error {
 	var err error
@@ -122,12 +451,23 @@ func (s *server) Client(id []byte) (*Client, bool) {
 	if !ok {
 		return nil, false
 	}
-	return &Client{
+	cl := &Client{
 		Conf:         c,
 		InfluxClient: s.InfluxClient,
+		OpenTSDB:     s.OpenTSDB,
+		OTLP:         s.OTLP,
+		Postgres:     s.Postgres,
+		Embedded:     s.Embedded,
+		hub:          s.Stream,
+		alerts:       s.Alerts,
+		anomalies:    s.Anomalies,
+		dedup:        s.Dedup,
+		forward:      s.Forward,
 		tsLayout:     s.TSLayout,
 		useTS:        s.UseTS,
-	}, true
+	}
+	cl.setData()
+	return cl, true
 }
 
 // NewClient creates a new Node, adds it to the server's inventory and
@@ -138,12 +478,20 @@ func (s *server) NewClient() (c *Client, err error) {
 	s.Inventory.mu.Lock()
 	defer s.Inventory.mu.Unlock()
 	for {
-		// TODO replace with a rand bytes or striing
-		id := randchars.AlphaNum(util.IDLen)
+		id := s.newID()
 		if !s.Inventory.clientExists(id) {
 			c = s.newClient(id)
 			s.Inventory.clients[string(id)] = c.Conf
 			c.InfluxClient = s.InfluxClient
+			c.OpenTSDB = s.OpenTSDB
+			c.OTLP = s.OTLP
+			c.Postgres = s.Postgres
+			c.Embedded = s.Embedded
+			c.hub = s.Stream
+			c.alerts = s.Alerts
+			c.anomalies = s.Anomalies
+			c.dedup = s.Dedup
+			c.forward = s.Forward
 			break
 		}
 	}
@@ -154,6 +502,29 @@ func (s *server) NewClient() (c *Client, err error) {
 	return c, err
 }
 
+// newID returns a new client ID.  In a cluster, the ID is prefixed with the
+// server's node so that it doesn't collide with those of the other instances.
+func (s *server) newID() []byte {
+	if s.Cluster != nil {
+		return s.Cluster.NewID()
+	}
+	return randchars.AlphaNum(util.IDLen)
+}
+
+// AddReplicatedClient adds a client that another instance of the cluster
+// created, or updated, to the inventory and saves it to the database.
+func (s *server) AddReplicatedClient(c *conf.Client) {
+	s.Inventory.AddClient(c)
+	err := s.Bolt.SaveClient(c)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "save replicated client"),
+			zap.String("client", string(c.IDBytes())),
+		)
+	}
+}
+
 func (s *server) newClient(id []byte) *Client {
 	bldr := flatbuffers.NewBuilder(0)
 	v := bldr.CreateByteVector(id)
@@ -167,11 +538,7 @@ func (s *server) newClient(id []byte) *Client {
 	c := Client{
 		Conf: conf.GetRootAsClient(bldr.Bytes[bldr.Head():], 0),
 	}
-	if data != nil {
-		c.Data = data.With(
-			czap.String("client", string(c.Conf.IDBytes())),
-		)
-	}
+	c.setData()
 	return &c
 }
 
@@ -193,19 +560,114 @@ func (s *server) WriteBinaryMessage(client string, conn *websocket.Conn, k messa
 // Client holds information about a client.
 type Client struct {
 	Conf *conf.Client
-	WS   *websocket.Conn
+	// WS is the client's websocket connection, if it has one.  It is set
+	// before the connection is served and isn't changed afterwards: each
+	// connection has its own Client.
+	WS *websocket.Conn
+	// Protocol is the protocol agreed on with the client.
+	Protocol message.Protocol
+	// codec is the agreed on codec; binary messages are encoded with it on
+	// write and decoded with it on read.
+	codec message.Codec
+	// wmu serializes writes to WS.
+	wmu sync.Mutex
+	// pending are the messages for a client using the HTTP transport; they
+	// are sent in the response to its next request.
+	pending [][]byte
+	// relay is the relay that the client is connected through, if any; dst
+	// identifies the client on the relay's connection.
+	relay *Client
+	dst   uint32
+	// relayed are the clients connected through this client, by DstID.
+	rmu     sync.Mutex
+	relayed map[uint32]*relayed
 	*InfluxClient
+	OpenTSDB       *OpenTSDBClient
+	OTLP           *OTLPClient
+	Postgres       *PostgresClient
+	Embedded       *EmbeddedClient
+	hub            *hub
+	alerts         *alerter
+	anomalies      *detector
+	dedup          *dedup
+	forward        *forwarder
+	acks           *acker
+	limiter        *limiter
 	isConnected    bool
 	CPUUtilization func(*message.Message)
 	LoadAvg        func(*message.Message)
 	MemInfo        func(*message.Message)
 	NetUsage       func(*message.Message)
+	Event          func(kind, msg string)
 	tsLayout       string //the layout for timestamps
 	useTS          bool
+	// tags are added to the client's data; e.g. the tags of the regional
+	// autofactory that forwarded the client's messages.
+	tags map[string]string
 	// Data is a child Data Logger with relevant context for when output is to a File.
 	Data czap.Logger
 }
 
+// WriteMessage writes the message to the client's connection.  Binary
+// messages are encoded using the client's codec.  The binary messages of a
+// relayed client are written to its relay's connection.  Clients using the
+// HTTP transport don't have a connection: their binary messages are held
+// until their next request.  Relayed clients, and clients using the HTTP
+// transport, can't be sent other messages.  This is safe for concurrent use.
+func (c *Client) WriteMessage(typ int, p []byte) error {
+	if typ == websocket.BinaryMessage && c.codec != nil {
+		var err error
+		p, err = c.codec.Encode(p)
+		if err != nil {
+			return err
+		}
+	}
+	if c.relay != nil {
+		if typ != websocket.BinaryMessage {
+			return fmt.Errorf("%s messages can't be sent to relayed clients", util.WSString(typ))
+		}
+		return c.relay.WriteMessage(websocket.BinaryMessage, message.SerializeRelay(c.dst, p))
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.WS == nil {
+		if typ != websocket.BinaryMessage {
+			return fmt.Errorf("%s messages can't be sent using the HTTP transport", util.WSString(typ))
+		}
+		if len(c.pending) >= MaxHTTPPending {
+			c.pending = c.pending[1:]
+		}
+		c.pending = append(c.pending, p)
+		return nil
+	}
+	return c.WS.WriteMessage(typ, p)
+}
+
+// Pending returns the messages held for a client using the HTTP transport
+// and clears them.
+func (c *Client) Pending() [][]byte {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	p := c.pending
+	c.pending = nil
+	return p
+}
+
+// WriteBinaryMessage serializes a message and writes it to the client's
+// connection using the client's codec.
+func (c *Client) WriteBinaryMessage(k message.Kind, p []byte) {
+	err := c.WriteMessage(websocket.BinaryMessage, message.Serialize(srvr.idGen.Snowflake(), k, p))
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "write binary message"),
+			zap.String("client", string(c.Conf.IDBytes())),
+			zap.String("kind", k.String()),
+			zap.Base64("message", p),
+		)
+	}
+}
+
 // SetFuncs sets the processing func for the client based on the output destination type.
 func (c *Client) SetFuncs() {
 	// at this point outputType is a supported output.Type so only need to handle
@@ -216,22 +678,64 @@ func (c *Client) SetFuncs() {
 		c.LoadAvg = c.LoadAvgFile
 		c.MemInfo = c.MemInfoFile
 		c.NetUsage = c.NetUsageFile
+		c.Event = c.EventFile
 	case output.InfluxDB:
 		c.CPUUtilization = c.CPUUtilizationInfluxDB
 		c.LoadAvg = c.LoadAvgInfluxDB
 		c.MemInfo = c.MemInfoInfluxDB
 		c.NetUsage = c.NetUsageFile
+		c.Event = c.EventInfluxDB
+	case output.OpenTSDB:
+		c.CPUUtilization = c.CPUUtilizationOpenTSDB
+		c.LoadAvg = c.LoadAvgOpenTSDB
+		c.MemInfo = c.MemInfoOpenTSDB
+		c.NetUsage = c.NetUsageOpenTSDB
+		c.Event = c.EventOpenTSDB
+	case output.OTLP:
+		c.CPUUtilization = c.CPUUtilizationOTLP
+		c.LoadAvg = c.LoadAvgOTLP
+		c.MemInfo = c.MemInfoOTLP
+		c.NetUsage = c.NetUsageOTLP
+		c.Event = c.EventOTLP
+	case output.Postgres:
+		c.CPUUtilization = c.CPUUtilizationPostgres
+		c.LoadAvg = c.LoadAvgPostgres
+		c.MemInfo = c.MemInfoPostgres
+		c.NetUsage = c.NetUsagePostgres
+		c.Event = c.EventPostgres
+	case output.Embedded:
+		c.CPUUtilization = c.CPUUtilizationEmbedded
+		c.LoadAvg = c.LoadAvgEmbedded
+		c.MemInfo = c.MemInfoEmbedded
+		c.NetUsage = c.NetUsageEmbedded
+		c.Event = c.EventEmbedded
 	}
 }
 
-// Listen listens for messages and handles them accordingly.  Binary messages
-// are expected to be  Flatbuffer serialized bytes containing a Message.
-func (c *Client) Listen(doneCh chan struct{}) {
+// Listen listens for messages on conn, the client's websocket connection, and
+// handles them accordingly.  Binary messages are expected to be  Flatbuffer
+// serialized bytes containing a Message.
+func (c *Client) Listen(conn *websocket.Conn, doneCh chan struct{}) {
 	// loop until there's a done signal
 	defer close(doneCh)
 	for {
-		typ, p, err := c.WS.ReadMessage()
+		// anything from the client, including a pong, shows that the
+		// connection is alive.
+		conn.SetReadDeadline(time.Now().Add(autofact.PongWait))
+		typ, p, err := conn.ReadMessage()
 		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Warn(
+					"client timed out: closing connection",
+					zap.String("op", "read message"),
+					zap.String("client", string(c.Conf.IDBytes())),
+				)
+				return
+			}
+			if err == websocket.ErrReadLimit {
+				c.Reject(err, "read message")
+				return
+			}
 			if _, ok := err.(*websocket.CloseError); !ok {
 				log.Error(
 					err.Error(),
@@ -252,6 +756,13 @@ func (c *Client) Listen(doneCh chan struct{}) {
 			)
 			return
 		}
+		srvr.Inventory.Seen(c.Conf.IDBytes())
+		if c.limiter != nil && !c.Limit(len(p)) {
+			if c.limiter.Policy == PolicyDisconnect {
+				return
+			}
+			continue
+		}
 		switch typ {
 		case websocket.TextMessage:
 			// Currently, no text message are expected so warn.
@@ -264,6 +775,18 @@ func (c *Client) Listen(doneCh chan struct{}) {
 			)
 
 		case websocket.BinaryMessage:
+			if c.codec != nil {
+				p, err = c.codec.Decode(p)
+				if err != nil {
+					c.Reject(err, "decode message")
+					continue
+				}
+			}
+			err = message.Verify(p)
+			if err != nil {
+				c.Reject(err, "verify message")
+				continue
+			}
 			c.processBinaryMessage(p)
 		case websocket.CloseMessage:
 			log.Info(
@@ -277,30 +800,137 @@ func (c *Client) Listen(doneCh chan struct{}) {
 	}
 }
 
+// Reject counts, and logs, a frame from the client that was rejected because
+// it couldn't be read, decoded, or verified.
+func (c *Client) Reject(err error, op string) {
+	n := srvr.Inventory.Reject(c.Conf.IDBytes())
+	log.Warn(
+		err.Error(),
+		zap.String("op", op),
+		zap.String("client", string(c.Conf.IDBytes())),
+		zap.Uint64("rejected", n),
+	)
+}
+
+// Limit applies the client's rate limit to a message of n bytes and returns
+// whether the message should be processed.  Depending on the policy, a message
+// that exceeds the limit is dropped, is delayed until it is within the limit,
+// or results in the connection being closed.
+func (c *Client) Limit(n int) bool {
+	now := time.Now()
+	wait := c.limiter.Wait(n, now)
+	if wait == 0 {
+		c.limiter.Take(n)
+		return true
+	}
+	cnt := srvr.Inventory.Throttle(c.Conf.IDBytes(), now)
+	log.Debug(
+		"rate limit exceeded",
+		zap.String("op", "rate limit"),
+		zap.String("client", string(c.Conf.IDBytes())),
+		zap.String("policy", c.limiter.Policy),
+		zap.Uint64("throttled", cnt),
+	)
+	switch c.limiter.Policy {
+	case PolicyThrottle:
+		// not reading from the connection while waiting applies
+		// backpressure to the client.
+		time.Sleep(wait)
+		c.limiter.Take(n)
+		return true
+	case PolicyDisconnect:
+		log.Warn(
+			"rate limit exceeded: closing connection",
+			zap.String("op", "rate limit"),
+			zap.String("client", string(c.Conf.IDBytes())),
+		)
+		if c.WS != nil {
+			c.WS.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded"), time.Now().Add(autofact.WriteWait))
+		}
+	}
+	return false
+}
+
+// MissedHealthbeats is the number of consecutive healthbeats a client can miss
+// before it is considered down.
+var MissedHealthbeats = 3
+
 // Healthbeat pulls info from the client on a set interval.  If the client
 // doesn't respond before the deadline, an error is generated.  If the client
 // doesn't respond to several consecutive requests, an error is generated and
-// the client connection is closed.
+// the client connection is closed.  A client that misses MissedHealthbeats
+// consecutive healthbeats is alerted on as down.
+//
+// If the client pushes its healthbeat, nothing is requested: only the
+// arrival of its healthbeats is tracked.
 func (c *Client) Healthbeat(done chan struct{}) {
 	// If this was set to 0; don't do a healthbeat.
 	if c.Conf.HealthbeatPeriod() == 0 {
 		return
 	}
-	ticker := time.NewTicker(time.Duration(c.Conf.HealthbeatPeriod()))
+	push := util.ByteToBool(c.Conf.HealthbeatPush())
+	period := time.Duration(c.Conf.HealthbeatPeriod())
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	var down bool
+	for {
+		select {
+		case t := <-ticker.C:
+			if !push {
+				// request the Healthbeat; serveClient will handle the response.
+				err := c.WriteMessage(websocket.TextMessage, autofact.LoadAvg)
+				if err != nil {
+					log.Error(
+						err.Error(),
+						zap.String("op", "health request"),
+						zap.String("client", string(c.Conf.IDBytes())),
+					)
+					return
+				} // add the data
+			}
+			if c.alerts == nil {
+				continue
+			}
+			// a client that is connected but hasn't responded to the last
+			// few healthbeats, or hasn't pushed them, is down.
+			st := srvr.Inventory.Status(c.Conf.IDBytes())
+			last := st.LastSeen
+			if push {
+				last = st.LastHealthbeat
+			}
+			missed := t.Sub(last) > time.Duration(MissedHealthbeats)*period
+			if missed && !down {
+				c.alerts.ClientDown(c.Resource(), t)
+			}
+			if !missed && down {
+				c.alerts.ClientUp(string(c.Conf.IDBytes()), t)
+			}
+			down = missed
+		case <-done:
+			return
+		}
+	}
+}
+
+// Ping pings the client on conn, its websocket connection, every PingPeriod.
+// The client's pongs extend the connection's read deadline, so a client that
+// stops responding, e.g. because the connection is half-open, is disconnected
+// once the deadline passes.
+func (c *Client) Ping(conn *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(autofact.PingPeriod)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			// request the Healthbeat; serveClient will handle the response.
-			err := c.WS.WriteMessage(websocket.TextMessage, autofact.LoadAvg)
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(autofact.WriteWait))
 			if err != nil {
 				log.Error(
 					err.Error(),
-					zap.String("op", "health request"),
+					zap.String("op", "ping"),
 					zap.String("client", string(c.Conf.IDBytes())),
 				)
 				return
-			} // add the data
+			}
 		case <-done:
 			return
 		}
@@ -316,6 +946,27 @@ func (c *Client) processBinaryMessage(p []byte) error {
 	msg := message.GetRootAsMessage(p, 0)
 	// process according to kind
 	k := message.Kind(msg.Kind())
+	// Messages are acknowledged once they have been processed.  A message
+	// that has already been received is a retransmit of a message whose
+	// acknowledgement didn't reach the client: it is acknowledged again but
+	// isn't processed.
+	if id := msg.IDBytes(); len(id) > 0 {
+		if c.acks != nil {
+			defer c.acks.Add(id)
+		}
+		if c.dedup != nil && c.dedup.Seen(string(c.Conf.IDBytes()), id, time.Now()) {
+			log.Debug(
+				"duplicate message",
+				zap.String("op", "process binary message"),
+				zap.String("client", string(c.Conf.IDBytes())),
+				zap.String("kind", k.String()),
+			)
+			return nil
+		}
+	}
+	if c.forward != nil && forwardKinds[k] {
+		c.forward.Forward(c.Conf, p)
+	}
 	switch k {
 	case message.CPUUtilization:
 		log.Debug(
@@ -323,30 +974,35 @@ func (c *Client) processBinaryMessage(p []byte) error {
 			zap.String("client", string(c.Conf.Hostname())),
 		)
 		c.CPUUtilization(msg)
+		c.observe(k, msg)
 	case message.LoadAvg:
 		log.Debug(
 			"loadavg",
 			zap.String("client", string(c.Conf.Hostname())),
 		)
+		srvr.Inventory.Healthbeat(c.Conf.IDBytes())
 		c.LoadAvg(msg)
+		c.observe(k, msg)
 	case message.MemInfo:
 		log.Debug(
 			"meminfo",
 			zap.String("client", string(c.Conf.Hostname())),
 		)
 		c.MemInfo(msg)
+		c.observe(k, msg)
 	case message.NetUsage:
 		log.Debug(
 			"netusage",
 			zap.String("client", string(c.Conf.Hostname())),
 		)
 		c.NetUsage(msg)
+		c.observe(k, msg)
 	case message.SysInfoJSON:
 		log.Debug(
 			"sysinfojson",
 			zap.String("client", string(c.Conf.Hostname())),
 		)
-		s, err := systeminfo.JSONUnmarshal(p)
+		s, err := systeminfo.JSONUnmarshal(msg.DataBytes())
 		if err != nil {
 			log.Error(
 				err.Error(),
@@ -362,6 +1018,24 @@ func (c *Client) processBinaryMessage(p []byte) error {
 			zap.String("client", string(c.Conf.Hostname())),
 			zap.Object("data", s),
 		)
+	case message.CommandReply:
+		srvr.Commands.Reply(string(c.Conf.IDBytes()), command.GetRootAsReply(msg.DataBytes(), 0))
+	case message.Relay:
+		if !c.Protocol.Supports(message.Relay) {
+			c.Reject(fmt.Errorf("relay messages weren't agreed on"), "process binary message")
+			return nil
+		}
+		c.Relay(msg)
+	case message.EOT:
+		// a relayed client disconnected from the relay.
+		if msg.DstID() != 0 && c.removeRelayed(msg.DstID()) {
+			log.Info(
+				"relayed client disconnected",
+				zap.String("op", "process binary message"),
+				zap.String("relay", string(c.Conf.IDBytes())),
+				zap.String("reason", string(msg.DataBytes())),
+			)
+		}
 	default:
 		log.Error(
 			"unsupported message kind",
@@ -374,11 +1048,67 @@ func (c *Client) processBinaryMessage(p []byte) error {
 	return nil
 }
 
+// observe passes the message's points to the stream's subscribers, the alert
+// rules, and the anomaly detector.  The message is only decoded if one of
+// them wants it.  Anomalies are written to the output as events.
+func (c *Client) observe(k message.Kind, msg *message.Message) {
+	stream := c.hub != nil && c.hub.Len() > 0 && c.hub.Wants(k, c.Resource())
+	alerts := c.alerts != nil && c.alerts.Wants(k)
+	anomalies := c.anomalies != nil && c.anomalies.Wants(k)
+	if !stream && !alerts && !anomalies {
+		return
+	}
+	pts := c.Points(k, msg)
+	if stream {
+		c.hub.Publish(k, pts)
+	}
+	if alerts {
+		c.alerts.Eval(pts)
+	}
+	if anomalies {
+		for _, a := range c.anomalies.Observe(pts) {
+			log.Info(
+				a.String(),
+				zap.String("op", "detect anomaly"),
+				zap.String("client", a.Client),
+			)
+			c.Event("anomaly", a.String())
+		}
+	}
+}
+
+// setData sets the client's data logger; its entries are tagged with the
+// client's resource.
+func (c *Client) setData() {
+	if data == nil {
+		return
+	}
+	res := c.Resource()
+	keys := make([]string, 0, len(res))
+	for k := range res {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fields := make([]czap.Field, len(keys))
+	for i, k := range keys {
+		fields[i] = czap.String(k, res[k])
+	}
+	c.Data = data.With(fields...)
+}
+
+// influxTags returns the tags of the client's InfluxDB points: the client's
+// resource without its ID.
+func (c *Client) influxTags() map[string]string {
+	tags := c.Resource()
+	delete(tags, "client")
+	return tags
+}
+
 // CPUUtilizationInfluxDB processes CPUUtilization messages and saves to
 // InfluxDB
 func (c *Client) CPUUtilizationInfluxDB(msg *message.Message) {
 	cpus := cpuutil.Deserialize(msg.DataBytes())
-	tags := map[string]string{"host": string(c.Conf.Hostname()), "region": string(c.Conf.Region())}
+	tags := c.influxTags()
 	// Each cpu is it's own point, make a slice to accommodate them all and process.
 	pts := make([]*influx.Point, 0, len(cpus.CPU))
 	for _, cpu := range cpus.CPU {
@@ -438,7 +1168,7 @@ func (c *Client) CPUUtilizationFile(msg *message.Message) {
 // LoadAvgInfluxDB processes LoadAvg messages and saves to InfluxDB.
 func (c *Client) LoadAvgInfluxDB(msg *message.Message) {
 	l := loadavg.Deserialize(msg.DataBytes())
-	tags := map[string]string{"host": string(c.Conf.Hostname()), "region": string(c.Conf.Region())}
+	tags := c.influxTags()
 	fields := map[string]interface{}{
 		"one":     l.One,
 		"five":    l.Five,
@@ -472,7 +1202,7 @@ func (c *Client) LoadAvgFile(msg *message.Message) {
 // MemInfoInfluxDB processes MemInfo messages and saves to InfluxDB.
 func (c *Client) MemInfoInfluxDB(msg *message.Message) {
 	m := mem.Deserialize(msg.DataBytes())
-	tags := map[string]string{"host": string(c.Conf.Hostname()), "region": string(c.Conf.Region())}
+	tags := c.influxTags()
 	fields := map[string]interface{}{
 		"total_ram":  m.TotalRAM,
 		"free_ram":   m.FreeRAM,
@@ -512,7 +1242,7 @@ func (c *Client) MemInfoFile(msg *message.Message) {
 // NetUsageInfluxDB processes NetUSage messages and saves them to InfluxDB
 func (c *Client) NetUsageInfluxDB(msg *message.Message) {
 	devs := netusage.Deserialize(msg.DataBytes())
-	tags := map[string]string{"host": string(c.Conf.Hostname()), "region": string(c.Conf.Region())}
+	tags := c.influxTags()
 	// Make a slice of points whose length is equal to the number of Interfaces
 	// and process the interfaces.
 	pts := make([]*influx.Point, 0, len(devs.Device))
@@ -586,6 +1316,36 @@ func (c *Client) NetUsageFile(msg *message.Message) {
 	}
 }
 
+// EventInfluxDB writes the event to InfluxDB.
+func (c *Client) EventInfluxDB(kind, msg string) {
+	tags := c.influxTags()
+	tags["kind"] = kind
+	fields := map[string]interface{}{
+		"message": msg,
+	}
+	pt, err := influx.NewPoint("events", tags, fields, time.Now().UTC())
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "create point"),
+			zap.String("client", string(c.Conf.IDBytes())),
+			zap.String("event", kind),
+		)
+		return
+	}
+	c.InfluxClient.pointsCh <- []*influx.Point{pt}
+}
+
+// EventFile writes the event to the data file as JSON.
+func (c *Client) EventFile(kind, msg string) {
+	c.Data.Info(
+		"event",
+		czap.String("ts", c.FormattedTime(time.Now().UnixNano())),
+		czap.String("kind", kind),
+		czap.String("message", msg),
+	)
+}
+
 // FormattedTime returns the nanoseconds as a formatted datetime string using
 // the client's layout.
 func (c *Client) FormattedTime(t int64) string {
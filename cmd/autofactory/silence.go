@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mohae/autofact/util"
+	"github.com/mohae/randchars"
+	"github.com/uber-go/zap"
+)
+
+// silence suppresses the notifications of the alerts that match it while it
+// is active.  Silenced alerts are still evaluated.
+type silence struct {
+	ID string `json:"id"`
+	// Matchers are the labels an alert must have to be silenced.  rule and
+	// severity can be used along with the alert labels.
+	Matchers  map[string]string `json:"matchers"`
+	StartsAt  time.Time         `json:"starts_at"`
+	EndsAt    time.Time         `json:"ends_at"`
+	CreatedBy string            `json:"created_by,omitempty"`
+	Comment   string            `json:"comment,omitempty"`
+}
+
+// Validate checks that the silence can be used.
+func (s *silence) Validate() error {
+	if len(s.Matchers) == 0 {
+		return errors.New("silence: at least one matcher is required")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("silence: ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// Active returns whether the silence is in effect at t.
+func (s *silence) Active(t time.Time) bool {
+	return !t.Before(s.StartsAt) && t.Before(s.EndsAt)
+}
+
+// AddSilence adds the silence and persists it.  If the silence doesn't have an
+// ID, one is generated; if it doesn't have a start, it starts now.
+func (a *alerter) AddSilence(s silence) (silence, error) {
+	if s.StartsAt.IsZero() {
+		s.StartsAt = time.Now()
+	}
+	err := s.Validate()
+	if err != nil {
+		return s, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s.ID == "" {
+		for {
+			s.ID = string(randchars.AlphaNum(util.IDLen))
+			if _, ok := a.silences[s.ID]; !ok {
+				break
+			}
+		}
+	}
+	a.silences[s.ID] = &s
+	if a.db != nil {
+		p, err := json.Marshal(s)
+		if err != nil {
+			return s, err
+		}
+		err = a.db.SaveSilence(s.ID, p)
+		if err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// DeleteSilence removes the silence.  False is returned if it doesn't exist.
+func (a *alerter) DeleteSilence(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.silences[id]; !ok {
+		return false
+	}
+	a.deleteSilence(id)
+	return true
+}
+
+// deleteSilence removes the silence.  Errors are logged.  This does not do any
+// locking; it is assumed that the caller is properly managing the lock's
+// state.
+func (a *alerter) deleteSilence(id string) {
+	delete(a.silences, id)
+	if a.db == nil {
+		return
+	}
+	err := a.db.DeleteSilence(id)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "delete silence"),
+			zap.String("id", id),
+		)
+	}
+}
+
+// Silences returns the silences, sorted by start.
+func (a *alerter) Silences() []silence {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	silences := make([]silence, 0, len(a.silences))
+	for _, s := range a.silences {
+		silences = append(silences, *s)
+	}
+	sort.Sort(bySilenceStart(silences))
+	return silences
+}
+
+// silenced returns whether the alert is silenced at t.  This does not do any
+// locking; it is assumed that the caller is properly managing the lock's
+// state.
+func (a *alerter) silenced(al *alert, t time.Time) bool {
+	for _, s := range a.silences {
+		if s.Active(t) && al.matchLabels(s.Matchers) {
+			return true
+		}
+	}
+	return false
+}
+
+// expireSilences removes the silences that have ended.  This does not do any
+// locking; it is assumed that the caller is properly managing the lock's
+// state.
+func (a *alerter) expireSilences(t time.Time) {
+	for id, s := range a.silences {
+		if !t.Before(s.EndsAt) {
+			a.deleteSilence(id)
+		}
+	}
+}
+
+// restoreSilences loads the persisted silences.  This does not do any locking;
+// it is assumed that the caller is properly managing the lock's state.
+func (a *alerter) restoreSilences() error {
+	saved, err := a.db.Silences()
+	if err != nil {
+		return err
+	}
+	for id, p := range saved {
+		var s silence
+		err = json.Unmarshal(p, &s)
+		if err != nil {
+			log.Error(
+				err.Error(),
+				zap.String("op", "restore silence"),
+				zap.String("id", id),
+			)
+			continue
+		}
+		a.silences[id] = &s
+	}
+	return nil
+}
+
+type bySilenceStart []silence
+
+func (b bySilenceStart) Len() int           { return len(b) }
+func (b bySilenceStart) Less(i, j int) bool { return b[i].StartsAt.Before(b[j].StartsAt) }
+func (b bySilenceStart) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// serveSilences handles /api/silences: GET lists the silences, POST adds the
+// silence in the body, and DELETE removes the silence with the id parameter.
+// POST and DELETE require the admin key.
+func serveSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, srvr.Alerts.Silences())
+	case "POST":
+		if !authorizeAdmin(w, r) {
+			return
+		}
+		var s silence
+		err := json.NewDecoder(r.Body).Decode(&s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s, err = srvr.Alerts.AddSilence(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, s)
+	case "DELETE":
+		if !authorizeAdmin(w, r) {
+			return
+		}
+		if !srvr.Alerts.DeleteSilence(r.URL.Query().Get("id")) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
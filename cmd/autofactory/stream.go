@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mohae/autofact"
+	"github.com/mohae/autofact/message"
+	"github.com/uber-go/zap"
+)
+
+// Subscriber buffer sizes.  Once a subscriber's buffer is full, points are
+// dropped until there is room: a slow subscriber must never block ingestion.
+var (
+	// SubscriberBuffer is the default number of points that will be buffered
+	// for a subscriber.
+	SubscriberBuffer = 256
+	// MaxSubscriberBuffer is the largest buffer a subscriber may request.
+	MaxSubscriberBuffer = 4096
+)
+
+// filter selects the points a subscriber receives.  A point must match every
+// part of the filter that is set; an empty filter matches everything.
+type filter struct {
+	// Clients are the IDs of the clients to receive points for.
+	Clients []string `json:"clients,omitempty"`
+	// Tags are client attributes, e.g. region, that must all match.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Kinds are the message kinds, e.g. LoadAvg, to receive points for.
+	Kinds []string `json:"kinds,omitempty"`
+}
+
+// Validate checks that the filter's kinds are kinds of data messages.
+func (f *filter) Validate() error {
+	for _, v := range f.Kinds {
+		k := message.KindFromString(v)
+		switch k {
+		case message.CPUUtilization, message.LoadAvg, message.MemInfo, message.NetUsage:
+		default:
+			return fmt.Errorf("unsupported kind %q", v)
+		}
+	}
+	return nil
+}
+
+// Match returns whether a point of kind k, from the client with the resource
+// attributes, passes the filter.
+func (f *filter) Match(k message.Kind, res map[string]string) bool {
+	if len(f.Clients) > 0 {
+		var ok bool
+		for _, v := range f.Clients {
+			if v == res["client"] {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(f.Kinds) > 0 {
+		var ok bool
+		for _, v := range f.Kinds {
+			if strings.EqualFold(v, k.String()) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return matchTags(res, f.Tags)
+}
+
+// subscriber receives the decoded points that match its filter.
+type subscriber struct {
+	filter
+	C chan point
+	// dropped is the number of points dropped because C was full.
+	dropped uint64
+}
+
+// Dropped returns the number of points that have been dropped.
+func (s *subscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// hub distributes decoded points, as they arrive, to its subscribers.
+type hub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[*subscriber]struct{})}
+}
+
+// Subscribe adds a subscriber for the points that match the filter.  The
+// buffer is the size of the subscriber's buffer; if it is <= 0 the default
+// is used and it is capped at MaxSubscriberBuffer.
+func (h *hub) Subscribe(f filter, buffer int) *subscriber {
+	if buffer <= 0 {
+		buffer = SubscriberBuffer
+	}
+	if buffer > MaxSubscriberBuffer {
+		buffer = MaxSubscriberBuffer
+	}
+	s := &subscriber{
+		filter: f,
+		C:      make(chan point, buffer),
+	}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// SetFilter replaces the subscriber's filter.
+func (h *hub) SetFilter(s *subscriber, f filter) {
+	h.mu.Lock()
+	s.filter = f
+	h.mu.Unlock()
+}
+
+// Unsubscribe removes the subscriber and closes its channel.
+func (h *hub) Unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.C)
+	}
+	h.mu.Unlock()
+}
+
+// Len returns the number of subscribers.
+func (h *hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Wants returns whether any subscriber wants points of kind k from the client
+// with the resource attributes.  This is used to avoid decoding messages
+// nobody is subscribed to.
+func (h *hub) Wants(k message.Kind, res map[string]string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.Match(k, res) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish sends the points, of kind k, to the subscribers whose filter they
+// match.  If a subscriber's buffer is full the point is dropped for that
+// subscriber.
+func (h *hub) Publish(k message.Kind, pts []point) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		for _, pt := range pts {
+			if !s.Match(k, pt.Resource) {
+				continue
+			}
+			select {
+			case s.C <- pt:
+			default:
+				atomic.AddUint64(&s.dropped, 1)
+			}
+		}
+	}
+}
+
+// Points returns the decoded points for a message.  Kinds that aren't data
+// return nil.
+func (c *Client) Points(k message.Kind, msg *message.Message) []point {
+	switch k {
+	case message.CPUUtilization:
+		return c.CPUUtilizationPoints(msg)
+	case message.LoadAvg:
+		return c.LoadAvgPoints(msg)
+	case message.MemInfo:
+		return c.MemInfoPoints(msg)
+	case message.NetUsage:
+		return c.NetUsagePoints(msg)
+	default:
+		return nil
+	}
+}
+
+// streamNotice is sent to a subscriber, in place of a point, to tell it about
+// dropped points or a rejected filter update.
+type streamNotice struct {
+	Dropped uint64 `json:"dropped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// serveSubscribe handles /subscribe: the decoded points that match the
+// subscriber's filter are streamed, as JSON, over a websocket as they arrive.
+// The initial filter is specified with the following parameters:
+//
+//	client: client IDs, comma separated or repeated
+//	tag:    key=value attribute selectors, comma separated or repeated
+//	kind:   message kinds, e.g. LoadAvg, comma separated or repeated
+//	buffer: the number of points to buffer; defaults to SubscriberBuffer
+//
+// The filter can be replaced at any time by sending it as a JSON message,
+// e.g. {"clients": ["id"], "kinds": ["MemInfo"]}.
+func serveSubscribe(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f := filter{
+		Clients: splitValues(r.Form["client"]),
+		Kinds:   splitValues(r.Form["kind"]),
+	}
+	f.Tags, err = parseTags(r.Form["tag"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = f.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var buffer int
+	if v := r.Form.Get("buffer"); v != "" {
+		buffer, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid buffer %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error(
+			err.Error(),
+			zap.String("op", "upgrade subscriber connection"),
+		)
+		return
+	}
+	defer conn.Close()
+	sub := srvr.Stream.Subscribe(f, buffer)
+	defer srvr.Stream.Unsubscribe(sub)
+	streamPoints(conn, sub)
+}
+
+// streamPoints writes the subscriber's points to the connection as JSON until
+// either the connection is closed or the subscription ends.  Filters received
+// from the peer replace the subscriber's filter.  If points have been dropped
+// since the last write, a notice with the total dropped is written first.
+func streamPoints(conn *websocket.Conn, sub *subscriber) {
+	closed := make(chan struct{})
+	notices := make(chan streamNotice, 1)
+	go func() {
+		defer close(closed)
+		for {
+			_, p, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var f filter
+			err = json.Unmarshal(p, &f)
+			if err == nil {
+				err = f.Validate()
+			}
+			if err != nil {
+				select {
+				case notices <- streamNotice{Error: err.Error()}:
+				default:
+				}
+				continue
+			}
+			srvr.Stream.SetFilter(sub, f)
+		}
+	}()
+	var dropped uint64
+	for {
+		var v interface{}
+		select {
+		case pt, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if n := sub.Dropped(); n > dropped {
+				dropped = n
+				err := writeStream(conn, streamNotice{Dropped: n})
+				if err != nil {
+					return
+				}
+			}
+			v = pt
+		case n := <-notices:
+			v = n
+		case <-closed:
+			return
+		}
+		err := writeStream(conn, v)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeStream writes v to the connection as JSON.
+func writeStream(conn *websocket.Conn, v interface{}) error {
+	conn.SetWriteDeadline(time.Now().Add(autofact.WriteWait))
+	err := conn.WriteJSON(v)
+	if err != nil {
+		log.Debug(
+			err.Error(),
+			zap.String("op", "write stream"),
+			zap.String("remote", conn.RemoteAddr().String()),
+		)
+	}
+	return err
+}
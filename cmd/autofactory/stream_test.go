@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mohae/autofact/message"
+)
+
+func TestFilterMatch(t *testing.T) {
+	res := map[string]string{"client": "c1", "region": "east"}
+	tests := []struct {
+		f     filter
+		k     message.Kind
+		match bool
+	}{
+		{filter{}, message.LoadAvg, true},
+		{filter{Clients: []string{"c2", "c1"}}, message.LoadAvg, true},
+		{filter{Clients: []string{"c2"}}, message.LoadAvg, false},
+		{filter{Kinds: []string{"loadavg"}}, message.LoadAvg, true},
+		{filter{Kinds: []string{"MemInfo"}}, message.LoadAvg, false},
+		{filter{Tags: map[string]string{"region": "east"}}, message.LoadAvg, true},
+		{filter{Tags: map[string]string{"region": "west"}}, message.LoadAvg, false},
+		{filter{Clients: []string{"c1"}, Kinds: []string{"LoadAvg"}, Tags: map[string]string{"region": "west"}}, message.LoadAvg, false},
+	}
+	for i, test := range tests {
+		if got := test.f.Match(test.k, res); got != test.match {
+			t.Errorf("%d: got %t; want %t", i, got, test.match)
+		}
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	f := filter{Kinds: []string{"LoadAvg", "CPUUtilization"}}
+	if err := f.Validate(); err != nil {
+		t.Errorf("data kinds: unexpected error: %s", err)
+	}
+	f = filter{Kinds: []string{"ClientConf"}}
+	if err := f.Validate(); err == nil {
+		t.Error("ClientConf: expected an error; got none")
+	}
+}
+
+func TestHubPublish(t *testing.T) {
+	h := newHub()
+	all := h.Subscribe(filter{}, 2)
+	east := h.Subscribe(filter{Tags: map[string]string{"region": "east"}}, 0)
+	mem := h.Subscribe(filter{Kinds: []string{"MemInfo"}}, 0)
+	if h.Len() != 3 {
+		t.Fatalf("got %d subscribers; want 3", h.Len())
+	}
+	if cap(east.C) != SubscriberBuffer {
+		t.Errorf("default buffer: got %d; want %d", cap(east.C), SubscriberBuffer)
+	}
+	s := h.Subscribe(filter{}, MaxSubscriberBuffer+1)
+	if cap(s.C) != MaxSubscriberBuffer {
+		t.Errorf("capped buffer: got %d; want %d", cap(s.C), MaxSubscriberBuffer)
+	}
+	h.Unsubscribe(s)
+	res := map[string]string{"client": "c1", "region": "east"}
+	if !h.Wants(message.LoadAvg, res) {
+		t.Error("LoadAvg: got not wanted; want wanted")
+	}
+	pts := []point{
+		{Client: "c1", Name: "loadavg", Resource: res},
+		{Client: "c2", Name: "loadavg", Resource: map[string]string{"client": "c2", "region": "west"}},
+		{Client: "c1", Name: "loadavg", Resource: res},
+	}
+	h.Publish(message.LoadAvg, pts)
+	// all's buffer holds 2 points: the third is dropped, not blocked on.
+	if len(all.C) != 2 || all.Dropped() != 1 {
+		t.Errorf("all: got %d points, %d dropped; want 2, 1 dropped", len(all.C), all.Dropped())
+	}
+	if len(east.C) != 2 || east.Dropped() != 0 {
+		t.Errorf("east: got %d points, %d dropped; want 2, 0 dropped", len(east.C), east.Dropped())
+	}
+	for len(east.C) > 0 {
+		if pt := <-east.C; pt.Resource["region"] != "east" {
+			t.Errorf("east: got a point from %q", pt.Resource["region"])
+		}
+	}
+	if len(mem.C) != 0 {
+		t.Errorf("mem: got %d points; want none", len(mem.C))
+	}
+	// a new filter applies to the points published after it is set.
+	h.SetFilter(mem, filter{Clients: []string{"c2"}})
+	h.Publish(message.LoadAvg, pts)
+	if len(mem.C) != 1 {
+		t.Errorf("mem, after SetFilter: got %d points; want 1", len(mem.C))
+	}
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	h := newHub()
+	s := h.Subscribe(filter{}, 1)
+	h.Unsubscribe(s)
+	if h.Len() != 0 {
+		t.Errorf("got %d subscribers; want 0", h.Len())
+	}
+	if _, ok := <-s.C; ok {
+		t.Error("got an open channel; want it closed")
+	}
+	// unsubscribing again, e.g. when the connection is closed after the
+	// subscription was ended, is a no-op.
+	h.Unsubscribe(s)
+	// nothing is sent to a removed subscriber.
+	h.Publish(message.LoadAvg, []point{{Client: "c1", Resource: map[string]string{"client": "c1"}}})
+	if s.Dropped() != 0 {
+		t.Errorf("got %d dropped; want 0", s.Dropped())
+	}
+	if h.Wants(message.LoadAvg, map[string]string{"client": "c1"}) {
+		t.Error("got wanted; want not wanted")
+	}
+}
@@ -0,0 +1,127 @@
+// Package command handles the commands that autofactory sends to its clients
+// and the clients' replies.  Both are flatbuffer serialized and are sent as
+// the data of a message.Message: commands as message.Command and replies as
+// message.CommandReply.  A reply has the ID of the command it is for.
+package command
+
+//go:generate stringer -type=Op
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/flatbuffers/go"
+)
+
+// Op is the operation a command requests of a client.
+type Op int16
+
+const (
+	Unknown        Op = iota
+	CollectNow        // send a snapshot of the collected data now
+	ResendSysInfo     // send the system information
+	SetLogLevel       // change the log level to Arg
+	Reconnect         // close the connection and reconnect
+	StopCollectors    // stop collecting data; the healthbeat is still answered
+	Burst             // collect data at a higher frequency for a while; see BurstArg
+)
+
+// Burst limits.
+var (
+	// MinBurstPeriod is the shortest collection period a burst can use.
+	MinBurstPeriod = 100 * time.Millisecond
+	// MaxBurstDuration is the longest a burst can last.
+	MaxBurstDuration = time.Hour
+)
+
+// Int16 is a convenience method that returns the Op as an int16 value.
+func (o Op) Int16() int16 {
+	return int16(o)
+}
+
+// OpFromString returns the Op for a given string, or Unknown for anything
+// that does not match.  The comparison is case insensitive.
+func OpFromString(s string) Op {
+	for o := Op(0); int(o) < len(_Op_index)-1; o++ {
+		if strings.EqualFold(s, o.String()) {
+			return o
+		}
+	}
+	return Unknown
+}
+
+// BurstArg returns the Arg of a Burst command that collects data every
+// period for the duration d, e.g. "1s,10m".
+func BurstArg(period, d time.Duration) string {
+	return period.String() + "," + d.String()
+}
+
+// ParseBurst parses a Burst command's Arg and returns its period and
+// duration.  An error is returned if either is outside of the burst limits.
+func ParseBurst(arg string) (period, d time.Duration, err error) {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("burst: invalid arg %q: expected period,duration", arg)
+	}
+	period, err = time.ParseDuration(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("burst: period: %s", err)
+	}
+	d, err = time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("burst: duration: %s", err)
+	}
+	if period < MinBurstPeriod {
+		return 0, 0, fmt.Errorf("burst: period must be at least %s", MinBurstPeriod)
+	}
+	if d <= 0 || d > MaxBurstDuration {
+		return 0, 0, fmt.Errorf("burst: duration must be > 0 and at most %s", MaxBurstDuration)
+	}
+	return period, d, nil
+}
+
+// Serialize creates a flatbuffer serialized Command and returns the bytes.
+func Serialize(id []byte, o Op, arg string) []byte {
+	bldr := flatbuffers.NewBuilder(0)
+	i := bldr.CreateByteVector(id)
+	a := bldr.CreateString(arg)
+	CommandStart(bldr)
+	CommandAddID(bldr, i)
+	CommandAddOp(bldr, o.Int16())
+	CommandAddArg(bldr, a)
+	bldr.Finish(CommandEnd(bldr))
+	return bldr.Bytes[bldr.Head():]
+}
+
+// SerializeReply creates a flatbuffer serialized Reply to the command with
+// the id and returns the bytes.  A nil err is a success.
+func SerializeReply(id []byte, err error) []byte {
+	bldr := flatbuffers.NewBuilder(0)
+	i := bldr.CreateByteVector(id)
+	var e flatbuffers.UOffsetT
+	if err != nil {
+		e = bldr.CreateString(err.Error())
+	}
+	ReplyStart(bldr)
+	ReplyAddID(bldr, i)
+	if err == nil {
+		ReplyAddOK(bldr, 1)
+	} else {
+		ReplyAddError(bldr, e)
+	}
+	bldr.Finish(ReplyEnd(bldr))
+	return bldr.Bytes[bldr.Head():]
+}
+
+// Err returns the reply's error; nil if the command succeeded.
+func (rcv *Reply) Err() error {
+	if rcv.OK() != 0 {
+		return nil
+	}
+	if len(rcv.Error()) == 0 {
+		return errors.New("command failed")
+	}
+	return errors.New(string(rcv.Error()))
+}
@@ -0,0 +1,90 @@
+package command
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSerialize(t *testing.T) {
+	p := Serialize([]byte("abc"), SetLogLevel, "debug")
+	c := GetRootAsCommand(p, 0)
+	if string(c.IDBytes()) != "abc" {
+		t.Errorf("id: got %q; want %q", c.IDBytes(), "abc")
+	}
+	if Op(c.Op()) != SetLogLevel {
+		t.Errorf("op: got %s; want %s", Op(c.Op()), SetLogLevel)
+	}
+	if string(c.Arg()) != "debug" {
+		t.Errorf("arg: got %q; want %q", c.Arg(), "debug")
+	}
+}
+
+func TestSerializeReply(t *testing.T) {
+	r := GetRootAsReply(SerializeReply([]byte("abc"), nil), 0)
+	if string(r.IDBytes()) != "abc" {
+		t.Errorf("id: got %q; want %q", r.IDBytes(), "abc")
+	}
+	if r.Err() != nil {
+		t.Errorf("got %s; want nil", r.Err())
+	}
+	r = GetRootAsReply(SerializeReply([]byte("abc"), errors.New("failed")), 0)
+	if r.Err() == nil || r.Err().Error() != "failed" {
+		t.Errorf("got %v; want failed", r.Err())
+	}
+}
+
+func TestOpFromString(t *testing.T) {
+	tests := []struct {
+		s  string
+		op Op
+	}{
+		{"CollectNow", CollectNow},
+		{"resendsysinfo", ResendSysInfo},
+		{"SETLOGLEVEL", SetLogLevel},
+		{"Reconnect", Reconnect},
+		{"StopCollectors", StopCollectors},
+		{"burst", Burst},
+		{"reboot", Unknown},
+	}
+	for _, test := range tests {
+		if op := OpFromString(test.s); op != test.op {
+			t.Errorf("%s: got %s; want %s", test.s, op, test.op)
+		}
+	}
+}
+
+func TestParseBurst(t *testing.T) {
+	tests := []struct {
+		arg    string
+		period time.Duration
+		d      time.Duration
+		err    bool
+	}{
+		{"1s,10m", time.Second, 10 * time.Minute, false},
+		{"500ms, 1m", 500 * time.Millisecond, time.Minute, false},
+		{BurstArg(2*time.Second, 5*time.Minute), 2 * time.Second, 5 * time.Minute, false},
+		{"1s", 0, 0, true},
+		{"1x,10m", 0, 0, true},
+		{"1s,10x", 0, 0, true},
+		{"10ms,10m", 0, 0, true},
+		{"1s,0s", 0, 0, true},
+		{"1s,2h", 0, 0, true},
+	}
+	for _, test := range tests {
+		period, d, err := ParseBurst(test.arg)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.arg, err)
+			continue
+		}
+		if period != test.period || d != test.d {
+			t.Errorf("%s: got %s, %s; want %s, %s", test.arg, period, d, test.period, test.d)
+		}
+	}
+}
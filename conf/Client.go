@@ -110,7 +110,40 @@ func (rcv *Client) CPUUtilizationPeriod() int64 {
 	return 0
 }
 
-func ClientStart(builder *flatbuffers.Builder) { builder.StartObject(9) }
+func (rcv *Client) HealthbeatPush() byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(22))
+	if o != 0 {
+		return rcv._tab.GetByte(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *Client) Role() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(24))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+func (rcv *Client) Labels(j int) []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(26))
+	if o != 0 {
+		a := rcv._tab.Vector(o)
+		return rcv._tab.ByteVector(a + flatbuffers.UOffsetT(j * 4))
+	}
+	return nil
+}
+
+func (rcv *Client) LabelsLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(26))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+func ClientStart(builder *flatbuffers.Builder) { builder.StartObject(12) }
 func ClientAddID(builder *flatbuffers.Builder, ID flatbuffers.UOffsetT) { builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(ID), 0) }
 func ClientStartIDVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT { return builder.StartVector(1, numElems, 1)
 }
@@ -122,4 +155,9 @@ func ClientAddHealthbeatPeriod(builder *flatbuffers.Builder, HealthbeatPeriod in
 func ClientAddMemInfoPeriod(builder *flatbuffers.Builder, MemInfoPeriod int64) { builder.PrependInt64Slot(6, MemInfoPeriod, 0) }
 func ClientAddNetUsagePeriod(builder *flatbuffers.Builder, NetUsagePeriod int64) { builder.PrependInt64Slot(7, NetUsagePeriod, 0) }
 func ClientAddCPUUtilizationPeriod(builder *flatbuffers.Builder, CPUUtilizationPeriod int64) { builder.PrependInt64Slot(8, CPUUtilizationPeriod, 0) }
+func ClientAddHealthbeatPush(builder *flatbuffers.Builder, HealthbeatPush byte) { builder.PrependByteSlot(9, HealthbeatPush, 0) }
+func ClientAddRole(builder *flatbuffers.Builder, Role flatbuffers.UOffsetT) { builder.PrependUOffsetTSlot(10, flatbuffers.UOffsetT(Role), 0) }
+func ClientAddLabels(builder *flatbuffers.Builder, Labels flatbuffers.UOffsetT) { builder.PrependUOffsetTSlot(11, flatbuffers.UOffsetT(Labels), 0) }
+func ClientStartLabelsVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT { return builder.StartVector(4, numElems, 4)
+}
 func ClientEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT { return builder.EndObject() }
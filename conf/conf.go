@@ -5,9 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/google/flatbuffers/go"
 	"github.com/mohae/autofact/util"
@@ -51,14 +55,139 @@ func (c *Conf) Flag(s string) *flag.Flag {
 // Conn holds the connection information for a node.  This is all that is
 // persisted on a client node.
 type Conn struct {
-	ID              []byte        `json:"id"`
-	ServerAddress   string        `json:"server_address"`
-	ServerPort      string        `json:"server_port"`
+	ID            []byte `json:"id"`
+	ServerAddress string `json:"server_address"`
+	ServerPort    string `json:"server_port"`
+	// Servers are the servers to connect to, in order of preference.  If
+	// there aren't any, ServerAddress and ServerPort are used.
+	Servers         []Server      `json:"servers,omitempty"`
 	ServerID        uint32        `json:"server_id"`
 	ConnectInterval util.Duration `json:"connect_interval"`
 	ConnectPeriod   util.Duration `json:"connect_period"`
-	Filename        string        `json:"-"`
-	Conf            `json:"-"`
+	// FailbackInterval is how often a node that isn't connected to its
+	// preferred server checks whether it can fail back to it; 0 disables
+	// failback.
+	FailbackInterval util.Duration `json:"failback_interval"`
+	// The node's location and role, and any other labels; they are sent to
+	// the server, which tags the node's data with them.
+	Region     string            `json:"region,omitempty"`
+	Zone       string            `json:"zone,omitempty"`
+	DataCenter string            `json:"datacenter,omitempty"`
+	Role       string            `json:"role,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Filename   string            `json:"-"`
+	Conf       `json:"-"`
+}
+
+// The names of the labels that are Client fields.
+const (
+	LabelRegion     = "region"
+	LabelZone       = "zone"
+	LabelDataCenter = "datacenter"
+	LabelRole       = "role"
+)
+
+// Limits on a client's hostname and labels.  They are reported by the client
+// and end up in the tags of its data and in alert notifications.
+const (
+	MaxHostnameLen   = 255
+	MaxLabels        = 32
+	MaxLabelKeyLen   = 64
+	MaxLabelValueLen = 256
+)
+
+// ValidateHostname checks that the hostname isn't too long and doesn't have
+// control characters.
+func ValidateHostname(s string) error {
+	if len(s) > MaxHostnameLen {
+		return fmt.Errorf("hostname is longer than %d bytes", MaxHostnameLen)
+	}
+	if hasControl(s) {
+		return fmt.Errorf("hostname %q has control characters", s)
+	}
+	return nil
+}
+
+// ValidateLabels checks that there aren't more than MaxLabels labels, that
+// each key is non-empty, doesn't have an =, and isn't longer than
+// MaxLabelKeyLen, that each value isn't longer than MaxLabelValueLen, and that
+// neither has control characters.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return fmt.Errorf("%d labels: no more than %d are allowed", len(labels), MaxLabels)
+	}
+	for k, v := range labels {
+		switch {
+		case k == "":
+			return fmt.Errorf("label with an empty key")
+		case strings.Contains(k, "="):
+			return fmt.Errorf("label %q: key has an =", k)
+		case len(k) > MaxLabelKeyLen:
+			return fmt.Errorf("label %q: key is longer than %d bytes", k, MaxLabelKeyLen)
+		case len(v) > MaxLabelValueLen:
+			return fmt.Errorf("label %q: value is longer than %d bytes", k, MaxLabelValueLen)
+		case hasControl(k) || hasControl(v):
+			return fmt.Errorf("label %q: has control characters", k)
+		}
+	}
+	return nil
+}
+
+// hasControl returns whether s has any control characters.
+func hasControl(s string) bool {
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllLabels returns the node's labels, including its location and role.  The
+// location and role take precedence over labels with the same name.
+func (c *Conn) AllLabels() map[string]string {
+	labels := make(map[string]string, len(c.Labels)+4)
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+	for k, v := range map[string]string{LabelRegion: c.Region, LabelZone: c.Zone, LabelDataCenter: c.DataCenter, LabelRole: c.Role} {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// Server is a server's address.
+type Server struct {
+	Address string `json:"address"`
+	Port    string `json:"port"`
+}
+
+// String returns the server's address as host:port.
+func (s Server) String() string {
+	return net.JoinHostPort(s.Address, s.Port)
+}
+
+// ParseServers parses a comma separated list of host:port server addresses.
+func ParseServers(s string) ([]Server, error) {
+	var servers []Server
+	for _, v := range strings.Split(s, ",") {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid server address %q: %s", v, err)
+		}
+		servers = append(servers, Server{Address: host, Port: port})
+	}
+	return servers, nil
+}
+
+// Endpoints returns the servers to connect to, in order of preference.
+func (c *Conn) Endpoints() []Server {
+	if len(c.Servers) > 0 {
+		return c.Servers
+	}
+	return []Server{{Address: c.ServerAddress, Port: c.ServerPort}}
 }
 
 // LoadConn loads the config file.  The Conn's filename is set during this
@@ -99,14 +228,117 @@ func (c *Conn) Save() error {
 
 // Serialize serializes the Client conf.
 func (c *Client) Serialize() []byte {
+	return c.Info().Serialize()
+}
+
+// Info returns the Client's information.
+func (c *Client) Info() ClientInfo {
+	info := ClientInfo{
+		ID:                   c.IDBytes(),
+		Hostname:             string(c.Hostname()),
+		Region:               string(c.Region()),
+		Zone:                 string(c.Zone()),
+		DataCenter:           string(c.DataCenter()),
+		Role:                 string(c.Role()),
+		HealthbeatPeriod:     c.HealthbeatPeriod(),
+		MemInfoPeriod:        c.MemInfoPeriod(),
+		NetUsagePeriod:       c.NetUsagePeriod(),
+		CPUUtilizationPeriod: c.CPUUtilizationPeriod(),
+		HealthbeatPush:       util.ByteToBool(c.HealthbeatPush()),
+	}
+	for i := 0; i < c.LabelsLength(); i++ {
+		kv := strings.SplitN(string(c.Labels(i)), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if info.Labels == nil {
+			info.Labels = make(map[string]string)
+		}
+		info.Labels[kv[0]] = kv[1]
+	}
+	return info
+}
+
+// ClientInfo is the information in a Client conf.  Labels are the client's
+// labels other than its location and role.
+type ClientInfo struct {
+	ID                   []byte
+	Hostname             string
+	Region               string
+	Zone                 string
+	DataCenter           string
+	Role                 string
+	Labels               map[string]string
+	HealthbeatPeriod     int64
+	MemInfoPeriod        int64
+	NetUsagePeriod       int64
+	CPUUtilizationPeriod int64
+	HealthbeatPush       bool
+}
+
+// SetLabels sets the client's labels: the location and role labels are set
+// as their fields, the rest as Labels.  If the labels aren't valid, see
+// ValidateLabels, an error is returned and the client's labels are unchanged.
+func (c *ClientInfo) SetLabels(labels map[string]string) error {
+	err := ValidateLabels(labels)
+	if err != nil {
+		return err
+	}
+	c.Region = labels[LabelRegion]
+	c.Zone = labels[LabelZone]
+	c.DataCenter = labels[LabelDataCenter]
+	c.Role = labels[LabelRole]
+	c.Labels = nil
+	for k, v := range labels {
+		switch k {
+		case LabelRegion, LabelZone, LabelDataCenter, LabelRole:
+			continue
+		}
+		if c.Labels == nil {
+			c.Labels = make(map[string]string)
+		}
+		c.Labels[k] = v
+	}
+	return nil
+}
+
+// Serialize serializes the information as a Client conf.  Labels are
+// serialized as key=value, sorted by key.
+func (c *ClientInfo) Serialize() []byte {
 	bldr := flatbuffers.NewBuilder(0)
-	id := bldr.CreateByteVector(c.IDBytes())
+	id := bldr.CreateByteVector(c.ID)
+	h := bldr.CreateString(c.Hostname)
+	r := bldr.CreateString(c.Region)
+	z := bldr.CreateString(c.Zone)
+	d := bldr.CreateString(c.DataCenter)
+	role := bldr.CreateString(c.Role)
+	keys := make([]string, 0, len(c.Labels))
+	for k := range c.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	labels := make([]flatbuffers.UOffsetT, len(keys))
+	for i, k := range keys {
+		labels[i] = bldr.CreateString(k + "=" + c.Labels[k])
+	}
+	ClientStartLabelsVector(bldr, len(labels))
+	for i := len(labels) - 1; i >= 0; i-- {
+		bldr.PrependUOffsetT(labels[i])
+	}
+	l := bldr.EndVector(len(labels))
 	ClientStart(bldr)
 	ClientAddID(bldr, id)
-	ClientAddHealthbeatPeriod(bldr, c.HealthbeatPeriod())
-	ClientAddMemInfoPeriod(bldr, c.MemInfoPeriod())
-	ClientAddCPUUtilizationPeriod(bldr, c.CPUUtilizationPeriod())
-	ClientAddNetUsagePeriod(bldr, c.NetUsagePeriod())
+	ClientAddHostname(bldr, h)
+	ClientAddRegion(bldr, r)
+	ClientAddZone(bldr, z)
+	ClientAddDataCenter(bldr, d)
+	ClientAddRole(bldr, role)
+	ClientAddLabels(bldr, l)
+	ClientAddHealthbeatPeriod(bldr, c.HealthbeatPeriod)
+	ClientAddMemInfoPeriod(bldr, c.MemInfoPeriod)
+	ClientAddNetUsagePeriod(bldr, c.NetUsagePeriod)
+	ClientAddCPUUtilizationPeriod(bldr, c.CPUUtilizationPeriod)
+	ClientAddHealthbeatPush(bldr, util.BoolToByte(c.HealthbeatPush))
 	bldr.Finish(ClientEnd(bldr))
 	return bldr.Bytes[bldr.Head():]
 }
@@ -2,6 +2,9 @@ package conf
 
 import (
 	"flag"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -56,3 +59,122 @@ func NewFlagSet() *flag.FlagSet {
 	f.String("biz", "Biz", "string flag")
 	return f
 }
+
+func TestParseServers(t *testing.T) {
+	tests := []struct {
+		s       string
+		servers []Server
+		err     bool
+	}{
+		{"127.0.0.1:8675", []Server{{"127.0.0.1", "8675"}}, false},
+		{"a.example.com:8675, b.example.com:8676", []Server{{"a.example.com", "8675"}, {"b.example.com", "8676"}}, false},
+		{"[::1]:8675", []Server{{"::1", "8675"}}, false},
+		{"a.example.com", nil, true},
+		{"a.example.com:8675,", nil, true},
+	}
+	for _, test := range tests {
+		servers, err := ParseServers(test.s)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error; got none", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(servers, test.servers) {
+			t.Errorf("%q: got %v; want %v", test.s, servers, test.servers)
+		}
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	c := Conn{ServerAddress: "127.0.0.1", ServerPort: "8675"}
+	want := []Server{{"127.0.0.1", "8675"}}
+	if got := c.Endpoints(); !reflect.DeepEqual(got, want) {
+		t.Errorf("no servers: got %v; want %v", got, want)
+	}
+	c.Servers = []Server{{"a.example.com", "8675"}, {"b.example.com", "8675"}}
+	if got := c.Endpoints(); !reflect.DeepEqual(got, c.Servers) {
+		t.Errorf("servers: got %v; want %v", got, c.Servers)
+	}
+}
+
+func TestClientInfo(t *testing.T) {
+	info := ClientInfo{
+		ID:               []byte("abc"),
+		Hostname:         "web1",
+		HealthbeatPeriod: 1000,
+		MemInfoPeriod:    5000,
+		HealthbeatPush:   true,
+	}
+	err := info.SetLabels(map[string]string{"region": "us-east", "zone": "a", "datacenter": "dc1", "role": "web", "team": "ops", "tier": "1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if info.Region != "us-east" || info.Zone != "a" || info.DataCenter != "dc1" || info.Role != "web" {
+		t.Errorf("got %+v; want the location and role labels as fields", info)
+	}
+	want := map[string]string{"team": "ops", "tier": "1"}
+	if !reflect.DeepEqual(info.Labels, want) {
+		t.Errorf("labels: got %v; want %v", info.Labels, want)
+	}
+	got := GetRootAsClient(info.Serialize(), 0).Info()
+	if !reflect.DeepEqual(got, info) {
+		t.Errorf("got %+v; want %+v", got, info)
+	}
+}
+
+func TestAllLabels(t *testing.T) {
+	c := Conn{Region: "us-east", Role: "web", Labels: map[string]string{"team": "ops", "region": "ignored"}}
+	want := map[string]string{"region": "us-east", "role": "web", "team": "ops"}
+	if got := c.AllLabels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	many := make(map[string]string)
+	for i := 0; i <= MaxLabels; i++ {
+		many[fmt.Sprintf("k%d", i)] = "v"
+	}
+	tests := []struct {
+		labels map[string]string
+		ok     bool
+	}{
+		{nil, true},
+		{map[string]string{"team": "ops", "url": "a=b"}, true},
+		{map[string]string{"": "ops"}, false},
+		{map[string]string{"team=x": "ops"}, false},
+		{map[string]string{strings.Repeat("k", MaxLabelKeyLen+1): "ops"}, false},
+		{map[string]string{"team": strings.Repeat("v", MaxLabelValueLen+1)}, false},
+		{map[string]string{"team": "ops\r\nBcc: x"}, false},
+		{map[string]string{"te\x00am": "ops"}, false},
+		{many, false},
+	}
+	for i, test := range tests {
+		err := ValidateLabels(test.labels)
+		if (err == nil) != test.ok {
+			t.Errorf("%d: got %v; want ok %t", i, err, test.ok)
+		}
+	}
+	// invalid labels don't change the client's labels.
+	info := ClientInfo{Region: "us-east", Labels: map[string]string{"team": "ops"}}
+	err := info.SetLabels(map[string]string{"region": "us-west", "bad=key": "x"})
+	if err == nil {
+		t.Error("SetLabels: expected an error; got none")
+	}
+	if info.Region != "us-east" || !reflect.DeepEqual(info.Labels, map[string]string{"team": "ops"}) {
+		t.Errorf("SetLabels: got %+v; want the labels unchanged", info)
+	}
+	if err := ValidateHostname("web01.example.com"); err != nil {
+		t.Errorf("hostname: unexpected error: %s", err)
+	}
+	for _, v := range []string{"web\n01", strings.Repeat("h", MaxHostnameLen+1)} {
+		if err := ValidateHostname(v); err == nil {
+			t.Errorf("hostname %q: expected an error; got none", v)
+		}
+	}
+}
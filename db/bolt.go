@@ -3,7 +3,7 @@ package db
 import (
 	"errors"
 	"fmt"
-	"os"
+	"strings"
 
 	"github.com/boltdb/bolt"
 	"github.com/mohae/autofact/conf"
@@ -30,26 +30,14 @@ func (b *Bolt) Open(name string) error {
 	b.Filename = name
 	fmt.Println(name)
 	fmt.Println(b.Filename)
-	var notExist bool
-	// See if the file exists.  If it does not exist, the buckets will need to
-	// be created.
-	// TODO: what about handling new buckets?  Should we just create if not
-	// exist on DB open?
-	_, err := os.Stat(name)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return Error{"check database file", err}
-		}
-		notExist = true
-	}
+	var err error
 	b.DB, err = bolt.Open(name, 0600, nil)
 	if err != nil {
 		return Error{"open database", err}
 	}
-	if notExist {
-		return b.CreateBuckets()
-	}
-	return nil
+	// The buckets are always created, if they don't exist, so that databases
+	// created by older versions get any buckets that have since been added.
+	return b.CreateBuckets()
 }
 
 // Close the database if it's open.
@@ -138,3 +126,102 @@ func (b *Bolt) GetClient(id []byte) (c *conf.Client, err error) {
 	})
 	return c, err
 }
+
+// Alerts returns all of the saved alerts, keyed by alert ID.
+func (b *Bolt) Alerts() (map[string][]byte, error) {
+	return b.all(Alert)
+}
+
+// SaveAlert saves an alert in the alert bucket.
+func (b *Bolt) SaveAlert(id string, p []byte) error {
+	return b.put(Alert, id, p)
+}
+
+// DeleteAlert deletes an alert from the alert bucket.
+func (b *Bolt) DeleteAlert(id string) error {
+	return b.delete(Alert, id)
+}
+
+// Silences returns all of the saved silences, keyed by silence ID.
+func (b *Bolt) Silences() (map[string][]byte, error) {
+	return b.all(Silence)
+}
+
+// SaveSilence saves a silence in the silence bucket.
+func (b *Bolt) SaveSilence(id string, p []byte) error {
+	return b.put(Silence, id, p)
+}
+
+// DeleteSilence deletes a silence from the silence bucket.
+func (b *Bolt) DeleteSilence(id string) error {
+	return b.delete(Silence, id)
+}
+
+// MaintenanceWindows returns all of the saved maintenance windows, keyed by
+// window ID.
+func (b *Bolt) MaintenanceWindows() (map[string][]byte, error) {
+	return b.all(Maintenance)
+}
+
+// SaveMaintenanceWindow saves a maintenance window in the maintenance bucket.
+func (b *Bolt) SaveMaintenanceWindow(id string, p []byte) error {
+	return b.put(Maintenance, id, p)
+}
+
+// DeleteMaintenanceWindow deletes a maintenance window from the maintenance
+// bucket.
+func (b *Bolt) DeleteMaintenanceWindow(id string) error {
+	return b.delete(Maintenance, id)
+}
+
+// Fingerprints returns all of the saved host fingerprints; each is the ID of
+// the client on the fingerprinted host, keyed by fingerprint.
+func (b *Bolt) Fingerprints() (map[string][]byte, error) {
+	return b.all(Fingerprint)
+}
+
+// SaveFingerprint saves the ID of the client on the fingerprinted host.
+func (b *Bolt) SaveFingerprint(fp string, id []byte) error {
+	return b.put(Fingerprint, fp, id)
+}
+
+// all returns all of the key/value pairs in the bucket.
+func (b *Bolt) all(bkt Bucket) (map[string][]byte, error) {
+	m := make(map[string][]byte)
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bkt.String()))
+		if b == nil {
+			return Error{fmt.Sprintf("get %s bucket", bkt), errors.New("does not exist")}
+		}
+		return b.ForEach(func(k, v []byte) error {
+			// bolt values are only valid during the transaction
+			m[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	})
+	return m, err
+}
+
+// put saves the value in the bucket.
+func (b *Bolt) put(bkt Bucket, k string, v []byte) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bkt.String()))
+		err := b.Put([]byte(k), v)
+		if err != nil {
+			return Error{fmt.Sprintf("save %s %s", strings.ToLower(bkt.String()), k), err}
+		}
+		return nil
+	})
+}
+
+// delete deletes the key from the bucket.
+func (b *Bolt) delete(bkt Bucket, k string) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bkt.String()))
+		err := b.Delete([]byte(k))
+		if err != nil {
+			return Error{fmt.Sprintf("delete %s %s", strings.ToLower(bkt.String()), k), err}
+		}
+		return nil
+	})
+}
@@ -77,4 +77,28 @@ func TestBoltDB(t *testing.T) {
 			return
 		}
 	}
+	// alerts
+	err = db.SaveAlert("a", []byte("1"))
+	if err != nil {
+		t.Errorf("save alert: expected no error; got %s", err)
+		return
+	}
+	err = db.SaveAlert("b", []byte("2"))
+	if err != nil {
+		t.Errorf("save alert: expected no error; got %s", err)
+		return
+	}
+	err = db.DeleteAlert("a")
+	if err != nil {
+		t.Errorf("delete alert: expected no error; got %s", err)
+		return
+	}
+	alerts, err := db.Alerts()
+	if err != nil {
+		t.Errorf("alerts: expected no error; got %s", err)
+		return
+	}
+	if len(alerts) != 1 || string(alerts["b"]) != "2" {
+		t.Errorf("alerts: expected map[b:2]; got %s", alerts)
+	}
 }
@@ -14,10 +14,14 @@ const (
 	Group
 	Cluster
 	Datacenter
+	Alert
+	Silence
+	Maintenance
+	Fingerprint
 )
 
 // Buckets is a slice of top level buckets for the database.
-var Buckets = []Bucket{Invalid, Client, Role, Group, Cluster, Datacenter}
+var Buckets = []Bucket{Invalid, Client, Role, Group, Cluster, Datacenter, Alert, Silence, Maintenance, Fingerprint}
 
 // BucketFromString returns the Bucket for a given string, or Invalid for
 // anything that does not match.  All input strings are normalized to lower.
@@ -34,6 +38,14 @@ func BucketFromString(s string) Bucket {
 		return Cluster
 	case "datacenter":
 		return Datacenter
+	case "alert":
+		return Alert
+	case "silence":
+		return Silence
+	case "maintenance":
+		return Maintenance
+	case "fingerprint":
+		return Fingerprint
 	default:
 		return Invalid
 	}
@@ -4,9 +4,9 @@ package db
 
 import "fmt"
 
-const _Bucket_name = "InvalidClientRoleGroupClusterDatacenter"
+const _Bucket_name = "InvalidClientRoleGroupClusterDatacenterAlertSilenceMaintenanceFingerprint"
 
-var _Bucket_index = [...]uint8{0, 7, 13, 17, 22, 29, 39}
+var _Bucket_index = [...]uint8{0, 7, 13, 17, 22, 29, 39, 44, 51, 62, 73}
 
 func (i Bucket) String() string {
 	if i < 0 || i >= Bucket(len(_Bucket_index)-1) {
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Value is a single value of an aligned series.
+type Value struct {
+	Time  int64 // nanoseconds
+	Value float64
+}
+
+// Aggregator reduces the aggregates within a step to a single value.  The
+// aggregates are in time order and there is at least one.
+type Aggregator func([]Aggregate) float64
+
+// Aggregators are the supported aggregation functions, by name.
+var Aggregators = map[string]Aggregator{
+	"avg":  Avg,
+	"min":  Min,
+	"max":  Max,
+	"last": Last,
+	"p95":  P95,
+}
+
+// AggregatorFromString returns the Aggregator for s and whether it exists.
+// All input is normalized to lowercase.
+func AggregatorFromString(s string) (Aggregator, bool) {
+	fn, ok := Aggregators[strings.ToLower(s)]
+	return fn, ok
+}
+
+// Avg returns the average of all the samples in the aggregates.
+func Avg(aggs []Aggregate) float64 {
+	var sum, n float64
+	for _, a := range aggs {
+		sum += a.Sum
+		n += a.Count
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / n
+}
+
+// Min returns the lowest value.
+func Min(aggs []Aggregate) float64 {
+	v := aggs[0].Min
+	for _, a := range aggs[1:] {
+		if a.Min < v {
+			v = a.Min
+		}
+	}
+	return v
+}
+
+// Max returns the highest value.
+func Max(aggs []Aggregate) float64 {
+	v := aggs[0].Max
+	for _, a := range aggs[1:] {
+		if a.Max > v {
+			v = a.Max
+		}
+	}
+	return v
+}
+
+// Last returns the most recent value.
+func Last(aggs []Aggregate) float64 {
+	return aggs[len(aggs)-1].Last
+}
+
+// P95 returns the 95th percentile, using the nearest rank method.  Rollups
+// don't retain their individual samples so, for rollups, the percentile is
+// of the rollups' averages, weighted by their counts; for raw samples it is
+// exact.
+func P95(aggs []Aggregate) float64 {
+	vals := make(weighted, 0, len(aggs))
+	var n float64
+	for _, a := range aggs {
+		vals = append(vals, weightedValue{a.Avg(), a.Count})
+		n += a.Count
+	}
+	sort.Sort(vals)
+	rank := math.Ceil(0.95 * n)
+	var seen float64
+	for _, v := range vals {
+		seen += v.n
+		if seen >= rank {
+			return v.v
+		}
+	}
+	return vals[len(vals)-1].v
+}
+
+type weightedValue struct {
+	v float64
+	n float64
+}
+
+type weighted []weightedValue
+
+func (w weighted) Len() int           { return len(w) }
+func (w weighted) Less(i, j int) bool { return w[i].v < w[j].v }
+func (w weighted) Swap(i, j int)      { w[i], w[j] = w[j], w[i] }
+
+// Align groups the aggregates into step wide buckets, starting with the step
+// that start is in, and reduces each bucket using fn.  The aggregates must be
+// in time order, e.g. as returned by Store.Read.  Buckets without data are
+// omitted.  The Value's time is the start of its bucket.
+func Align(aggs []Aggregate, start, end, step int64, fn Aggregator) []Value {
+	if step <= 0 || len(aggs) == 0 {
+		return nil
+	}
+	start -= start % step
+	var vals []Value
+	i := 0
+	for t := start; t <= end && i < len(aggs); t += step {
+		// skip anything before this bucket
+		for i < len(aggs) && aggs[i].Time < t {
+			i++
+		}
+		j := i
+		for j < len(aggs) && aggs[j].Time < t+step && aggs[j].Time <= end {
+			j++
+		}
+		if j > i {
+			vals = append(vals, Value{Time: t, Value: fn(aggs[i:j])})
+		}
+		i = j
+	}
+	return vals
+}
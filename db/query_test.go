@@ -0,0 +1,63 @@
+package db
+
+import "testing"
+
+func TestAlign(t *testing.T) {
+	// ten raw samples, one per unit, with the values 1-10
+	var aggs []Aggregate
+	for i := int64(0); i < 10; i++ {
+		v := float64(i + 1)
+		aggs = append(aggs, Aggregate{Time: i, Count: 1, Sum: v, Min: v, Max: v, Last: v})
+	}
+	tests := []struct {
+		name     string
+		start    int64
+		end      int64
+		step     int64
+		expected []Value
+	}{
+		{"avg", 0, 9, 5, []Value{{0, 3}, {5, 8}}},
+		{"min", 0, 9, 5, []Value{{0, 1}, {5, 6}}},
+		{"max", 0, 9, 5, []Value{{0, 5}, {5, 10}}},
+		{"last", 0, 9, 5, []Value{{0, 5}, {5, 10}}},
+		{"p95", 0, 9, 10, []Value{{0, 10}}},
+		{"avg", 3, 6, 2, []Value{{2, 3.5}, {4, 5.5}, {6, 7}}},
+		{"avg", 0, 9, 0, nil},
+		{"avg", 20, 30, 5, nil},
+	}
+	for i, test := range tests {
+		fn, ok := AggregatorFromString(test.name)
+		if !ok {
+			t.Errorf("%d: %s: expected aggregator to exist", i, test.name)
+			continue
+		}
+		vals := Align(aggs, test.start, test.end, test.step, fn)
+		if len(vals) != len(test.expected) {
+			t.Errorf("%d: %s: got %v; want %v", i, test.name, vals, test.expected)
+			continue
+		}
+		for j, v := range vals {
+			if v != test.expected[j] {
+				t.Errorf("%d: %s: value %d: got %v; want %v", i, test.name, j, v, test.expected[j])
+			}
+		}
+	}
+	if _, ok := AggregatorFromString("median"); ok {
+		t.Error("median: expected aggregator to not exist")
+	}
+}
+
+func TestP95Rollups(t *testing.T) {
+	// 19 samples averaging 1 and 1 sample of 100: p95 is the 19th sample.
+	aggs := []Aggregate{
+		{Time: 0, Count: 19, Sum: 19, Min: 1, Max: 1, Last: 1},
+		{Time: 1, Count: 1, Sum: 100, Min: 100, Max: 100, Last: 100},
+	}
+	if v := P95(aggs); v != 1 {
+		t.Errorf("got %v; want 1", v)
+	}
+	aggs[0].Count, aggs[0].Sum = 18, 18
+	if v := P95(aggs); v != 100 {
+		t.Errorf("got %v; want 100", v)
+	}
+}
@@ -0,0 +1,407 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Resolution is the resolution of the samples in a Store.
+type Resolution int
+
+const (
+	Raw    Resolution = iota // samples as received
+	Minute                   // 1m rollups
+	Hour                     // 1h rollups
+)
+
+// Resolutions are the Store's resolutions, from finest to coarsest.  When a
+// partition expires, it is rolled up into the next resolution.
+var Resolutions = []Resolution{Raw, Minute, Hour}
+
+func (r Resolution) String() string {
+	switch r {
+	case Raw:
+		return "raw"
+	case Minute:
+		return "1m"
+	case Hour:
+		return "1h"
+	default:
+		return fmt.Sprintf("Resolution(%d)", int(r))
+	}
+}
+
+// Step returns the width of the resolution's rollup buckets.  Raw samples
+// aren't bucketed so its step is 0.
+func (r Resolution) Step() time.Duration {
+	switch r {
+	case Minute:
+		return time.Minute
+	case Hour:
+		return time.Hour
+	default:
+		return 0
+	}
+}
+
+// Store defaults.
+var (
+	DefaultPartition = map[Resolution]time.Duration{
+		Raw:    time.Hour,
+		Minute: 24 * time.Hour,
+		Hour:   7 * 24 * time.Hour,
+	}
+	DefaultRetention = map[Resolution]time.Duration{
+		Raw:    24 * time.Hour,
+		Minute: 7 * 24 * time.Hour,
+		Hour:   365 * 24 * time.Hour,
+	}
+)
+
+// Sample is a single value of a client's series.
+type Sample struct {
+	Client string
+	Series string
+	Time   int64 // nanoseconds
+	Value  float64
+}
+
+// Aggregate summarizes the samples within a rollup bucket.  Raw samples are
+// read as an Aggregate of one sample.
+type Aggregate struct {
+	Time  int64 // start of the bucket, in nanoseconds
+	Count float64
+	Sum   float64
+	Min   float64
+	Max   float64
+	Last  float64
+}
+
+// Avg returns the average of the aggregated samples.
+func (a Aggregate) Avg() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Sum / a.Count
+}
+
+// Merge adds b to the aggregate.  b is assumed to be later than a.
+func (a *Aggregate) Merge(b Aggregate) {
+	a.Count += b.Count
+	a.Sum += b.Sum
+	if b.Min < a.Min {
+		a.Min = b.Min
+	}
+	if b.Max > a.Max {
+		a.Max = b.Max
+	}
+	a.Last = b.Last
+}
+
+// Store is an embedded time-series store.  Samples are stored per client, per
+// series, in time-partitioned buckets: resolution/partition/client/series.
+// Partitions that are older than the resolution's retention are rolled up
+// into the next resolution and then deleted; expired partitions of the
+// coarsest resolution are deleted.
+type Store struct {
+	*bolt.DB
+	Filename string
+	// Partition is the time span of a partition, per resolution.  A partition
+	// must be a multiple of the next resolution's step.
+	Partition map[Resolution]time.Duration
+	// Retention is how long data is kept at each resolution.
+	Retention map[Resolution]time.Duration
+}
+
+// NewStore returns a Store with the default partitions and retentions.
+func NewStore() *Store {
+	s := &Store{
+		Partition: make(map[Resolution]time.Duration, len(Resolutions)),
+		Retention: make(map[Resolution]time.Duration, len(Resolutions)),
+	}
+	for _, r := range Resolutions {
+		s.Partition[r] = DefaultPartition[r]
+		s.Retention[r] = DefaultRetention[r]
+	}
+	return s
+}
+
+// Open opens the store's database, creating the resolution buckets if they
+// don't exist.
+func (s *Store) Open(name string) error {
+	for i, r := range Resolutions {
+		if s.Partition[r] <= 0 {
+			return Error{"open store", fmt.Errorf("%s: partition must be > 0", r)}
+		}
+		if i+1 < len(Resolutions) && s.Partition[r]%Resolutions[i+1].Step() != 0 {
+			return Error{"open store", fmt.Errorf("%s: partition %s is not a multiple of %s", r, s.Partition[r], Resolutions[i+1].Step())}
+		}
+	}
+	s.Filename = name
+	var err error
+	s.DB, err = bolt.Open(name, 0600, nil)
+	if err != nil {
+		return Error{"open store", err}
+	}
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		for _, r := range Resolutions {
+			_, err := tx.CreateBucketIfNotExists([]byte(r.String()))
+			if err != nil {
+				return Error{fmt.Sprintf("create bucket %s", r), err}
+			}
+		}
+		return nil
+	})
+}
+
+// Close the store if it's open.
+func (s *Store) Close() error {
+	if s.DB != nil {
+		return s.DB.Close()
+	}
+	return nil
+}
+
+// partitionKey returns the key of the partition that t falls in.
+func (s *Store) partitionKey(r Resolution, t int64) []byte {
+	p := int64(s.Partition[r])
+	return int64Key(t - t%p)
+}
+
+// Write saves the samples as raw data.
+func (s *Store) Write(samples []Sample) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(Raw.String()))
+		for _, v := range samples {
+			b, err := seriesBucket(root, s.partitionKey(Raw, v.Time), v.Client, v.Series)
+			if err != nil {
+				return Error{fmt.Sprintf("write %s %s", v.Client, v.Series), err}
+			}
+			err = b.Put(int64Key(v.Time), encodeValue(v.Value))
+			if err != nil {
+				return Error{fmt.Sprintf("write %s %s", v.Client, v.Series), err}
+			}
+		}
+		return nil
+	})
+}
+
+// Read returns the client's series between start and end, inclusive, ordered
+// by time.  Data is read from all resolutions; rollups are returned as their
+// bucket's Aggregate.
+func (s *Store) Read(client, series string, start, end int64) ([]Aggregate, error) {
+	var aggs aggregates
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		for _, r := range Resolutions {
+			root := tx.Bucket([]byte(r.String()))
+			c := root.Cursor()
+			for k, _ := c.Seek(s.partitionKey(r, start)); k != nil && keyInt64(k) <= end; k, _ = c.Next() {
+				b := root.Bucket(k).Bucket([]byte(client))
+				if b == nil {
+					continue
+				}
+				b = b.Bucket([]byte(series))
+				if b == nil {
+					continue
+				}
+				sc := b.Cursor()
+				for tk, v := sc.Seek(int64Key(start)); tk != nil && keyInt64(tk) <= end; tk, v = sc.Next() {
+					aggs = append(aggs, decodeAggregate(keyInt64(tk), v))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(aggs)
+	return aggs, nil
+}
+
+// Series returns the names of the series stored for the client, in sorted
+// order.
+func (s *Store) Series(client string) ([]string, error) {
+	names := make(map[string]struct{})
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		for _, r := range Resolutions {
+			root := tx.Bucket([]byte(r.String()))
+			err := root.ForEach(func(k, _ []byte) error {
+				b := root.Bucket(k).Bucket([]byte(client))
+				if b == nil {
+					return nil
+				}
+				return b.ForEach(func(name, _ []byte) error {
+					names[string(name)] = struct{}{}
+					return nil
+				})
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, len(names))
+	for k := range names {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// Enforce applies the retention policy as of now: expired partitions are
+// rolled up into the next resolution, if there is one, and deleted.
+func (s *Store) Enforce(now time.Time) error {
+	for i, r := range Resolutions {
+		cutoff := now.UnixNano() - int64(s.Retention[r])
+		err := s.DB.Update(func(tx *bolt.Tx) error {
+			root := tx.Bucket([]byte(r.String()))
+			var expired [][]byte
+			c := root.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				// partitions are in time order; stop at the first unexpired one
+				if keyInt64(k)+int64(s.Partition[r]) > cutoff {
+					break
+				}
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			for _, k := range expired {
+				if i+1 < len(Resolutions) {
+					err := s.rollup(tx, root.Bucket(k), Resolutions[i+1])
+					if err != nil {
+						return Error{fmt.Sprintf("rollup %s partition %d", r, keyInt64(k)), err}
+					}
+				}
+				err := root.DeleteBucket(k)
+				if err != nil {
+					return Error{fmt.Sprintf("delete %s partition %d", r, keyInt64(k)), err}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollup aggregates every series in the partition into the resolution's
+// buckets.  If a rollup bucket already exists, the data is merged into it.
+func (s *Store) rollup(tx *bolt.Tx, part *bolt.Bucket, to Resolution) error {
+	root := tx.Bucket([]byte(to.String()))
+	step := int64(to.Step())
+	return part.ForEach(func(client, _ []byte) error {
+		cb := part.Bucket(client)
+		return cb.ForEach(func(series, _ []byte) error {
+			var agg Aggregate
+			var ok bool
+			flush := func() error {
+				b, err := seriesBucket(root, s.partitionKey(to, agg.Time), string(client), string(series))
+				if err != nil {
+					return err
+				}
+				k := int64Key(agg.Time)
+				if v := b.Get(k); v != nil {
+					prev := decodeAggregate(agg.Time, v)
+					prev.Merge(agg)
+					agg = prev
+				}
+				return b.Put(k, encodeAggregate(agg))
+			}
+			c := cb.Bucket(series).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				a := decodeAggregate(keyInt64(k), v)
+				t := a.Time - a.Time%step
+				if ok && t != agg.Time {
+					err := flush()
+					if err != nil {
+						return err
+					}
+					ok = false
+				}
+				if !ok {
+					agg = a
+					agg.Time = t
+					ok = true
+					continue
+				}
+				agg.Merge(a)
+			}
+			if ok {
+				return flush()
+			}
+			return nil
+		})
+	})
+}
+
+// seriesBucket returns the bucket for the series, creating the partition,
+// client, and series buckets as needed.
+func seriesBucket(root *bolt.Bucket, partition []byte, client, series string) (*bolt.Bucket, error) {
+	b, err := root.CreateBucketIfNotExists(partition)
+	if err != nil {
+		return nil, err
+	}
+	b, err = b.CreateBucketIfNotExists([]byte(client))
+	if err != nil {
+		return nil, err
+	}
+	return b.CreateBucketIfNotExists([]byte(series))
+}
+
+// int64Key returns v as a big-endian key so that keys sort by time.
+func int64Key(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func keyInt64(k []byte) int64 {
+	return int64(binary.BigEndian.Uint64(k))
+}
+
+// Raw values are stored as a single float64; aggregates as count, sum, min,
+// max, and last.
+func encodeValue(v float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(v))
+	return b
+}
+
+func encodeAggregate(a Aggregate) []byte {
+	b := make([]byte, 40)
+	for i, v := range []float64{a.Count, a.Sum, a.Min, a.Max, a.Last} {
+		binary.BigEndian.PutUint64(b[i*8:], math.Float64bits(v))
+	}
+	return b
+}
+
+// decodeAggregate decodes either a raw value or an aggregate.
+func decodeAggregate(t int64, b []byte) Aggregate {
+	if len(b) == 8 {
+		v := math.Float64frombits(binary.BigEndian.Uint64(b))
+		return Aggregate{Time: t, Count: 1, Sum: v, Min: v, Max: v, Last: v}
+	}
+	if len(b) != 40 {
+		return Aggregate{Time: t}
+	}
+	f := func(i int) float64 { return math.Float64frombits(binary.BigEndian.Uint64(b[i*8:])) }
+	return Aggregate{Time: t, Count: f(0), Sum: f(1), Min: f(2), Max: f(3), Last: f(4)}
+}
+
+// aggregates sorts by time.
+type aggregates []Aggregate
+
+func (a aggregates) Len() int           { return len(a) }
+func (a aggregates) Less(i, j int) bool { return a[i].Time < a[j].Time }
+func (a aggregates) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
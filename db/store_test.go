@@ -0,0 +1,111 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "autofact")
+	if err != nil {
+		t.Errorf("error creating tmpDir for store: %s", err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	s := NewStore()
+	err = s.Open(filepath.Join(tmpDir, "store.db"))
+	if err != nil {
+		t.Errorf("error opening store: %s", err)
+		return
+	}
+	defer s.Close()
+
+	// two hours of samples, one every 30s, starting on an hour boundary.
+	start := time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)
+	var samples []Sample
+	for i := 0; i < 240; i++ {
+		samples = append(samples, Sample{Client: "a", Series: "loadavg.one", Time: start.Add(time.Duration(i) * 30 * time.Second).UnixNano(), Value: float64(i)})
+	}
+	samples = append(samples, Sample{Client: "b", Series: "loadavg.five", Time: start.UnixNano(), Value: 1})
+	err = s.Write(samples)
+	if err != nil {
+		t.Errorf("write: expected no error; got %s", err)
+		return
+	}
+	aggs, err := s.Read("a", "loadavg.one", start.UnixNano(), start.Add(2*time.Hour).UnixNano())
+	if err != nil {
+		t.Errorf("read: expected no error; got %s", err)
+		return
+	}
+	if len(aggs) != 240 {
+		t.Errorf("read raw: got %d samples; want 240", len(aggs))
+	}
+	names, err := s.Series("a")
+	if err != nil {
+		t.Errorf("series: expected no error; got %s", err)
+	}
+	if len(names) != 1 || names[0] != "loadavg.one" {
+		t.Errorf("series: got %v; want [loadavg.one]", names)
+	}
+
+	// Expire the first hour of raw data: it should be rolled up into 1m.
+	err = s.Enforce(start.Add(time.Hour + s.Retention[Raw]))
+	if err != nil {
+		t.Errorf("enforce: expected no error; got %s", err)
+		return
+	}
+	aggs, err = s.Read("a", "loadavg.one", start.UnixNano(), start.Add(2*time.Hour).UnixNano())
+	if err != nil {
+		t.Errorf("read: expected no error; got %s", err)
+		return
+	}
+	// 60 1m rollups followed by 120 raw samples.
+	if len(aggs) != 180 {
+		t.Errorf("read after rollup: got %d; want 180", len(aggs))
+		return
+	}
+	want := Aggregate{Time: start.UnixNano(), Count: 2, Sum: 1, Min: 0, Max: 1, Last: 1}
+	if aggs[0] != want {
+		t.Errorf("rollup: got %+v; want %+v", aggs[0], want)
+	}
+	if aggs[60].Count != 1 || aggs[60].Last != 120 {
+		t.Errorf("raw after rollup: got %+v; want a single sample of 120", aggs[60])
+	}
+
+	// Expire everything but the hour rollups.
+	err = s.Enforce(start.Add(2*time.Hour + s.Retention[Minute] + s.Partition[Minute]))
+	if err != nil {
+		t.Errorf("enforce: expected no error; got %s", err)
+		return
+	}
+	aggs, err = s.Read("a", "loadavg.one", start.UnixNano(), start.Add(2*time.Hour).UnixNano())
+	if err != nil {
+		t.Errorf("read: expected no error; got %s", err)
+		return
+	}
+	if len(aggs) != 2 {
+		t.Errorf("read after 1h rollup: got %d; want 2", len(aggs))
+		return
+	}
+	if aggs[0].Count != 120 || aggs[0].Min != 0 || aggs[0].Max != 119 || aggs[0].Avg() != 59.5 {
+		t.Errorf("1h rollup: got %+v", aggs[0])
+	}
+
+	// Expire everything.
+	err = s.Enforce(start.Add(s.Retention[Hour] + s.Partition[Hour] + s.Retention[Minute] + s.Partition[Minute]))
+	if err != nil {
+		t.Errorf("enforce: expected no error; got %s", err)
+		return
+	}
+	aggs, err = s.Read("a", "loadavg.one", start.UnixNano(), start.Add(2*time.Hour).UnixNano())
+	if err != nil {
+		t.Errorf("read: expected no error; got %s", err)
+		return
+	}
+	if len(aggs) != 0 {
+		t.Errorf("read after expiration: got %d; want 0", len(aggs))
+	}
+}
@@ -0,0 +1,37 @@
+package message
+
+import "github.com/google/flatbuffers/go"
+
+// SerializeAck creates a flatbuffer serialized Acks of the message IDs and
+// returns the bytes.
+func SerializeAck(ids [][]byte) []byte {
+	bldr := flatbuffers.NewBuilder(0)
+	offs := make([]flatbuffers.UOffsetT, len(ids))
+	for i, id := range ids {
+		v := bldr.CreateByteVector(id)
+		MessageIDStart(bldr)
+		MessageIDAddID(bldr, v)
+		offs[i] = MessageIDEnd(bldr)
+	}
+	AcksStartIDsVector(bldr, len(offs))
+	for i := len(offs) - 1; i >= 0; i-- {
+		bldr.PrependUOffsetT(offs[i])
+	}
+	v := bldr.EndVector(len(offs))
+	AcksStart(bldr)
+	AcksAddIDs(bldr, v)
+	bldr.Finish(AcksEnd(bldr))
+	return bldr.Bytes[bldr.Head():]
+}
+
+// AckIDs returns the message IDs in the flatbuffer serialized Acks.
+func AckIDs(p []byte) [][]byte {
+	ack := GetRootAsAcks(p, 0)
+	ids := make([][]byte, ack.IDsLength())
+	var id MessageID
+	for i := range ids {
+		ack.IDs(&id, i)
+		ids[i] = id.IDBytes()
+	}
+	return ids
+}
@@ -0,0 +1,26 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAck(t *testing.T) {
+	tests := [][][]byte{
+		nil,
+		{[]byte("a")},
+		{[]byte("abcdefgh"), []byte("ijklmnop"), []byte("qrstuvwx")},
+	}
+	for i, test := range tests {
+		ids := AckIDs(SerializeAck(test))
+		if len(ids) != len(test) {
+			t.Errorf("%d: got %d ids; want %d", i, len(ids), len(test))
+			continue
+		}
+		for j, id := range ids {
+			if !bytes.Equal(id, test[j]) {
+				t.Errorf("%d: %d: got %q; want %q", i, j, id, test[j])
+			}
+		}
+	}
+}
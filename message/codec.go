@@ -0,0 +1,61 @@
+package message
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CodecProtobuf is the name of the protobuf codec.
+const CodecProtobuf = "protobuf"
+
+// Codec is a wire format for messages.  Within autofact, messages are
+// handled as flatbuffer serialized Messages; a codec converts them to, and
+// from, its wire format at the connection.  The codec used by a connection is
+// agreed on during the handshake; the handshake itself is always flatbuffer
+// serialized.
+type Codec interface {
+	// Name is the name of the codec used during the handshake.
+	Name() string
+	// Encode returns the wire format of the flatbuffer serialized message.
+	Encode(p []byte) ([]byte, error)
+	// Decode returns the flatbuffer serialized message of the wire format.
+	Decode(p []byte) ([]byte, error)
+}
+
+// codecs are the supported codecs, by name.
+var codecs = map[string]Codec{
+	CodecFlatbuffers: Flatbuffers{},
+	CodecProtobuf:    Protobuf{},
+}
+
+// GetCodec returns the codec with the name.
+func GetCodec(name string) (Codec, error) {
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec: %q", name)
+	}
+	return c, nil
+}
+
+// CodecNames returns the names of the supported codecs, sorted.
+func CodecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for k := range codecs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Flatbuffers is the flatbuffers codec.  Its wire format is the flatbuffer
+// serialized message.
+type Flatbuffers struct{}
+
+// Name returns the name of the codec.
+func (Flatbuffers) Name() string { return CodecFlatbuffers }
+
+// Encode returns the message as is.
+func (Flatbuffers) Encode(p []byte) ([]byte, error) { return p, nil }
+
+// Decode returns the message as is.
+func (Flatbuffers) Decode(p []byte) ([]byte, error) { return p, nil }
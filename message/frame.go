@@ -0,0 +1,58 @@
+package message
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FrameContentType is the content type of a body of frames.
+const FrameContentType = "application/x-autofact-frames"
+
+// WriteFrames writes the messages as frames: each message is preceded by its
+// length as a big-endian uint32.  This is used when messages are sent
+// without a websocket, which frames them itself, e.g. by the HTTP transport.
+func WriteFrames(w io.Writer, msgs [][]byte) error {
+	var n [4]byte
+	for _, p := range msgs {
+		binary.BigEndian.PutUint32(n[:], uint32(len(p)))
+		_, err := w.Write(n[:])
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFrames reads frames, as written by WriteFrames, until EOF and returns
+// their messages.  An error is returned if a frame is truncated or if a
+// message is larger than max bytes.
+func ReadFrames(r io.Reader, max int64) ([][]byte, error) {
+	br := bufio.NewReader(r)
+	var msgs [][]byte
+	var n [4]byte
+	for {
+		_, err := io.ReadFull(br, n[:])
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return msgs, fmt.Errorf("read frame %d: %s", len(msgs), err)
+		}
+		l := int64(binary.BigEndian.Uint32(n[:]))
+		if l > max {
+			return msgs, fmt.Errorf("read frame %d: %d bytes exceeds the maximum of %d", len(msgs), l, max)
+		}
+		p := make([]byte, l)
+		_, err = io.ReadFull(br, p)
+		if err != nil {
+			return msgs, fmt.Errorf("read frame %d: %s", len(msgs), err)
+		}
+		msgs = append(msgs, p)
+	}
+}
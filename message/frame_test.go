@@ -0,0 +1,43 @@
+package message
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFrames(t *testing.T) {
+	msgs := [][]byte{[]byte("a"), {}, []byte("abcdefghijklmnop")}
+	var buf bytes.Buffer
+	err := WriteFrames(&buf, msgs)
+	if err != nil {
+		t.Fatalf("write: unexpected error: %s", err)
+	}
+	b := buf.Bytes()
+	got, err := ReadFrames(bytes.NewReader(b), 16)
+	if err != nil {
+		t.Fatalf("read: unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got, msgs) {
+		t.Errorf("got %q; want %q", got, msgs)
+	}
+	// too large
+	_, err = ReadFrames(bytes.NewReader(b), 15)
+	if err == nil {
+		t.Error("max 15: expected an error, got none")
+	}
+	// truncated
+	for i := 1; i < len(b); i++ {
+		got, err = ReadFrames(bytes.NewReader(b[:i]), 16)
+		if i == 5 || i == 9 {
+			// at the end of a frame
+			if err != nil {
+				t.Errorf("%d bytes: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%d bytes: expected an error, got none", i)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 //go:generate stringer -type=Kind
 package message
 
+import "strings"
+
 // Kind is the kind of message.Message.  This is used for routing.
 type Kind int16
 
@@ -16,6 +18,10 @@ const (
 	LoadAvg        // Sysinfo based load avg
 	MemInfo        // Sysinfo based mem info
 	NetUsage       // network interface usage info
+	CommandReply   // a client's reply to a Command
+	Ack            // the IDs of the messages the server has received
+	Hello          // the protocol a side supports, or the negotiated protocol
+	Relay          // a message of a client connected through a relay
 )
 
 // Int16 is a convenience method that returns the Kind as an int16 value.
@@ -23,3 +29,16 @@ const (
 func (k Kind) Int16() int16 {
 	return int16(k)
 }
+
+// KindFromString returns the Kind for a given string, or Unknown for
+// anything that does not match.  The comparison is case insensitive.
+func KindFromString(s string) Kind {
+	// _Kind_index is from the generated String method; it has an entry for
+	// every Kind plus one.
+	for k := Kind(0); int(k) < len(_Kind_index)-1; k++ {
+		if strings.EqualFold(s, k.String()) {
+			return k
+		}
+	}
+	return Unknown
+}
@@ -4,9 +4,9 @@ package message
 
 import "fmt"
 
-const _Kind_name = "UnknownEOTGenericCommandSysInfoFBSysInfoJSONClientConfCPUUtilizationLoadAvgMemInfoNetUsage"
+const _Kind_name = "UnknownEOTGenericCommandSysInfoFBSysInfoJSONClientConfCPUUtilizationLoadAvgMemInfoNetUsageCommandReplyAckHelloRelay"
 
-var _Kind_index = [...]uint8{0, 7, 10, 17, 24, 33, 44, 54, 68, 75, 82, 90}
+var _Kind_index = [...]uint8{0, 7, 10, 17, 24, 33, 44, 54, 68, 75, 82, 90, 102, 105, 110, 115}
 
 func (i Kind) String() string {
 	if i < 0 || i >= Kind(len(_Kind_index)-1) {
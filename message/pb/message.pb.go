@@ -0,0 +1,223 @@
+// Code generated by protoc-gen-go.
+// source: message.proto
+// DO NOT EDIT!
+
+/*
+Package pb is a generated protocol buffer package.
+
+It is generated from these files:
+
+	message.proto
+
+It has these top-level messages:
+
+	Message
+	Client
+	Command
+	Reply
+	Acks
+	LoadAvg
+	MemInfo
+	CPUUtilization
+	CPU
+	NetUsage
+	Device
+*/
+package pb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+// Message is the envelope; Data is the payload of the Kind.
+type Message struct {
+	ID    []byte `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	DstID uint32 `protobuf:"varint,2,opt,name=DstID,proto3" json:"DstID,omitempty"`
+	Type  int32  `protobuf:"varint,3,opt,name=Type,proto3" json:"Type,omitempty"`
+	Kind  int32  `protobuf:"varint,4,opt,name=Kind,proto3" json:"Kind,omitempty"`
+	Data  []byte `protobuf:"bytes,5,opt,name=Data,proto3" json:"Data,omitempty"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+
+// Client is the ClientConf payload.
+type Client struct {
+	ID                   []byte   `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Hostname             string   `protobuf:"bytes,2,opt,name=Hostname,proto3" json:"Hostname,omitempty"`
+	Region               string   `protobuf:"bytes,3,opt,name=Region,proto3" json:"Region,omitempty"`
+	Zone                 string   `protobuf:"bytes,4,opt,name=Zone,proto3" json:"Zone,omitempty"`
+	DataCenter           string   `protobuf:"bytes,5,opt,name=DataCenter,proto3" json:"DataCenter,omitempty"`
+	HealthbeatPeriod     int64    `protobuf:"varint,6,opt,name=HealthbeatPeriod,proto3" json:"HealthbeatPeriod,omitempty"`
+	MemInfoPeriod        int64    `protobuf:"varint,7,opt,name=MemInfoPeriod,proto3" json:"MemInfoPeriod,omitempty"`
+	NetUsagePeriod       int64    `protobuf:"varint,8,opt,name=NetUsagePeriod,proto3" json:"NetUsagePeriod,omitempty"`
+	CPUUtilizationPeriod int64    `protobuf:"varint,9,opt,name=CPUUtilizationPeriod,proto3" json:"CPUUtilizationPeriod,omitempty"`
+	HealthbeatPush       bool     `protobuf:"varint,10,opt,name=HealthbeatPush,proto3" json:"HealthbeatPush,omitempty"`
+	Role                 string   `protobuf:"bytes,11,opt,name=Role,proto3" json:"Role,omitempty"`
+	Labels               []string `protobuf:"bytes,12,rep,name=Labels" json:"Labels,omitempty"`
+}
+
+func (m *Client) Reset()         { *m = Client{} }
+func (m *Client) String() string { return proto.CompactTextString(m) }
+func (*Client) ProtoMessage()    {}
+
+// Command is the Command payload.
+type Command struct {
+	ID  []byte `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Op  int32  `protobuf:"varint,2,opt,name=Op,proto3" json:"Op,omitempty"`
+	Arg string `protobuf:"bytes,3,opt,name=Arg,proto3" json:"Arg,omitempty"`
+}
+
+func (m *Command) Reset()         { *m = Command{} }
+func (m *Command) String() string { return proto.CompactTextString(m) }
+func (*Command) ProtoMessage()    {}
+
+// Reply is the CommandReply payload.
+type Reply struct {
+	ID    []byte `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	OK    bool   `protobuf:"varint,2,opt,name=OK,proto3" json:"OK,omitempty"`
+	Error string `protobuf:"bytes,3,opt,name=Error,proto3" json:"Error,omitempty"`
+}
+
+func (m *Reply) Reset()         { *m = Reply{} }
+func (m *Reply) String() string { return proto.CompactTextString(m) }
+func (*Reply) ProtoMessage()    {}
+
+// Acks is the Ack payload.
+type Acks struct {
+	IDs [][]byte `protobuf:"bytes,1,rep,name=IDs,proto3" json:"IDs,omitempty"`
+}
+
+func (m *Acks) Reset()         { *m = Acks{} }
+func (m *Acks) String() string { return proto.CompactTextString(m) }
+func (*Acks) ProtoMessage()    {}
+
+// LoadAvg is the LoadAvg payload.
+type LoadAvg struct {
+	Timestamp int64   `protobuf:"varint,1,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+	One       float64 `protobuf:"fixed64,2,opt,name=One,proto3" json:"One,omitempty"`
+	Five      float64 `protobuf:"fixed64,3,opt,name=Five,proto3" json:"Five,omitempty"`
+	Fifteen   float64 `protobuf:"fixed64,4,opt,name=Fifteen,proto3" json:"Fifteen,omitempty"`
+}
+
+func (m *LoadAvg) Reset()         { *m = LoadAvg{} }
+func (m *LoadAvg) String() string { return proto.CompactTextString(m) }
+func (*LoadAvg) ProtoMessage()    {}
+
+// MemInfo is the MemInfo payload.
+type MemInfo struct {
+	Timestamp int64  `protobuf:"varint,1,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+	TotalRAM  uint64 `protobuf:"varint,2,opt,name=TotalRAM,proto3" json:"TotalRAM,omitempty"`
+	FreeRAM   uint64 `protobuf:"varint,3,opt,name=FreeRAM,proto3" json:"FreeRAM,omitempty"`
+	SharedRAM uint64 `protobuf:"varint,4,opt,name=SharedRAM,proto3" json:"SharedRAM,omitempty"`
+	BufferRAM uint64 `protobuf:"varint,5,opt,name=BufferRAM,proto3" json:"BufferRAM,omitempty"`
+	TotalSwap uint64 `protobuf:"varint,6,opt,name=TotalSwap,proto3" json:"TotalSwap,omitempty"`
+	FreeSwap  uint64 `protobuf:"varint,7,opt,name=FreeSwap,proto3" json:"FreeSwap,omitempty"`
+}
+
+func (m *MemInfo) Reset()         { *m = MemInfo{} }
+func (m *MemInfo) String() string { return proto.CompactTextString(m) }
+func (*MemInfo) ProtoMessage()    {}
+
+// CPUUtilization is the CPUUtilization payload.
+type CPUUtilization struct {
+	Timestamp  int64  `protobuf:"varint,1,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+	TimeDelta  int64  `protobuf:"varint,2,opt,name=TimeDelta,proto3" json:"TimeDelta,omitempty"`
+	BTimeDelta int64  `protobuf:"varint,3,opt,name=BTimeDelta,proto3" json:"BTimeDelta,omitempty"`
+	CtxtDelta  int64  `protobuf:"varint,4,opt,name=CtxtDelta,proto3" json:"CtxtDelta,omitempty"`
+	Processes  int64  `protobuf:"varint,5,opt,name=Processes,proto3" json:"Processes,omitempty"`
+	CPU        []*CPU `protobuf:"bytes,6,rep,name=CPU" json:"CPU,omitempty"`
+}
+
+func (m *CPUUtilization) Reset()         { *m = CPUUtilization{} }
+func (m *CPUUtilization) String() string { return proto.CompactTextString(m) }
+func (*CPUUtilization) ProtoMessage()    {}
+
+func (m *CPUUtilization) GetCPU() []*CPU {
+	if m != nil {
+		return m.CPU
+	}
+	return nil
+}
+
+type CPU struct {
+	ID     string  `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Usage  float32 `protobuf:"fixed32,2,opt,name=Usage,proto3" json:"Usage,omitempty"`
+	User   float32 `protobuf:"fixed32,3,opt,name=User,proto3" json:"User,omitempty"`
+	Nice   float32 `protobuf:"fixed32,4,opt,name=Nice,proto3" json:"Nice,omitempty"`
+	System float32 `protobuf:"fixed32,5,opt,name=System,proto3" json:"System,omitempty"`
+	Idle   float32 `protobuf:"fixed32,6,opt,name=Idle,proto3" json:"Idle,omitempty"`
+	IOWait float32 `protobuf:"fixed32,7,opt,name=IOWait,proto3" json:"IOWait,omitempty"`
+}
+
+func (m *CPU) Reset()         { *m = CPU{} }
+func (m *CPU) String() string { return proto.CompactTextString(m) }
+func (*CPU) ProtoMessage()    {}
+
+// NetUsage is the NetUsage payload.
+type NetUsage struct {
+	Timestamp int64     `protobuf:"varint,1,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+	TimeDelta int64     `protobuf:"varint,2,opt,name=TimeDelta,proto3" json:"TimeDelta,omitempty"`
+	Device    []*Device `protobuf:"bytes,3,rep,name=Device" json:"Device,omitempty"`
+}
+
+func (m *NetUsage) Reset()         { *m = NetUsage{} }
+func (m *NetUsage) String() string { return proto.CompactTextString(m) }
+func (*NetUsage) ProtoMessage()    {}
+
+func (m *NetUsage) GetDevice() []*Device {
+	if m != nil {
+		return m.Device
+	}
+	return nil
+}
+
+type Device struct {
+	Name        string `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	RBytes      int64  `protobuf:"varint,2,opt,name=RBytes,proto3" json:"RBytes,omitempty"`
+	RPackets    int64  `protobuf:"varint,3,opt,name=RPackets,proto3" json:"RPackets,omitempty"`
+	RErrs       int64  `protobuf:"varint,4,opt,name=RErrs,proto3" json:"RErrs,omitempty"`
+	RDrop       int64  `protobuf:"varint,5,opt,name=RDrop,proto3" json:"RDrop,omitempty"`
+	RFIFO       int64  `protobuf:"varint,6,opt,name=RFIFO,proto3" json:"RFIFO,omitempty"`
+	RFrame      int64  `protobuf:"varint,7,opt,name=RFrame,proto3" json:"RFrame,omitempty"`
+	RCompressed int64  `protobuf:"varint,8,opt,name=RCompressed,proto3" json:"RCompressed,omitempty"`
+	RMulticast  int64  `protobuf:"varint,9,opt,name=RMulticast,proto3" json:"RMulticast,omitempty"`
+	TBytes      int64  `protobuf:"varint,10,opt,name=TBytes,proto3" json:"TBytes,omitempty"`
+	TPackets    int64  `protobuf:"varint,11,opt,name=TPackets,proto3" json:"TPackets,omitempty"`
+	TErrs       int64  `protobuf:"varint,12,opt,name=TErrs,proto3" json:"TErrs,omitempty"`
+	TDrop       int64  `protobuf:"varint,13,opt,name=TDrop,proto3" json:"TDrop,omitempty"`
+	TFIFO       int64  `protobuf:"varint,14,opt,name=TFIFO,proto3" json:"TFIFO,omitempty"`
+	TColls      int64  `protobuf:"varint,15,opt,name=TColls,proto3" json:"TColls,omitempty"`
+	TCarrier    int64  `protobuf:"varint,16,opt,name=TCarrier,proto3" json:"TCarrier,omitempty"`
+	TCompressed int64  `protobuf:"varint,17,opt,name=TCompressed,proto3" json:"TCompressed,omitempty"`
+}
+
+func (m *Device) Reset()         { *m = Device{} }
+func (m *Device) String() string { return proto.CompactTextString(m) }
+func (*Device) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*Message)(nil), "autofact.Message")
+	proto.RegisterType((*Client)(nil), "autofact.Client")
+	proto.RegisterType((*Command)(nil), "autofact.Command")
+	proto.RegisterType((*Reply)(nil), "autofact.Reply")
+	proto.RegisterType((*Acks)(nil), "autofact.Acks")
+	proto.RegisterType((*LoadAvg)(nil), "autofact.LoadAvg")
+	proto.RegisterType((*MemInfo)(nil), "autofact.MemInfo")
+	proto.RegisterType((*CPUUtilization)(nil), "autofact.CPUUtilization")
+	proto.RegisterType((*CPU)(nil), "autofact.CPU")
+	proto.RegisterType((*NetUsage)(nil), "autofact.NetUsage")
+	proto.RegisterType((*Device)(nil), "autofact.Device")
+}
@@ -0,0 +1,266 @@
+package message
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/flatbuffers/go"
+	"github.com/mohae/autofact/command"
+	"github.com/mohae/autofact/conf"
+	"github.com/mohae/autofact/message/pb"
+	"github.com/mohae/autofact/util"
+	cpuutilf "github.com/mohae/joefriday/cpu/cpuutil/flat"
+	netusagef "github.com/mohae/joefriday/net/netusage/flat"
+	loadavgf "github.com/mohae/joefriday/sysinfo/loadavg/flat"
+	memf "github.com/mohae/joefriday/sysinfo/mem/flat"
+)
+
+// Protobuf is the protobuf codec.  Its wire format is a pb.Message whose Data
+// is the protobuf message of its Kind's payload, as defined in message.proto.
+// Payloads of kinds without a protobuf message, e.g. SysInfoJSON, are passed
+// through as is.
+type Protobuf struct{}
+
+// Name returns the name of the codec.
+func (Protobuf) Name() string { return CodecProtobuf }
+
+// Encode returns the protobuf serialized message.
+func (Protobuf) Encode(p []byte) ([]byte, error) {
+	msg := GetRootAsMessage(p, 0)
+	k := Kind(msg.Kind())
+	data := msg.DataBytes()
+	if pl, ok := payloads[k]; ok && len(data) > 0 {
+		var err error
+		data, err = pl.encode(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return proto.Marshal(&pb.Message{
+		ID:    msg.IDBytes(),
+		DstID: msg.DstID(),
+		Type:  int32(msg.Type()),
+		Kind:  int32(k),
+		Data:  data,
+	})
+}
+
+// Decode returns the flatbuffer serialized message of the protobuf serialized
+// message.
+func (Protobuf) Decode(p []byte) ([]byte, error) {
+	var m pb.Message
+	err := proto.Unmarshal(p, &m)
+	if err != nil {
+		return nil, err
+	}
+	k := Kind(m.Kind)
+	data := m.Data
+	if pl, ok := payloads[k]; ok && len(data) > 0 {
+		data, err = pl.decode(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	bldr := flatbuffers.NewBuilder(0)
+	id := bldr.CreateByteVector(m.ID)
+	d := bldr.CreateByteVector(data)
+	MessageStart(bldr)
+	MessageAddID(bldr, id)
+	MessageAddDstID(bldr, m.DstID)
+	MessageAddType(bldr, int8(m.Type))
+	MessageAddKind(bldr, k.Int16())
+	MessageAddData(bldr, d)
+	bldr.Finish(MessageEnd(bldr))
+	return bldr.Bytes[bldr.Head():], nil
+}
+
+// payload converts a kind's flatbuffer serialized payload to, and from, the
+// protobuf serialized payload.
+type payload struct {
+	encode func(p []byte) ([]byte, error)
+	decode func(b []byte) ([]byte, error)
+}
+
+// payloads are the payloads that have a protobuf message, by kind.
+var payloads = map[Kind]payload{
+	ClientConf: {encodeClientConf, decodeClientConf},
+	Command:    {encodeCommand, decodeCommand},
+	CommandReply: {
+		encode: func(p []byte) ([]byte, error) {
+			r := command.GetRootAsReply(p, 0)
+			return proto.Marshal(&pb.Reply{ID: r.IDBytes(), OK: util.ByteToBool(r.OK()), Error: string(r.Error())})
+		},
+		decode: func(b []byte) ([]byte, error) {
+			var m pb.Reply
+			err := proto.Unmarshal(b, &m)
+			if err != nil {
+				return nil, err
+			}
+			if m.OK {
+				return command.SerializeReply(m.ID, nil), nil
+			}
+			return command.SerializeReply(m.ID, errors.New(m.Error)), nil
+		},
+	},
+	Ack: {
+		encode: func(p []byte) ([]byte, error) {
+			return proto.Marshal(&pb.Acks{IDs: AckIDs(p)})
+		},
+		decode: func(b []byte) ([]byte, error) {
+			var m pb.Acks
+			err := proto.Unmarshal(b, &m)
+			if err != nil {
+				return nil, err
+			}
+			return SerializeAck(m.IDs), nil
+		},
+	},
+	CPUUtilization: flatPayload(cpuutilf.Deserialize, cpuutilf.Serialize, func() proto.Message { return &pb.CPUUtilization{} }),
+	LoadAvg:        flatPayload(loadavgf.Deserialize, loadavgf.Serialize, func() proto.Message { return &pb.LoadAvg{} }),
+	MemInfo:        flatPayload(memf.Deserialize, memf.Serialize, func() proto.Message { return &pb.MemInfo{} }),
+	NetUsage:       flatPayload(netusagef.Deserialize, netusagef.Serialize, func() proto.Message { return &pb.NetUsage{} }),
+}
+
+func encodeClientConf(p []byte) ([]byte, error) {
+	c := conf.GetRootAsClient(p, 0)
+	labels := make([]string, c.LabelsLength())
+	for i := range labels {
+		labels[i] = string(c.Labels(i))
+	}
+	return proto.Marshal(&pb.Client{
+		ID:                   c.IDBytes(),
+		Hostname:             string(c.Hostname()),
+		Region:               string(c.Region()),
+		Zone:                 string(c.Zone()),
+		DataCenter:           string(c.DataCenter()),
+		HealthbeatPeriod:     c.HealthbeatPeriod(),
+		MemInfoPeriod:        c.MemInfoPeriod(),
+		NetUsagePeriod:       c.NetUsagePeriod(),
+		CPUUtilizationPeriod: c.CPUUtilizationPeriod(),
+		HealthbeatPush:       util.ByteToBool(c.HealthbeatPush()),
+		Role:                 string(c.Role()),
+		Labels:               labels,
+	})
+}
+
+func decodeClientConf(b []byte) ([]byte, error) {
+	var m pb.Client
+	err := proto.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+	c := conf.ClientInfo{
+		ID:                   m.ID,
+		Hostname:             m.Hostname,
+		Region:               m.Region,
+		Zone:                 m.Zone,
+		DataCenter:           m.DataCenter,
+		Role:                 m.Role,
+		HealthbeatPeriod:     m.HealthbeatPeriod,
+		MemInfoPeriod:        m.MemInfoPeriod,
+		NetUsagePeriod:       m.NetUsagePeriod,
+		CPUUtilizationPeriod: m.CPUUtilizationPeriod,
+		HealthbeatPush:       m.HealthbeatPush,
+	}
+	for _, v := range m.Labels {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if c.Labels == nil {
+			c.Labels = make(map[string]string)
+		}
+		c.Labels[kv[0]] = kv[1]
+	}
+	return c.Serialize(), nil
+}
+
+func encodeCommand(p []byte) ([]byte, error) {
+	c := command.GetRootAsCommand(p, 0)
+	return proto.Marshal(&pb.Command{ID: c.IDBytes(), Op: int32(c.Op()), Arg: string(c.Arg())})
+}
+
+func decodeCommand(b []byte) ([]byte, error) {
+	var m pb.Command
+	err := proto.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+	return command.Serialize(m.ID, command.Op(m.Op), m.Arg), nil
+}
+
+// flatPayload returns the payload for a joefriday flat package, using its
+// Deserialize, func([]byte) *T, and Serialize, func(*T) []byte, funcs.  The
+// fields of T are copied to, and from, the protobuf message returned by
+// newMsg by name; the funcs are called using reflection so that only the
+// names of T's fields matter.
+func flatPayload(deserialize, serialize interface{}, newMsg func() proto.Message) payload {
+	d := reflect.ValueOf(deserialize)
+	s := reflect.ValueOf(serialize)
+	return payload{
+		encode: func(p []byte) ([]byte, error) {
+			v := d.Call([]reflect.Value{reflect.ValueOf(p)})[0]
+			m := newMsg()
+			copyValue(reflect.ValueOf(m), v)
+			return proto.Marshal(m)
+		},
+		decode: func(b []byte) ([]byte, error) {
+			m := newMsg()
+			err := proto.Unmarshal(b, m)
+			if err != nil {
+				return nil, err
+			}
+			v := reflect.New(s.Type().In(0)).Elem()
+			copyValue(v, reflect.ValueOf(m))
+			return s.Call([]reflect.Value{v})[0].Bytes(), nil
+		},
+	}
+}
+
+// copyValue copies src to dst.  Structs are copied field by field, to the
+// fields with the same name, and slices element by element; nil pointers in
+// dst are allocated.  Numeric values are converted to dst's type; values that
+// can't be converted are skipped.
+func copyValue(dst, src reflect.Value) {
+	if src.Kind() == reflect.Ptr {
+		if src.IsNil() {
+			return
+		}
+		src = src.Elem()
+	}
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+	switch {
+	case dst.Kind() == reflect.Struct && src.Kind() == reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			// skip unexported fields
+			if dst.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			f := src.FieldByName(dst.Type().Field(i).Name)
+			if f.IsValid() {
+				copyValue(dst.Field(i), f)
+			}
+		}
+	case dst.Kind() == reflect.Slice && src.Kind() == reflect.Slice && dst.Type().Elem().Kind() != reflect.Uint8:
+		s := reflect.MakeSlice(dst.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			copyValue(s.Index(i), src.Index(i))
+		}
+		dst.Set(s)
+	case src.Kind() == dst.Kind(), isNumeric(src.Kind()) && isNumeric(dst.Kind()):
+		if src.Type().ConvertibleTo(dst.Type()) {
+			dst.Set(src.Convert(dst.Type()))
+		}
+	}
+}
+
+func isNumeric(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
@@ -0,0 +1,117 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/flatbuffers/go"
+	"github.com/mohae/autofact/command"
+	"github.com/mohae/autofact/conf"
+	"github.com/mohae/snoflinga"
+)
+
+func TestProtobuf(t *testing.T) {
+	var flake snoflinga.Flake
+	copy(flake[:], "abcdefghijklmnop")
+	tests := []struct {
+		k Kind
+		p []byte
+	}{
+		{EOT, nil},
+		{SysInfoJSON, []byte(`{"hostname":"test"}`)},
+		{Command, command.Serialize([]byte("id"), command.Burst, "1s,10m")},
+		{CommandReply, command.SerializeReply([]byte("id"), errors.New("unknown command"))},
+		{Ack, SerializeAck([][]byte{[]byte("abcdefgh"), []byte("ijklmnop")})},
+		{ClientConf, clientConf()},
+	}
+	var codec Protobuf
+	for _, test := range tests {
+		b, err := codec.Encode(Serialize(flake, test.k, test.p))
+		if err != nil {
+			t.Errorf("%s: encode: unexpected error: %s", test.k, err)
+			continue
+		}
+		p, err := codec.Decode(b)
+		if err != nil {
+			t.Errorf("%s: decode: unexpected error: %s", test.k, err)
+			continue
+		}
+		msg := GetRootAsMessage(p, 0)
+		if !bytes.Equal(msg.IDBytes(), flake[:]) {
+			t.Errorf("%s: got id %q; want %q", test.k, msg.IDBytes(), flake[:])
+		}
+		if Kind(msg.Kind()) != test.k {
+			t.Errorf("got kind %s; want %s", Kind(msg.Kind()), test.k)
+		}
+		switch test.k {
+		case Command:
+			c := command.GetRootAsCommand(msg.DataBytes(), 0)
+			if command.Op(c.Op()) != command.Burst || string(c.Arg()) != "1s,10m" || string(c.IDBytes()) != "id" {
+				t.Errorf("%s: got %s %q %q; want Burst \"1s,10m\" \"id\"", test.k, command.Op(c.Op()), c.Arg(), c.IDBytes())
+			}
+		case CommandReply:
+			r := command.GetRootAsReply(msg.DataBytes(), 0)
+			if r.OK() != 0 || string(r.Error()) != "unknown command" {
+				t.Errorf("%s: got %d %q; want 0 \"unknown command\"", test.k, r.OK(), r.Error())
+			}
+		case ClientConf:
+			c := conf.GetRootAsClient(msg.DataBytes(), 0)
+			if string(c.IDBytes()) != "abcd1234" || string(c.Hostname()) != "test" || c.HealthbeatPeriod() != int64(time.Second) || c.HealthbeatPush() != 1 {
+				t.Errorf("%s: got %q %q %d %d; want \"abcd1234\" \"test\" %d 1", test.k, c.IDBytes(), c.Hostname(), c.HealthbeatPeriod(), c.HealthbeatPush(), time.Second)
+			}
+			info := c.Info()
+			if info.Role != "web" || !reflect.DeepEqual(info.Labels, map[string]string{"env": "prod"}) {
+				t.Errorf("%s: got %q %v; want \"web\" map[env:prod]", test.k, info.Role, info.Labels)
+			}
+		case Ack:
+			ids := AckIDs(msg.DataBytes())
+			if len(ids) != 2 || string(ids[0]) != "abcdefgh" || string(ids[1]) != "ijklmnop" {
+				t.Errorf("%s: got %q; want [abcdefgh ijklmnop]", test.k, ids)
+			}
+		default:
+			if !bytes.Equal(msg.DataBytes(), test.p) {
+				t.Errorf("%s: got %q; want %q", test.k, msg.DataBytes(), test.p)
+			}
+		}
+	}
+}
+
+func clientConf() []byte {
+	bldr := flatbuffers.NewBuilder(0)
+	id := bldr.CreateByteVector([]byte("abcd1234"))
+	h := bldr.CreateString("test")
+	r := bldr.CreateString("web")
+	l := bldr.CreateString("env=prod")
+	conf.ClientStartLabelsVector(bldr, 1)
+	bldr.PrependUOffsetT(l)
+	labels := bldr.EndVector(1)
+	conf.ClientStart(bldr)
+	conf.ClientAddID(bldr, id)
+	conf.ClientAddHostname(bldr, h)
+	conf.ClientAddHealthbeatPeriod(bldr, int64(time.Second))
+	conf.ClientAddHealthbeatPush(bldr, 1)
+	conf.ClientAddRole(bldr, r)
+	conf.ClientAddLabels(bldr, labels)
+	bldr.Finish(conf.ClientEnd(bldr))
+	return bldr.Bytes[bldr.Head():]
+}
+
+func TestGetCodec(t *testing.T) {
+	for _, name := range CodecNames() {
+		c, err := GetCodec(name)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		if c.Name() != name {
+			t.Errorf("got %s; want %s", c.Name(), name)
+		}
+	}
+	_, err := GetCodec("json")
+	if err == nil {
+		t.Error("json: expected an error, got none")
+	}
+}
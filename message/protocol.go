@@ -0,0 +1,253 @@
+package message
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/flatbuffers/go"
+)
+
+// Protocol versions.
+const (
+	// ProtocolVersion is the current version of the protocol.
+	ProtocolVersion = 3
+	// PushHealthbeatVersion is the first version in which clients push their
+	// healthbeat when their ClientConf's HealthbeatPush is set.
+	PushHealthbeatVersion = 3
+	// HelloProtocolVersion is the first version in which the handshake
+	// starts with a Hello.
+	HelloProtocolVersion = 2
+	// LegacyProtocolVersion is the version of the protocol used by clients
+	// that start the handshake by sending their ID as a text message instead
+	// of a Hello.
+	LegacyProtocolVersion = 1
+)
+
+// CodecFlatbuffers is the name of the flatbuffers codec.
+const CodecFlatbuffers = "flatbuffers"
+
+// LegacyKinds are the kinds supported by LegacyProtocolVersion clients.
+var LegacyKinds = []Kind{EOT, ClientConf, SysInfoJSON, CPUUtilization, LoadAvg, MemInfo, NetUsage}
+
+// Protocol is what one side of a connection supports.  During the handshake,
+// the client sends the server a Hello with the protocol it supports and the
+// server replies with a Hello with the protocol they've agreed on.
+type Protocol struct {
+	// ID is the client's ID; it is only set by the client.
+	ID []byte
+	// Version is the newest version supported, or the agreed on version.
+	Version int16
+	// MinVersion is the oldest version supported.
+	MinVersion int16
+	// Kinds are the message kinds that are understood.
+	Kinds []Kind
+	// Codecs are the supported codecs, in order of preference.
+	Codecs []string
+	// Fingerprint identifies the client's host; it is only set by the
+	// client.
+	Fingerprint Fingerprint
+	// Hostname and Labels, e.g. the client's region, zone, datacenter, and
+	// role, describe the client's host; they are only set by the client.
+	Hostname string
+	Labels   map[string]string
+}
+
+// Fingerprint identifies a host independent of the client's ID, which is lost
+// when the host is reimaged or the client's configuration is deleted.  Only
+// MachineID is required; ProductUUID and MAC are optional.
+type Fingerprint struct {
+	// MachineID is the host's /etc/machine-id.
+	MachineID string
+	// ProductUUID is the host's DMI product UUID.
+	ProductUUID string
+	// MAC is the hardware address of the host's primary network interface.
+	MAC string
+}
+
+// IsZero returns whether the fingerprint is empty, e.g. the client doesn't
+// send one.
+func (f Fingerprint) IsZero() bool {
+	return f.MachineID == "" && f.ProductUUID == "" && f.MAC == ""
+}
+
+// Keys returns a key for each of the fingerprint's identifiers that is set,
+// in order of precedence.
+func (f Fingerprint) Keys() []string {
+	var keys []string
+	if f.MachineID != "" {
+		keys = append(keys, "machine-id:"+f.MachineID)
+	}
+	if f.ProductUUID != "" {
+		keys = append(keys, "product-uuid:"+f.ProductUUID)
+	}
+	if f.MAC != "" {
+		keys = append(keys, "mac:"+f.MAC)
+	}
+	return keys
+}
+
+// Supports returns whether messages of kind k are supported.
+func (p *Protocol) Supports(k Kind) bool {
+	for _, v := range p.Kinds {
+		if v == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Codec returns the preferred codec; for an agreed on protocol this is the
+// codec that was agreed on.
+func (p *Protocol) Codec() string {
+	if len(p.Codecs) == 0 {
+		return ""
+	}
+	return p.Codecs[0]
+}
+
+// Serialize creates a flatbuffer serialized Handshake of the protocol and
+// returns the bytes.
+func (p *Protocol) Serialize() []byte {
+	bldr := flatbuffers.NewBuilder(0)
+	id := bldr.CreateByteVector(p.ID)
+	codecs := make([]flatbuffers.UOffsetT, len(p.Codecs))
+	for i, v := range p.Codecs {
+		codecs[i] = bldr.CreateString(v)
+	}
+	HandshakeStartCodecsVector(bldr, len(codecs))
+	for i := len(codecs) - 1; i >= 0; i-- {
+		bldr.PrependUOffsetT(codecs[i])
+	}
+	c := bldr.EndVector(len(codecs))
+	HandshakeStartKindsVector(bldr, len(p.Kinds))
+	for i := len(p.Kinds) - 1; i >= 0; i-- {
+		bldr.PrependInt16(p.Kinds[i].Int16())
+	}
+	k := bldr.EndVector(len(p.Kinds))
+	var mid, uuid, mac flatbuffers.UOffsetT
+	if p.Fingerprint.MachineID != "" {
+		mid = bldr.CreateString(p.Fingerprint.MachineID)
+	}
+	if p.Fingerprint.ProductUUID != "" {
+		uuid = bldr.CreateString(p.Fingerprint.ProductUUID)
+	}
+	if p.Fingerprint.MAC != "" {
+		mac = bldr.CreateString(p.Fingerprint.MAC)
+	}
+	var host flatbuffers.UOffsetT
+	if p.Hostname != "" {
+		host = bldr.CreateString(p.Hostname)
+	}
+	var labels flatbuffers.UOffsetT
+	if len(p.Labels) > 0 {
+		keys := make([]string, 0, len(p.Labels))
+		for k := range p.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kvs := make([]flatbuffers.UOffsetT, len(keys))
+		for i, k := range keys {
+			kvs[i] = bldr.CreateString(k + "=" + p.Labels[k])
+		}
+		HandshakeStartLabelsVector(bldr, len(kvs))
+		for i := len(kvs) - 1; i >= 0; i-- {
+			bldr.PrependUOffsetT(kvs[i])
+		}
+		labels = bldr.EndVector(len(kvs))
+	}
+	HandshakeStart(bldr)
+	HandshakeAddID(bldr, id)
+	HandshakeAddVersion(bldr, p.Version)
+	HandshakeAddMinVersion(bldr, p.MinVersion)
+	HandshakeAddKinds(bldr, k)
+	HandshakeAddCodecs(bldr, c)
+	if mid != 0 {
+		HandshakeAddMachineID(bldr, mid)
+	}
+	if uuid != 0 {
+		HandshakeAddProductUUID(bldr, uuid)
+	}
+	if mac != 0 {
+		HandshakeAddMAC(bldr, mac)
+	}
+	if host != 0 {
+		HandshakeAddHostname(bldr, host)
+	}
+	if labels != 0 {
+		HandshakeAddLabels(bldr, labels)
+	}
+	bldr.Finish(HandshakeEnd(bldr))
+	return bldr.Bytes[bldr.Head():]
+}
+
+// GetProtocol returns the protocol in the flatbuffer serialized Handshake.
+func GetProtocol(b []byte) Protocol {
+	h := GetRootAsHandshake(b, 0)
+	p := Protocol{
+		ID:         h.IDBytes(),
+		Version:    h.Version(),
+		MinVersion: h.MinVersion(),
+		Kinds:      make([]Kind, h.KindsLength()),
+		Codecs:     make([]string, h.CodecsLength()),
+		Fingerprint: Fingerprint{
+			MachineID:   string(h.MachineID()),
+			ProductUUID: string(h.ProductUUID()),
+			MAC:         string(h.MAC()),
+		},
+		Hostname: string(h.Hostname()),
+	}
+	for i := 0; i < h.LabelsLength(); i++ {
+		kv := strings.SplitN(string(h.Labels(i)), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if p.Labels == nil {
+			p.Labels = make(map[string]string)
+		}
+		p.Labels[kv[0]] = kv[1]
+	}
+	for i := range p.Kinds {
+		p.Kinds[i] = Kind(h.Kinds(i))
+	}
+	for i := range p.Codecs {
+		p.Codecs[i] = string(h.Codecs(i))
+	}
+	return p
+}
+
+// Negotiate returns the protocol that the local and remote sides can agree
+// on: the newest version both support, the kinds both understand, and the
+// first of the remote's codecs that the local side supports.  The returned
+// protocol has the remote's ID, Fingerprint, Hostname, and Labels.  An error
+// is returned if there isn't a version or a codec that both support.
+func Negotiate(local, remote Protocol) (Protocol, error) {
+	p := Protocol{
+		ID:          remote.ID,
+		Version:     local.Version,
+		Fingerprint: remote.Fingerprint,
+		Hostname:    remote.Hostname,
+		Labels:      remote.Labels,
+	}
+	if remote.Version < p.Version {
+		p.Version = remote.Version
+	}
+	if p.Version < local.MinVersion || p.Version < remote.MinVersion {
+		return p, fmt.Errorf("incompatible protocol versions: requested %d-%d, supported %d-%d", remote.MinVersion, remote.Version, local.MinVersion, local.Version)
+	}
+	p.MinVersion = p.Version
+	for _, k := range remote.Kinds {
+		if local.Supports(k) {
+			p.Kinds = append(p.Kinds, k)
+		}
+	}
+	for _, c := range remote.Codecs {
+		for _, v := range local.Codecs {
+			if c == v {
+				p.Codecs = []string{c}
+				return p, nil
+			}
+		}
+	}
+	return p, fmt.Errorf("no common codec: requested %v, supported %v", remote.Codecs, local.Codecs)
+}
@@ -0,0 +1,86 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProtocolSerialize(t *testing.T) {
+	p := Protocol{
+		ID:         []byte("abc"),
+		Version:    ProtocolVersion,
+		MinVersion: LegacyProtocolVersion,
+		Kinds:      []Kind{EOT, ClientConf, Ack},
+		Codecs:     []string{"protobuf", CodecFlatbuffers},
+	}
+	got := GetProtocol(p.Serialize())
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("got %+v; want %+v", got, p)
+	}
+	p.Fingerprint = Fingerprint{MachineID: "0123456789abcdef", MAC: "02:42:ac:11:00:02"}
+	got = GetProtocol(p.Serialize())
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("fingerprint: got %+v; want %+v", got, p)
+	}
+	p.Hostname = "web1"
+	p.Labels = map[string]string{"region": "us-east", "role": "web", "team": "ops=core"}
+	got = GetProtocol(p.Serialize())
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("labels: got %+v; want %+v", got, p)
+	}
+}
+
+func TestFingerprintKeys(t *testing.T) {
+	var f Fingerprint
+	if !f.IsZero() || len(f.Keys()) != 0 {
+		t.Errorf("empty fingerprint: got %v; want no keys", f.Keys())
+	}
+	f = Fingerprint{MachineID: "abc", ProductUUID: "def", MAC: "02:42:ac:11:00:02"}
+	want := []string{"machine-id:abc", "product-uuid:def", "mac:02:42:ac:11:00:02"}
+	if got := f.Keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	server := Protocol{
+		Version:    3,
+		MinVersion: 2,
+		Kinds:      []Kind{EOT, ClientConf, Command, Ack, LoadAvg},
+		Codecs:     []string{CodecFlatbuffers, "protobuf"},
+	}
+	tests := []struct {
+		name   string
+		client Protocol
+		want   Protocol
+		err    bool
+	}{
+		{
+			"older client", Protocol{ID: []byte("a"), Version: 2, MinVersion: 2, Kinds: []Kind{EOT, ClientConf, LoadAvg, Hello}, Codecs: []string{"protobuf", CodecFlatbuffers}},
+			Protocol{ID: []byte("a"), Version: 2, MinVersion: 2, Kinds: []Kind{EOT, ClientConf, LoadAvg}, Codecs: []string{"protobuf"}}, false,
+		},
+		{
+			"newer client", Protocol{Version: 5, MinVersion: 3, Kinds: []Kind{Ack}, Codecs: []string{CodecFlatbuffers}},
+			Protocol{Version: 3, MinVersion: 3, Kinds: []Kind{Ack}, Codecs: []string{CodecFlatbuffers}}, false,
+		},
+		{"too old", Protocol{Version: 1, MinVersion: 1, Codecs: []string{CodecFlatbuffers}}, Protocol{}, true},
+		{"too new", Protocol{Version: 5, MinVersion: 4, Codecs: []string{CodecFlatbuffers}}, Protocol{}, true},
+		{"no codec", Protocol{Version: 2, MinVersion: 2, Codecs: []string{"json"}}, Protocol{}, true},
+	}
+	for _, test := range tests {
+		p, err := Negotiate(server, test.client)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(p, test.want) {
+			t.Errorf("%s: got %+v; want %+v", test.name, p, test.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package message
+
+import (
+	"github.com/google/flatbuffers/go"
+	"github.com/gorilla/websocket"
+)
+
+// A relay is a client that multiplexes the connections of other clients over
+// its own connection to the server.  Each of its clients is identified, on
+// the relay's connection, by a non-zero DstID.  The messages of a client are
+// carried, as is, in the Data of Relay messages; the first is the client's
+// Hello.  An EOT with a DstID ends the client's session; its Data is the
+// reason.
+//
+// Relay messages don't have an ID: they are neither acknowledged nor
+// deduplicated.  The messages they carry are, by the client they're from.
+
+// SerializeRelay creates a flatbuffer serialized Relay message that carries
+// p, a message from, or for, the relayed client dst, and returns the bytes.
+func SerializeRelay(dst uint32, p []byte) []byte {
+	return serializeDst(Relay, dst, p)
+}
+
+// SerializeRelayEnd creates a flatbuffer serialized EOT that ends the session
+// of the relayed client dst and returns the bytes.
+func SerializeRelayEnd(dst uint32, reason string) []byte {
+	return serializeDst(EOT, dst, []byte(reason))
+}
+
+// serializeDst creates a flatbuffer serialized message, without an ID, for
+// dst and returns the bytes.
+func serializeDst(k Kind, dst uint32, p []byte) []byte {
+	bldr := flatbuffers.NewBuilder(0)
+	d := bldr.CreateByteVector(p)
+	MessageStart(bldr)
+	MessageAddDstID(bldr, dst)
+	MessageAddType(bldr, websocket.BinaryMessage)
+	MessageAddKind(bldr, k.Int16())
+	MessageAddData(bldr, d)
+	bldr.Finish(MessageEnd(bldr))
+	return bldr.Bytes[bldr.Head():]
+}
@@ -0,0 +1,54 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mohae/snoflinga"
+)
+
+func TestRelay(t *testing.T) {
+	p := Serialize(snoflinga.New([]byte("abcdefgh")).Snowflake(), LoadAvg, []byte("data"))
+	tests := []struct {
+		p    []byte
+		kind Kind
+		dst  uint32
+		data []byte
+	}{
+		{SerializeRelay(1, p), Relay, 1, p},
+		{SerializeRelay(4294967295, nil), Relay, 4294967295, nil},
+		{SerializeRelayEnd(42, "closed"), EOT, 42, []byte("closed")},
+	}
+	for i, test := range tests {
+		for _, codec := range []Codec{Flatbuffers{}, Protobuf{}} {
+			b, err := codec.Encode(test.p)
+			if err != nil {
+				t.Errorf("%d: %s: encode: unexpected error: %s", i, codec.Name(), err)
+				continue
+			}
+			b, err = codec.Decode(b)
+			if err != nil {
+				t.Errorf("%d: %s: decode: unexpected error: %s", i, codec.Name(), err)
+				continue
+			}
+			err = Verify(b)
+			if err != nil {
+				t.Errorf("%d: %s: verify: unexpected error: %s", i, codec.Name(), err)
+				continue
+			}
+			msg := GetRootAsMessage(b, 0)
+			if len(msg.IDBytes()) != 0 {
+				t.Errorf("%d: %s: got ID %q; want none", i, codec.Name(), msg.IDBytes())
+			}
+			if Kind(msg.Kind()) != test.kind {
+				t.Errorf("%d: %s: got kind %s; want %s", i, codec.Name(), Kind(msg.Kind()), test.kind)
+			}
+			if msg.DstID() != test.dst {
+				t.Errorf("%d: %s: got DstID %d; want %d", i, codec.Name(), msg.DstID(), test.dst)
+			}
+			if !bytes.Equal(msg.DataBytes(), test.data) {
+				t.Errorf("%d: %s: got data %q; want %q", i, codec.Name(), msg.DataBytes(), test.data)
+			}
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package message
+
+import (
+	"fmt"
+
+	"github.com/google/flatbuffers/go"
+	cpuutilf "github.com/mohae/joefriday/cpu/cpuutil/flat"
+	netusagef "github.com/mohae/joefriday/net/netusage/flat"
+	loadavgf "github.com/mohae/joefriday/sysinfo/loadavg/flat"
+	memf "github.com/mohae/joefriday/sysinfo/mem/flat"
+)
+
+// DefaultMaxFrameSize is the default maximum size, in bytes, of a frame read
+// from a connection.
+const DefaultMaxFrameSize = 1 << 20
+
+// Verify bounds-checks the flatbuffer serialized message and its payload so
+// that it is safe to access using GetRootAsMessage and the accessors of the
+// payload's kind.  Messages that fail verification aren't safe to use.
+func Verify(p []byte) error {
+	msg, err := verifyRoot(p)
+	if err != nil {
+		return fmt.Errorf("malformed message: %s", err)
+	}
+	err = msg.vector(0, 1) // ID
+	if err == nil {
+		err = msg.scalars(1, 4, 2, 1, 3, 2) // DstID, Type, Kind
+	}
+	if err == nil {
+		err = msg.vector(4, 1) // Data
+	}
+	if err != nil {
+		return fmt.Errorf("malformed message: %s", err)
+	}
+	m := GetRootAsMessage(p, 0)
+	k := Kind(m.Kind())
+	verify, ok := verifiers[k]
+	if !ok {
+		return nil
+	}
+	data := m.DataBytes()
+	if len(data) == 0 {
+		return nil
+	}
+	err = verify(data)
+	if err != nil {
+		return fmt.Errorf("malformed %s payload: %s", k, err)
+	}
+	return nil
+}
+
+// VerifyPayload bounds-checks a payload of kind k, e.g. a ClientConf that
+// isn't in a message.  Payloads of kinds without a verifier aren't checked.
+func VerifyPayload(k Kind, p []byte) error {
+	verify, ok := verifiers[k]
+	if !ok {
+		return nil
+	}
+	err := verify(p)
+	if err != nil {
+		return fmt.Errorf("malformed %s: %s", k, err)
+	}
+	return nil
+}
+
+// verifiers bounds-check a kind's payload.  Kinds without a verifier either
+// don't have a payload or their payload isn't flatbuffer serialized.
+var verifiers = map[Kind]func([]byte) error{
+	ClientConf: func(p []byte) error {
+		t, err := verifyRoot(p)
+		if err != nil {
+			return err
+		}
+		for slot := 0; slot < 5; slot++ { // ID, Hostname, Region, Zone, DataCenter
+			err = t.vector(slot, 1)
+			if err != nil {
+				return err
+			}
+		}
+		err = t.scalars(5, 8, 6, 8, 7, 8, 8, 8, 9, 1) // the periods, HealthbeatPush
+		if err != nil {
+			return err
+		}
+		err = t.vector(10, 1) // Role
+		if err != nil {
+			return err
+		}
+		return t.strings(11) // Labels
+	},
+	Command: func(p []byte) error {
+		t, err := verifyRoot(p)
+		if err != nil {
+			return err
+		}
+		err = t.vector(0, 1) // ID
+		if err != nil {
+			return err
+		}
+		err = t.scalars(1, 2) // Op
+		if err != nil {
+			return err
+		}
+		return t.vector(2, 1) // Arg
+	},
+	CommandReply: func(p []byte) error {
+		t, err := verifyRoot(p)
+		if err != nil {
+			return err
+		}
+		err = t.vector(0, 1) // ID
+		if err != nil {
+			return err
+		}
+		err = t.scalars(1, 1) // OK
+		if err != nil {
+			return err
+		}
+		return t.vector(2, 1) // Error
+	},
+	Ack: func(p []byte) error {
+		t, err := verifyRoot(p)
+		if err != nil {
+			return err
+		}
+		return t.tables(0, func(id table) error { return id.vector(0, 1) })
+	},
+	Hello: func(p []byte) error {
+		t, err := verifyRoot(p)
+		if err != nil {
+			return err
+		}
+		err = t.vector(0, 1) // ID
+		if err != nil {
+			return err
+		}
+		err = t.scalars(1, 2, 2, 2) // Version, MinVersion
+		if err != nil {
+			return err
+		}
+		err = t.vector(3, 2) // Kinds
+		if err != nil {
+			return err
+		}
+		err = t.strings(4) // Codecs
+		if err != nil {
+			return err
+		}
+		for slot := 5; slot < 9; slot++ { // MachineID, ProductUUID, MAC, Hostname
+			err = t.vector(slot, 1)
+			if err != nil {
+				return err
+			}
+		}
+		return t.strings(9) // Labels
+	},
+	CPUUtilization: func(p []byte) error { return verifyFlat(p, func() { cpuutilf.Deserialize(p) }) },
+	LoadAvg:        func(p []byte) error { return verifyFlat(p, func() { loadavgf.Deserialize(p) }) },
+	MemInfo:        func(p []byte) error { return verifyFlat(p, func() { memf.Deserialize(p) }) },
+	NetUsage:       func(p []byte) error { return verifyFlat(p, func() { netusagef.Deserialize(p) }) },
+}
+
+// verifyFlat verifies a joefriday flat payload.  Its schema isn't part of
+// autofact, so, after its root table has been bounds-checked, it is verified
+// by deserializing it with a recover: an out of range access is an error
+// instead of a panic.
+func verifyFlat(p []byte, deserialize func()) (err error) {
+	_, err = verifyRoot(p)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("deserialize: %v", r)
+		}
+	}()
+	deserialize()
+	return nil
+}
+
+// table is a bounds-checked flatbuffer table: its vtable and its inline data
+// are within buf.
+type table struct {
+	buf    []byte
+	pos    int // the start of the table
+	vt     int // the start of its vtable
+	vtLen  int
+	objLen int
+}
+
+// verifyRoot bounds-checks the root table of buf.
+func verifyRoot(buf []byte) (table, error) {
+	if len(buf) < flatbuffers.SizeUOffsetT {
+		return table{}, fmt.Errorf("%d bytes is too short", len(buf))
+	}
+	return verifyTable(buf, int(flatbuffers.GetUOffsetT(buf)))
+}
+
+// verifyTable bounds-checks the table at pos.
+func verifyTable(buf []byte, pos int) (table, error) {
+	if pos < 0 || pos+flatbuffers.SizeSOffsetT > len(buf) {
+		return table{}, fmt.Errorf("table offset %d out of range", pos)
+	}
+	t := table{buf: buf, pos: pos}
+	t.vt = pos - int(flatbuffers.GetSOffsetT(buf[pos:]))
+	if t.vt < 0 || t.vt+2*flatbuffers.SizeVOffsetT > len(buf) {
+		return table{}, fmt.Errorf("vtable offset %d out of range", t.vt)
+	}
+	t.vtLen = int(flatbuffers.GetVOffsetT(buf[t.vt:]))
+	t.objLen = int(flatbuffers.GetVOffsetT(buf[t.vt+flatbuffers.SizeVOffsetT:]))
+	if t.vtLen < 2*flatbuffers.SizeVOffsetT || t.vtLen%flatbuffers.SizeVOffsetT != 0 || t.vt+t.vtLen > len(buf) {
+		return table{}, fmt.Errorf("vtable length %d out of range", t.vtLen)
+	}
+	if t.objLen < flatbuffers.SizeSOffsetT || pos+t.objLen > len(buf) {
+		return table{}, fmt.Errorf("table length %d out of range", t.objLen)
+	}
+	return t, nil
+}
+
+// field returns the position of the field in the slot; 0 is returned if the
+// field isn't set.  The field's size bytes must be within the table.
+func (t table) field(slot, size int) (int, error) {
+	o := (2 + slot) * flatbuffers.SizeVOffsetT
+	if o+flatbuffers.SizeVOffsetT > t.vtLen {
+		return 0, nil
+	}
+	off := int(flatbuffers.GetVOffsetT(t.buf[t.vt+o:]))
+	if off == 0 {
+		return 0, nil
+	}
+	if off+size > t.objLen {
+		return 0, fmt.Errorf("field %d out of range", slot)
+	}
+	return t.pos + off, nil
+}
+
+// scalars bounds-checks scalar fields; the args are slot, size pairs.
+func (t table) scalars(args ...int) error {
+	for i := 0; i+1 < len(args); i += 2 {
+		_, err := t.field(args[i], args[i+1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vectorAt bounds-checks the vector referenced by the field in the slot and
+// returns the position of its first element and its length.
+func (t table) vectorAt(slot, elemSize int) (start, n int, err error) {
+	p, err := t.field(slot, flatbuffers.SizeUOffsetT)
+	if err != nil || p == 0 {
+		return 0, 0, err
+	}
+	v := p + int(flatbuffers.GetUOffsetT(t.buf[p:]))
+	if v+flatbuffers.SizeUOffsetT > len(t.buf) {
+		return 0, 0, fmt.Errorf("field %d: vector offset %d out of range", slot, v)
+	}
+	n = int(flatbuffers.GetUOffsetT(t.buf[v:]))
+	start = v + flatbuffers.SizeUOffsetT
+	if n < 0 || n > (len(t.buf)-start)/elemSize {
+		return 0, 0, fmt.Errorf("field %d: vector length %d out of range", slot, n)
+	}
+	return start, n, nil
+}
+
+// vector bounds-checks the vector, or string, in the slot.
+func (t table) vector(slot, elemSize int) error {
+	_, _, err := t.vectorAt(slot, elemSize)
+	return err
+}
+
+// strings bounds-checks the vector of strings in the slot.
+func (t table) strings(slot int) error {
+	start, n, err := t.vectorAt(slot, flatbuffers.SizeUOffsetT)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		p := start + i*flatbuffers.SizeUOffsetT
+		s := p + int(flatbuffers.GetUOffsetT(t.buf[p:]))
+		if s+flatbuffers.SizeUOffsetT > len(t.buf) || int(flatbuffers.GetUOffsetT(t.buf[s:])) > len(t.buf)-s-flatbuffers.SizeUOffsetT {
+			return fmt.Errorf("field %d: string %d out of range", slot, i)
+		}
+	}
+	return nil
+}
+
+// tables bounds-checks the vector of tables in the slot, verifying each
+// table with verify.
+func (t table) tables(slot int, verify func(table) error) error {
+	start, n, err := t.vectorAt(slot, flatbuffers.SizeUOffsetT)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		p := start + i*flatbuffers.SizeUOffsetT
+		tt, err := verifyTable(t.buf, p+int(flatbuffers.GetUOffsetT(t.buf[p:])))
+		if err != nil {
+			return fmt.Errorf("field %d: element %d: %s", slot, i, err)
+		}
+		err = verify(tt)
+		if err != nil {
+			return fmt.Errorf("field %d: element %d: %s", slot, i, err)
+		}
+	}
+	return nil
+}
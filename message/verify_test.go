@@ -0,0 +1,113 @@
+package message
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/mohae/autofact/command"
+	"github.com/mohae/autofact/conf"
+	"github.com/mohae/snoflinga"
+)
+
+// access reads every field of the message and its payload; it panics if the
+// message isn't safe to use.
+func access(p []byte) {
+	msg := GetRootAsMessage(p, 0)
+	msg.IDBytes()
+	msg.DstID()
+	msg.Type()
+	data := msg.DataBytes()
+	if len(data) == 0 {
+		return
+	}
+	switch Kind(msg.Kind()) {
+	case Command:
+		c := command.GetRootAsCommand(data, 0)
+		c.IDBytes()
+		c.Op()
+		c.Arg()
+	case CommandReply:
+		r := command.GetRootAsReply(data, 0)
+		r.IDBytes()
+		r.OK()
+		r.Error()
+	case ClientConf:
+		c := conf.GetRootAsClient(data, 0)
+		c.IDBytes()
+		c.Hostname()
+		c.Region()
+		c.Zone()
+		c.DataCenter()
+		c.HealthbeatPeriod()
+		c.MemInfoPeriod()
+		c.NetUsagePeriod()
+		c.CPUUtilizationPeriod()
+		c.HealthbeatPush()
+	case Ack:
+		AckIDs(data)
+	case Hello:
+		GetProtocol(data)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	var flake snoflinga.Flake
+	copy(flake[:], "abcdefghijklmnop")
+	msgs := [][]byte{
+		Serialize(flake, EOT, nil),
+		Serialize(flake, SysInfoJSON, []byte(`{"hostname":"test"}`)),
+		Serialize(flake, Command, command.Serialize([]byte("id"), command.Burst, "1s,10m")),
+		Serialize(flake, CommandReply, command.SerializeReply([]byte("id"), errors.New("unknown command"))),
+		Serialize(flake, Ack, SerializeAck([][]byte{[]byte("abcdefgh"), []byte("ijklmnop")})),
+		Serialize(flake, ClientConf, clientConf()),
+		Serialize(flake, Hello, (&Protocol{ID: []byte("id"), Version: 2, MinVersion: 1, Kinds: LegacyKinds, Codecs: CodecNames()}).Serialize()),
+	}
+	for i, p := range msgs {
+		err := Verify(p)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+	}
+
+	// Truncated and corrupted messages either fail verification or are safe
+	// to use.
+	verify := func(i int, p []byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("%d: verified message panicked: %v", i, r)
+			}
+		}()
+		if Verify(p) == nil {
+			access(p)
+		}
+	}
+	r := rand.New(rand.NewSource(1))
+	for i, p := range msgs {
+		for j := 0; j < len(p); j++ {
+			verify(i, p[:j])
+		}
+		for j := 0; j < 1000; j++ {
+			b := append([]byte(nil), p...)
+			b[r.Intn(len(b))] = byte(r.Intn(256))
+			verify(i, b)
+		}
+	}
+}
+
+func TestVerifyPayload(t *testing.T) {
+	p := clientConf()
+	err := VerifyPayload(ClientConf, p)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	err = VerifyPayload(ClientConf, p[:3])
+	if err == nil {
+		t.Error("truncated: expected an error; got none")
+	}
+	// kinds without a verifier aren't checked.
+	err = VerifyPayload(SysInfoJSON, []byte("{"))
+	if err != nil {
+		t.Errorf("SysInfoJSON: unexpected error: %s", err)
+	}
+}
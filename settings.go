@@ -13,6 +13,25 @@ var (
 
 	// WriteWait is the default time to wait for a write to succeed.
 	WriteWait = 5 * time.Second
+	// PongWait is how long to wait for a message, or a pong, from the other
+	// side of a connection before the connection is considered dead.
+	PongWait = 60 * time.Second
+	// PingPeriod is how often a ping is sent to the other side of a
+	// connection.  It must be less than PongWait.
+	PingPeriod = PongWait * 9 / 10
+)
+
+// HTTP transport.
+const (
+	// HTTPHelloPath is the path that clients using the HTTP transport send
+	// their Hello to.
+	HTTPHelloPath = "/http/hello"
+	// HTTPMessagesPath is the path that clients using the HTTP transport
+	// post their messages to.
+	HTTPMessagesPath = "/http/messages"
+	// HTTPClientHeader is the header with the client's ID in the requests of
+	// clients using the HTTP transport.
+	HTTPClientHeader = "X-Autofact-Client"
 )
 
 // Text Message stuff.